@@ -0,0 +1,521 @@
+package ecfg
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+	"github.com/Shopify/ecfg/pkg/format"
+	"github.com/Shopify/ecfg/pkg/yaml"
+)
+
+// Client performs ecfg operations with a particular configuration: where
+// documents and keys are read from, how private keys are found, where
+// randomness comes from, and which format handlers are used. The zero value
+// behaves like the package-level functions.
+//
+// A Client is safe for concurrent use as long as its fields aren't modified.
+type Client struct {
+	// FS is used to read and write documents and key files. If nil, the
+	// operating system's filesystem is used.
+	FS FS
+	// Getenv looks up the environment variables ecfg consults:
+	// ECFG_PRIVATE_KEY, HOME and XDG_CONFIG_HOME. If nil, os.Getenv is used.
+	Getenv func(key string) string
+	// Getuid returns the ID of the current user, which determines
+	// UserKeypath. If nil, os.Getuid is used.
+	Getuid func() int
+	// Keypath lists the directories searched for private keys. If nil,
+	// DefaultKeypath is used.
+	Keypath []string
+	// KeyProvider finds the private keys used for decryption. If nil, the
+	// key is taken from ECFG_PRIVATE_KEY or, failing that, from the file
+	// named after the public key in the first Keypath entry which has one.
+	KeyProvider KeyProvider
+	// Rand is the source of randomness for ephemeral keypairs and nonces. If
+	// nil, crypto/rand.Reader is used. If set, values are encrypted one at a
+	// time in document order, so that a deterministic Rand produces the same
+	// output every time.
+	Rand io.Reader
+	// Handlers overrides the handlers registered with format.Register for
+	// particular file types, e.g. to limit the number of workers they use.
+	Handlers map[FileType]format.FormatHandler
+	// KeepGoing makes decryption best-effort: values which can't be
+	// decrypted are left as written, or replaced with Placeholder if it's
+	// non-empty, and the rest of the document is decrypted regardless. The
+	// document is then returned along with a *PartialDecryptError listing
+	// every such value. Other errors, e.g. a malformed document or a missing
+	// private key, still abort decryption.
+	KeepGoing bool
+	// Placeholder replaces values which can't be decrypted if KeepGoing is
+	// set.
+	Placeholder string
+	// TrustedSigners, if non-empty, makes decryption refuse documents which
+	// haven't been signed by one of these keys, or have been changed since,
+	// as checked by VerifyData.
+	TrustedSigners []ed25519.PublicKey
+	// AllowUnencrypted makes decryption leave values which haven't been
+	// encrypted at all as they are, rather than failing. Documents produced
+	// by Marshal from values with fields tagged `ecfg:"secret"` have such
+	// values; Unmarshal always allows them.
+	AllowUnencrypted bool
+}
+
+// FS is the filesystem used by a Client. Its methods have the signatures of
+// those of fs.ReadFileFS and fs.StatFS, plus WriteFile, but take operating
+// system paths rather than paths relative to the root of the filesystem.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+	Stat(name string) (fs.FileInfo, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+}
+
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error)  { return ioutil.ReadFile(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return ioutil.WriteFile(name, data, perm)
+}
+
+// KeyProvider finds the private key corresponding to the public key embedded
+// in a document.
+type KeyProvider interface {
+	PrivateKey(ctx context.Context, pubkey [32]byte) ([32]byte, error)
+}
+
+// KeyProviderFunc adapts a function to a KeyProvider.
+type KeyProviderFunc func(ctx context.Context, pubkey [32]byte) ([32]byte, error)
+
+// PrivateKey returns f(ctx, pubkey).
+func (f KeyProviderFunc) PrivateKey(ctx context.Context, pubkey [32]byte) ([32]byte, error) {
+	return f(ctx, pubkey)
+}
+
+// defaultClient is used by the package-level functions.
+var defaultClient = &Client{}
+
+// keypathClient returns a Client which searches exactly keypath for private
+// keys, as the package-level functions taking a keypath do, configured by
+// opts.
+func keypathClient(keypath []string, opts []DecryptOption) *Client {
+	if keypath == nil {
+		keypath = []string{}
+	}
+	c := &Client{Keypath: keypath}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Client) fs() FS {
+	if c.FS == nil {
+		return osFS{}
+	}
+	return c.FS
+}
+
+func (c *Client) getenv(key string) string {
+	if c.Getenv == nil {
+		return os.Getenv(key)
+	}
+	return c.Getenv(key)
+}
+
+func (c *Client) getuid() int {
+	if c.Getuid == nil {
+		return os.Getuid()
+	}
+	return c.Getuid()
+}
+
+// GenerateKeypair is like the package-level GenerateKeypair, using c.Rand.
+func (c *Client) GenerateKeypair() (pub string, priv string, err error) {
+	var kp crypto.Keypair
+	if err := kp.GenerateFrom(c.Rand); err != nil {
+		return "", "", err
+	}
+	return kp.PublicString(), kp.PrivateString(), nil
+}
+
+// GenerateHybridKeypair is like the package-level GenerateHybridKeypair,
+// using c.Rand.
+func (c *Client) GenerateHybridKeypair() (pub string, priv string, err error) {
+	var kp crypto.HybridKeypair
+	if err := kp.GenerateFrom(c.Rand); err != nil {
+		return "", "", err
+	}
+	return kp.PublicString(), kp.PrivateString(), nil
+}
+
+// EncryptFileInPlace is like the package-level EncryptFileInPlaceContext.
+func (c *Client) EncryptFileInPlace(ctx context.Context, filePath string, fileType FileType) (int, error) {
+	data, err := c.fs().ReadFile(filePath)
+	if err != nil {
+		return -1, err
+	}
+
+	fi, err := c.fs().Stat(filePath)
+	if err != nil {
+		return -1, err
+	}
+
+	newdata, err := c.EncryptData(ctx, data, fileType)
+	if err != nil {
+		return -1, withFile(err, filePath)
+	}
+
+	if err := c.fs().WriteFile(filePath, newdata, fi.Mode()); err != nil {
+		return -1, err
+	}
+
+	return len(newdata), nil
+}
+
+// EncryptData is like the package-level EncryptDataContext.
+func (c *Client) EncryptData(ctx context.Context, data []byte, fileType FileType) ([]byte, error) {
+	fh, err := c.handlerForDocument(fileType, data)
+	if err != nil {
+		return nil, err
+	}
+	fh = c.encryptHandler(fh)
+
+	pubkey, err := extractPublicKey(fh, data)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypter, err := c.encrypter(pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	return transformContext(ctx, fh, data, encrypter.EncryptBound)
+}
+
+// DecryptFile is like the package-level DecryptFileContext, searching for
+// the private key as described on Client.
+func (c *Client) DecryptFile(ctx context.Context, filePath string, fileType FileType) ([]byte, error) {
+	data, err := c.fs().ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := c.DecryptData(ctx, data, fileType)
+	return decrypted, withFile(err, filePath)
+}
+
+// DecryptData is like the package-level DecryptDataContext, searching for
+// the private key as described on Client.
+func (c *Client) DecryptData(ctx context.Context, data []byte, fileType FileType) ([]byte, error) {
+	if err := c.verifyForDecryption(ctx, data, fileType); err != nil {
+		return nil, err
+	}
+
+	fh, err := c.handlerForDocument(fileType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := extractPublicKey(fh, data)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypter, err := c.decrypter(ctx, pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.KeepGoing {
+		return c.decryptBestEffort(ctx, fh, data, decrypter)
+	}
+	return transformContext(ctx, fh, data, decrypter.DecryptBound)
+}
+
+// decryptBestEffort decrypts data as DecryptData does if c.KeepGoing is set.
+// Failures are located by key path and position if fh is a
+// format.ScalarValueWalker, and only counted otherwise.
+func (c *Client) decryptBestEffort(ctx context.Context, fh format.FormatHandler, data []byte, decrypter *crypto.Decrypter) ([]byte, error) {
+	var failures decryptFailures
+	var out []byte
+	var err error
+	if walker, ok := fh.(format.ScalarValueWalker); ok {
+		out, err = walker.WalkScalarValues(data, func(v format.ScalarValue) ([]byte, error) {
+			return failures.visit(ctx, decrypter, c.Placeholder, v)
+		})
+		err = contextErr(ctx, err)
+	} else {
+		out, err = transformContext(ctx, fh, data, func(bs, _ []byte) ([]byte, error) {
+			out, err := failures.visit(ctx, decrypter, c.Placeholder, format.ScalarValue{Value: bs})
+			if out == nil && err == nil {
+				out = bs
+			}
+			return out, err
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, failures.err()
+}
+
+// decryptFailures collects the values which best-effort decryption couldn't
+// decrypt. Values may be visited concurrently.
+type decryptFailures struct {
+	mu   sync.Mutex
+	errs []*ValueError
+}
+
+// visit decrypts v with decrypter. If that fails, the failure is recorded and
+// v is replaced with placeholder or, if it's empty, left as written.
+func (f *decryptFailures) visit(ctx context.Context, decrypter *crypto.Decrypter, placeholder string, v format.ScalarValue) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	out, err := decrypter.DecryptBound(v.Value, binding(v.Path))
+	if err == nil {
+		if out == nil {
+			out = []byte{} // nil would leave the value unchanged
+		}
+		return out, nil
+	}
+
+	f.mu.Lock()
+	f.errs = append(f.errs, &ValueError{Path: v.Path, Line: v.Line, Column: v.Column, Err: err})
+	f.mu.Unlock()
+	if placeholder == "" {
+		return nil, nil
+	}
+	return []byte(placeholder), nil
+}
+
+// err returns a *PartialDecryptError listing the failures in document order,
+// or nil if there were none.
+func (f *decryptFailures) err() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	sort.SliceStable(f.errs, func(i, j int) bool {
+		a, b := f.errs[i], f.errs[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	return &PartialDecryptError{Errors: f.errs}
+}
+
+// DefaultKeypath is like the package-level DefaultKeypath, using c.Getuid
+// and c.Getenv.
+func (c *Client) DefaultKeypath() (keypath []string) {
+	keypath = append(keypath, c.UserKeypath()...)
+	keypath = append(keypath, SystemKeypath()...)
+	return
+}
+
+// UserKeypath is like the package-level UserKeypath, using c.Getuid and
+// c.Getenv.
+func (c *Client) UserKeypath() (keypath []string) {
+	// no user keypath entries for root.
+	if c.getuid() == 0 {
+		return
+	}
+
+	xdgConfigHome := c.getenv("XDG_CONFIG_HOME")
+	if xdgConfigHome != "" {
+		keypath = append(keypath, filepath.Join(xdgConfigHome, "ecfg", "keys"))
+	}
+	keypath = append(keypath, filepath.Join(c.getenv("HOME"), ".ecfg", "keys"))
+	return
+}
+
+func (c *Client) keypath() []string {
+	if c.Keypath == nil {
+		return c.DefaultKeypath()
+	}
+	return c.Keypath
+}
+
+// publicKey is the public key of a document: a Curve25519 key or, if hybrid
+// is set, a hybrid key whose X25519 component is id. Private keys are found
+// by id.
+type publicKey struct {
+	id     [32]byte
+	hybrid *crypto.HybridPublicKey
+}
+
+// extractPublicKey returns the public key of data. Hybrid keys are only
+// recognised by handlers which implement format.PublicKeyStringExtractor.
+func extractPublicKey(fh format.FormatHandler, data []byte) (publicKey, error) {
+	if ex, ok := fh.(format.PublicKeyStringExtractor); ok {
+		s, err := ex.ExtractPublicKeyString(data)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return parsePublicKey(s)
+	}
+	id, err := fh.ExtractPublicKey(data)
+	return publicKey{id: id}, err
+}
+
+// parsePublicKey parses s, the value of a document's PublicKeyField.
+func parsePublicKey(s string) (publicKey, error) {
+	if strings.HasPrefix(s, crypto.HybridKeyPrefix) {
+		hybrid, err := crypto.ParseHybridPublicKey(s)
+		if err != nil {
+			return publicKey{}, format.ErrPublicKeyInvalid
+		}
+		return publicKey{id: hybrid.X25519, hybrid: hybrid}, nil
+	}
+	id, err := format.ExtractPublicKeyHelper(map[string]interface{}{format.PublicKeyField: s})
+	return publicKey{id: id}, err
+}
+
+// bytes returns the raw key: the Curve25519 key, or the X25519 component and
+// ML-KEM-768 encapsulation key of a hybrid key.
+func (k publicKey) bytes() []byte {
+	if k.hybrid != nil {
+		return k.hybrid.Bytes()
+	}
+	return k.id[:]
+}
+
+// encrypter returns an Encrypter to pubkey from a new ephemeral keypair.
+// Values may be encrypted concurrently, so reads from c.Rand are serialized.
+// Hybrid encryption also reads from crypto/rand, so c.Rand doesn't make its
+// output reproducible.
+func (c *Client) encrypter(pubkey publicKey) (*crypto.Encrypter, error) {
+	var r io.Reader
+	if c.Rand != nil {
+		r = &lockedReader{r: c.Rand}
+	}
+	var myKP crypto.Keypair
+	if err := myKP.GenerateFrom(r); err != nil {
+		return nil, err
+	}
+	var encrypter *crypto.Encrypter
+	if pubkey.hybrid != nil {
+		encrypter = myKP.HybridEncrypter(pubkey.hybrid)
+	} else {
+		encrypter = myKP.Encrypter(pubkey.id)
+	}
+	encrypter.Rand = r
+	return encrypter, nil
+}
+
+type lockedReader struct {
+	mu sync.Mutex
+	r  io.Reader
+}
+
+func (lr *lockedReader) Read(p []byte) (int, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return lr.r.Read(p)
+}
+
+// decrypter returns a Decrypter for documents encrypted to pubkey. The
+// private key of a hybrid key is the seed from which both of its components
+// are derived.
+func (c *Client) decrypter(ctx context.Context, pubkey publicKey) (*crypto.Decrypter, error) {
+	privkey, err := c.privateKey(ctx, pubkey.id)
+	if err != nil {
+		return nil, err
+	}
+
+	if pubkey.hybrid != nil {
+		var hybridKP crypto.HybridKeypair
+		if err := hybridKP.SetSeed(privkey); err != nil {
+			return nil, err
+		}
+		if hybridKP.PublicString() != pubkey.hybrid.String() {
+			return nil, fmt.Errorf("private key doesn't match hybrid public key")
+		}
+		decrypter := hybridKP.Decrypter()
+		decrypter.AllowUnencrypted = c.AllowUnencrypted
+		return decrypter, nil
+	}
+
+	myKP := crypto.Keypair{
+		Public:  pubkey.id,
+		Private: privkey,
+	}
+
+	decrypter := myKP.Decrypter()
+	decrypter.AllowUnencrypted = c.AllowUnencrypted
+	return decrypter, nil
+}
+
+func (c *Client) privateKey(ctx context.Context, pubkey [32]byte) (privkey [32]byte, err error) {
+	if c.KeyProvider != nil {
+		return c.KeyProvider.PrivateKey(ctx, pubkey)
+	}
+
+	keyString := c.getenv("ECFG_PRIVATE_KEY")
+	if keyString == "" {
+		for _, keydir := range c.keypath() {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			keyFile := fmt.Sprintf("%s/%x", keydir, pubkey)
+			fileContents, err := c.fs().ReadFile(keyFile)
+			if err == nil {
+				keyString = strings.TrimSpace(string(fileContents))
+				break
+			}
+		}
+	}
+	if keyString == "" {
+		err = fmt.Errorf("private key not found in keypath")
+		return
+	}
+
+	bs, err := hex.DecodeString(keyString)
+	if err != nil {
+		return
+	}
+
+	if len(bs) != 32 {
+		err = fmt.Errorf("invalid private key retrieved from keydir")
+		return
+	}
+
+	copy(privkey[:], bs)
+	return
+}
+
+// handlerForDocument returns the handler for typ, except that YAML documents
+// which are Kubernetes Secret manifests are handled as such.
+func (c *Client) handlerForDocument(typ FileType, data []byte) (format.FormatHandler, error) {
+	if typ == FileTypeYAML && yaml.IsKubernetesSecret(data) {
+		typ = FileTypeK8sSecret
+	}
+	return c.handler(typ)
+}
+
+// encryptHandler returns fh for use in encryption. If c.Rand is set, it's
+// limited to a single worker, so that c.Rand is read in document order.
+func (c *Client) encryptHandler(fh format.FormatHandler) format.FormatHandler {
+	if ch, ok := fh.(format.ConcurrentHandler); ok && c.Rand != nil {
+		return ch.WithWorkers(1)
+	}
+	return fh
+}
+
+// handler returns the handler for typ from c.Handlers or, failing that, the
+// format registry.
+func (c *Client) handler(typ FileType) (format.FormatHandler, error) {
+	if fh, ok := c.Handlers[typ]; ok {
+		return fh, nil
+	}
+	return format.Lookup(string(typ))
+}
@@ -0,0 +1,204 @@
+package ecfg
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/ecfg/pkg/format"
+	"github.com/Shopify/ecfg/pkg/json"
+)
+
+// memFS is an in-memory FS, keyed by path. Every file has mode 0400.
+type memFS map[string][]byte
+
+func (m memFS) ReadFile(name string) ([]byte, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return data, nil
+}
+
+func (m memFS) Stat(name string) (fs.FileInfo, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memFileInfo{name: name, size: int64(len(data))}, nil
+}
+
+func (m memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m[name] = append([]byte(nil), data...)
+	return nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return 0400 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
+
+// zeroReader is a source of "randomness" which only returns zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// countingHandler counts calls to TransformScalarValues.
+type countingHandler struct {
+	format.FormatHandler
+	calls int
+}
+
+func (h *countingHandler) TransformScalarValues(data []byte, action func([]byte) ([]byte, error)) ([]byte, error) {
+	h.calls++
+	return h.FormatHandler.TransformScalarValues(data, action)
+}
+
+func TestClientKeyProvider(t *testing.T) {
+	doc := []byte(`{"_public_key": "` + testPublicKey + `", "a": "b"}`)
+	encrypted, err := EncryptData(doc, FileTypeJSON)
+	assertNoError(t, err)
+
+	var requested [32]byte
+	c := &Client{
+		Keypath: []string{"/does/not/exist"},
+		KeyProvider: KeyProviderFunc(func(ctx context.Context, pubkey [32]byte) (privkey [32]byte, err error) {
+			requested = pubkey
+			_, err = hex.Decode(privkey[:], []byte(testPrivateKey))
+			return
+		}),
+	}
+	out, err := c.DecryptData(context.Background(), encrypted, FileTypeJSON)
+	assertNoError(t, err)
+	if !bytes.Equal(out, doc) {
+		t.Errorf("unexpected output: %s", out)
+	}
+	if hex.EncodeToString(requested[:]) != testPublicKey {
+		t.Errorf("KeyProvider called with unexpected public key %x", requested)
+	}
+
+	providerErr := errors.New("no key for you")
+	c.KeyProvider = KeyProviderFunc(func(context.Context, [32]byte) ([32]byte, error) {
+		return [32]byte{}, providerErr
+	})
+	if _, err := c.DecryptData(context.Background(), encrypted, FileTypeJSON); err != providerErr {
+		t.Errorf("expected KeyProvider's error, got %v", err)
+	}
+}
+
+func TestClientHandlers(t *testing.T) {
+	h := &countingHandler{FormatHandler: &json.FormatHandler{Workers: 1}}
+	c := &Client{Handlers: map[FileType]format.FormatHandler{FileTypeJSON: h}}
+	_, err := c.EncryptData(context.Background(), []byte(`{"_public_key": "`+testPublicKey+`", "a": "b"}`), FileTypeJSON)
+	assertNoError(t, err)
+	if h.calls != 1 {
+		t.Errorf("expected the overriding handler to be used once, but it was used %d times", h.calls)
+	}
+}
+
+func TestClientRand(t *testing.T) {
+	doc := []byte(`{"_public_key": "` + testPublicKey + `", "a": "b"}`)
+	c := &Client{Rand: zeroReader{}}
+	out1, err := c.EncryptData(context.Background(), doc, FileTypeJSON)
+	assertNoError(t, err)
+	out2, err := c.EncryptData(context.Background(), doc, FileTypeJSON)
+	assertNoError(t, err)
+	if !bytes.Equal(out1, out2) {
+		t.Errorf("expected identical output from identical randomness:\n%s\n%s", out1, out2)
+	}
+
+	out3, err := EncryptData(doc, FileTypeJSON)
+	assertNoError(t, err)
+	if bytes.Equal(out1, out3) {
+		t.Errorf("expected the default client not to use c.Rand")
+	}
+}
+
+// countingReader is a source of "randomness" which returns successive bytes,
+// so that the output depends on the order in which it's read.
+type countingReader struct{ n byte }
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = r.n
+		r.n++
+	}
+	return len(p), nil
+}
+
+func TestClientRandOrder(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	var jsonDoc, yamlDoc, tomlDoc strings.Builder
+	jsonDoc.WriteString(`{"_public_key": "` + testPublicKey + `"`)
+	yamlDoc.WriteString("_public_key: " + testPublicKey + "\n")
+	tomlDoc.WriteString("_public_key = \"" + testPublicKey + "\"\n")
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&jsonDoc, `, "k%d": "v%d"`, i, i)
+		fmt.Fprintf(&yamlDoc, "k%d: v%d\n", i, i)
+		fmt.Fprintf(&tomlDoc, "k%d = \"v%d\"\n", i, i)
+	}
+	jsonDoc.WriteString("}")
+	docs := map[FileType]string{FileTypeJSON: jsonDoc.String(), FileTypeYAML: yamlDoc.String(), FileTypeTOML: tomlDoc.String()}
+
+	for fileType, doc := range docs {
+		var outputs [][]byte
+		for i := 0; i < 3; i++ {
+			out, err := EncryptData([]byte(doc), fileType, WithRand(&countingReader{}))
+			assertNoError(t, err)
+			outputs = append(outputs, out)
+		}
+		if !bytes.Equal(outputs[0], outputs[1]) || !bytes.Equal(outputs[0], outputs[2]) {
+			t.Errorf("%s: expected identical output from identical randomness", fileType)
+		}
+		decrypted, err := DecryptData(outputs[0], nil, fileType)
+		assertNoError(t, err)
+		if !bytes.Contains(decrypted, []byte("v199")) {
+			t.Errorf("%s: unexpected decrypted document:\n%s", fileType, decrypted)
+		}
+	}
+}
+
+func TestClientGetenv(t *testing.T) {
+	doc := []byte(`{"_public_key": "` + testPublicKey + `", "a": "b"}`)
+	encrypted, err := EncryptData(doc, FileTypeJSON)
+	assertNoError(t, err)
+
+	c := &Client{
+		Keypath: []string{},
+		Getenv: func(k string) string {
+			if k == "ECFG_PRIVATE_KEY" {
+				return testPrivateKey
+			}
+			return ""
+		},
+	}
+	out, err := c.DecryptData(context.Background(), encrypted, FileTypeJSON)
+	assertNoError(t, err)
+	if !bytes.Equal(out, doc) {
+		t.Errorf("unexpected output: %s", out)
+	}
+
+	c.Getenv = func(string) string { return "" }
+	_, err = c.DecryptData(context.Background(), encrypted, FileTypeJSON)
+	if err == nil || !strings.Contains(err.Error(), "private key not found") {
+		t.Errorf("wanted key not found error, but got %v", err)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"syscall"
@@ -11,18 +15,12 @@ import (
 
 const access_W_OK = 0x02
 
-func encryptAction(filePath string, ftype ecfg.FileType) error {
+// encryptAction encrypts the file at filePath in place or, if filePath is
+// empty, writes the encrypted form of stdin to stdout. If stdin has already
+// been read, its contents are passed in.
+func encryptAction(filePath string, stdin []byte, ftype ecfg.FileType) error {
 	if filePath == "" { // read from stdin, write to stdout
-		data, err := ioutil.ReadAll(os.Stdin)
-		if err != nil {
-			return err
-		}
-		out, err := ecfg.EncryptData(data, ftype)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("%s", string(out))
-		return nil
+		return ecfg.EncryptStream(stdinReader(stdin), os.Stdout, ecfg.StreamOptions{FileType: ftype})
 	}
 	n, err := ecfg.EncryptFileInPlace(filePath, ftype)
 	if err != nil {
@@ -32,30 +30,64 @@ func encryptAction(filePath string, ftype ecfg.FileType) error {
 	return nil
 }
 
-func decryptAction(filePath string, keydir, outFile string, ftype ecfg.FileType) error {
+// upgradeAction upgrades the values of each file in filePaths in place,
+// reporting how many were upgraded in each. It stops at the first file which
+// can't be upgraded.
+func upgradeAction(filePaths []string, keydir, typeArg string, toVersion int) error {
 	keypath := ecfg.DefaultKeypath()
 	if keydir != "" {
 		keypath = []string{keydir}
 	}
-
-	if filePath == "" { // read from stdin, write to stdout
-		data, err := ioutil.ReadAll(os.Stdin)
+	for _, filePath := range filePaths {
+		fileType, err := determineFileType(typeArg, filePath, nil)
 		if err != nil {
 			return err
 		}
-		out, err := ecfg.DecryptData(data, keypath, ftype)
+		n, err := ecfg.UpgradeFileInPlace(filePath, keypath, fileType, toVersion)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("%s", string(out))
-		return nil
+		if n == 1 {
+			fmt.Printf("Upgraded 1 value in %s.\n", filePath)
+		} else {
+			fmt.Printf("Upgraded %d values in %s.\n", n, filePath)
+		}
 	}
+	return nil
+}
 
-	decrypted, err := ecfg.DecryptFile(filePath, keypath, ftype)
-	if err != nil {
-		return err
+// decryptAction decrypts the file at filePath or, if filePath is empty, stdin.
+// If stdin has already been read, its contents are passed in. opts gives the
+// file type and the decryption options; its Keypath is set from keydir. If
+// opts.KeepGoing is set, the output is written before any
+// *ecfg.PartialDecryptError is returned.
+func decryptAction(filePath string, stdin []byte, keydir, outFile string, opts ecfg.StreamOptions) error {
+	opts.Keypath = ecfg.DefaultKeypath()
+	if keydir != "" {
+		opts.Keypath = []string{keydir}
 	}
 
+	if filePath == "" { // read from stdin, write to stdout
+		return ecfg.DecryptStream(stdinReader(stdin), os.Stdout, opts)
+	}
+
+	var decryptOpts []ecfg.DecryptOption
+	if opts.KeepGoing {
+		decryptOpts = append(decryptOpts, ecfg.KeepGoing(opts.Placeholder))
+	}
+	if len(opts.TrustedSigners) > 0 {
+		decryptOpts = append(decryptOpts, ecfg.RequireSignature(opts.TrustedSigners...))
+	}
+	if opts.AllowUnencrypted {
+		decryptOpts = append(decryptOpts, ecfg.AllowUnencrypted())
+	}
+	decrypted, decryptErr := ecfg.DecryptFile(filePath, opts.Keypath, opts.FileType, decryptOpts...)
+	var partialErr *ecfg.PartialDecryptError
+	if decryptErr != nil && !errors.As(decryptErr, &partialErr) {
+		return decryptErr
+	}
+
+	var err error
 	target := os.Stdout
 	if outFile != "" {
 		target, err = os.Create(outFile)
@@ -65,12 +97,127 @@ func decryptAction(filePath string, keydir, outFile string, ftype ecfg.FileType)
 		defer func() { _ = target.Close() }()
 	}
 
-	_, err = target.Write(decrypted)
-	return err
+	if _, err = target.Write(decrypted); err != nil {
+		return err
+	}
+	return decryptErr
+}
+
+// signAction signs the file at filePath in place or, if filePath is empty,
+// writes the signed form of stdin to stdout. The signing key is read from
+// keyFile or, if that's empty, ECFG_SIGNING_KEY.
+func signAction(filePath string, stdin []byte, keyFile string, ftype ecfg.FileType) error {
+	keyString := os.Getenv("ECFG_SIGNING_KEY")
+	if keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		keyString = string(data)
+	}
+	if keyString == "" {
+		return errors.New("no signing key: pass --key or set ECFG_SIGNING_KEY")
+	}
+	key, err := ecfg.ParseSigningKey(keyString)
+	if err != nil {
+		return err
+	}
+
+	if filePath == "" { // read from stdin, write to stdout
+		data, err := ioutil.ReadAll(stdinReader(stdin))
+		if err != nil {
+			return err
+		}
+		signed, err := ecfg.SignData(data, ftype, key)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(signed)
+		return err
+	}
+	n, err := ecfg.SignFileInPlace(filePath, ftype, key)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d bytes to %s.\n", n, filePath)
+	return nil
+}
+
+// verifyAction checks that the file at filePath or, if filePath is empty,
+// stdin has been signed by one of the keys listed in signersFile.
+func verifyAction(filePath string, stdin []byte, signersFile string, ftype ecfg.FileType) error {
+	if signersFile == "" {
+		return errors.New("--trusted-signers is required")
+	}
+	trusted, err := readTrustedSigners(signersFile)
+	if err != nil {
+		return err
+	}
+
+	var signer ed25519.PublicKey
+	if filePath == "" {
+		data, err := ioutil.ReadAll(stdinReader(stdin))
+		if err != nil {
+			return err
+		}
+		signer, err = ecfg.VerifyData(data, ftype, trusted)
+		if err != nil {
+			return err
+		}
+	} else if signer, err = ecfg.VerifyFile(filePath, ftype, trusted); err != nil {
+		return err
+	}
+	fmt.Printf("Signed by %x.\n", []byte(signer))
+	return nil
 }
 
-func keygenAction(args []string, keydir string, wFlag bool) error {
-	pub, priv, err := ecfg.GenerateKeypair()
+// readTrustedSigners reads the list of trusted signing keys in path, one per
+// line.
+func readTrustedSigners(path string) ([]ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ecfg.ParseTrustedSigners(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no trusted signers listed", path)
+	}
+	return keys, nil
+}
+
+// stdinReader returns a reader over the contents of stdin, which have already
+// been read if stdin is non-nil.
+func stdinReader(stdin []byte) io.Reader {
+	if stdin != nil {
+		return bytes.NewReader(stdin)
+	}
+	return os.Stdin
+}
+
+func keygenAction(args []string, keydir string, wFlag, signing, hybrid bool) error {
+	if signing && hybrid {
+		return errors.New("--signing and --hybrid can't be combined")
+	}
+	if signing {
+		if wFlag {
+			return errors.New("signing keys can't be written to the keydir")
+		}
+		pub, priv, err := ecfg.GenerateSigningKeypair()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Public Key:\n%s\nPrivate Key:\n%s\n", pub, priv)
+		return nil
+	}
+
+	generate := ecfg.GenerateKeypair
+	if hybrid {
+		generate = ecfg.GenerateHybridKeypair
+	}
+	pub, priv, err := generate()
 	if err != nil {
 		return err
 	}
@@ -95,7 +242,11 @@ func keygenAction(args []string, keydir string, wFlag bool) error {
 			ecfg.DefaultKeypath()[0])
 	}
 
-	keyFile := fmt.Sprintf("%s/%s", keydir, pub)
+	id, err := ecfg.PublicKeyID(pub)
+	if err != nil {
+		return err
+	}
+	keyFile := fmt.Sprintf("%s/%s", keydir, id)
 	err = writeFile(keyFile, []byte(priv), 0440)
 	if err != nil {
 		return err
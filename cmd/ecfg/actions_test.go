@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/Shopify/ecfg"
+	"github.com/urfave/cli"
+)
+
+const (
+	testPublicKey  = "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d"
+	testPrivateKey = "c5caa31a5b8cb2be0074b37c56775f533b368b81d8fd33b94181f79bd6e47f87"
+)
+
+// captureStdout runs fn with os.Stdout redirected to a file, returning what
+// was written to it.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	stdout := os.Stdout
+	os.Stdout = f
+	err = fn()
+	os.Stdout = stdout
+
+	out, readErr := ioutil.ReadFile(f.Name())
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	return string(out), err
+}
+
+func TestStdinLatePublicKey(t *testing.T) {
+	keydir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(keydir, testPublicKey), []byte(testPrivateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	in := `{"API_KEY":"secret","_public_key":"` + testPublicKey + `"}`
+
+	for _, fileType := range []ecfg.FileType{ecfg.FileTypeJSON, ecfg.FileTypeYAML} {
+		encrypted, err := captureStdout(t, func() error {
+			return encryptAction("", []byte(in), fileType)
+		})
+		if err != nil || !regexp.MustCompile(`"API_KEY": ?"EJ\[2:.*\]"`).MatchString(encrypted) {
+			t.Errorf("%s: unexpected encrypt output: %q, %v", fileType, encrypted, err)
+			continue
+		}
+
+		decrypted, err := captureStdout(t, func() error {
+			return decryptAction("", []byte(encrypted), keydir, "", ecfg.StreamOptions{FileType: fileType})
+		})
+		if err != nil || !regexp.MustCompile(`"API_KEY": ?"secret"`).MatchString(decrypted) {
+			t.Errorf("%s: unexpected decrypt output: %q, %v", fileType, decrypted, err)
+		}
+	}
+}
+
+func TestStdinErrorWritesNothing(t *testing.T) {
+	out, err := captureStdout(t, func() error {
+		return encryptAction("", []byte(`{"API_KEY":"secret","_public_key":"invalid"}`), ecfg.FileTypeJSON)
+	})
+	if err == nil || out != "" {
+		t.Errorf("expected an error and no output, got %q, %v", out, err)
+	}
+
+	out, err = captureStdout(t, func() error {
+		in := `{"API_KEY":"EJ[1:x]","_public_key":"` + testPublicKey + `"}`
+		return decryptAction("", []byte(in), t.TempDir(), "", ecfg.StreamOptions{FileType: ecfg.FileTypeJSON})
+	})
+	if err == nil || out != "" {
+		t.Errorf("expected an error and no output, got %q, %v", out, err)
+	}
+}
+
+func TestDecryptAllowUnencrypted(t *testing.T) {
+	keydir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(keydir, testPublicKey), []byte(testPrivateKey), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "a.ecfg.json")
+	if err := ioutil.WriteFile(path, []byte(`{"_public_key":"`+testPublicKey+`","a":"plain"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := ecfg.StreamOptions{FileType: ecfg.FileTypeJSON}
+	if _, err := captureStdout(t, func() error { return decryptAction(path, nil, keydir, "", opts) }); err == nil {
+		t.Errorf("expected an error decrypting an unencrypted value")
+	}
+
+	opts.AllowUnencrypted = true
+	out, err := captureStdout(t, func() error { return decryptAction(path, nil, keydir, "", opts) })
+	if err != nil || !regexp.MustCompile(`"a": ?"plain"`).MatchString(out) {
+		t.Errorf("unexpected output: %q, %v", out, err)
+	}
+}
+
+func TestDecryptOptions(t *testing.T) {
+	for _, tc := range []struct {
+		args  []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"--keep-going"}, true},
+		{[]string{"--keep-going", "--placeholder", "?"}, true},
+		{[]string{"--placeholder", "?"}, false},
+		{[]string{"--placeholder", ""}, false},
+	} {
+		set := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+		set.Bool("keep-going", false, "")
+		set.String("placeholder", "", "")
+		set.Bool("allow-unencrypted", false, "")
+		set.String("trusted-signers", "", "")
+		if err := set.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		_, err := decryptOptions(cli.NewContext(nil, set, nil))
+		if tc.valid != (err == nil) {
+			t.Errorf("%q: unexpected error: %v", tc.args, err)
+		}
+	}
+}
@@ -4,17 +4,23 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strings"
 	"syscall"
 
 	"github.com/Shopify/ecfg"
+	"github.com/Shopify/ecfg/pkg/format"
 	"github.com/urfave/cli"
 )
 
 var version string // set by Makefile via LDFLAGS
 
+// exitPartialDecrypt is the exit status of `ecfg decrypt --keep-going` when
+// some values couldn't be decrypted.
+const exitPartialDecrypt = 2
+
 func execManpage(sec, page string) {
 	if err := syscall.Exec("/usr/bin/env", []string{"/usr/bin/env", "man", sec, page}, os.Environ()); err != nil {
 		fmt.Println("Exec error:", err)
@@ -30,7 +36,7 @@ func main() {
 	cli.HelpPrinter = func(w io.Writer, templ string, data interface{}) {
 		if cmd, ok := data.(cli.Command); ok {
 			switch cmd.Name {
-			case "encrypt", "decrypt", "keygen":
+			case "encrypt", "decrypt", "keygen", "sign", "verify", "upgrade":
 				execManpage("1", "ecfg-"+cmd.Name)
 			}
 		}
@@ -58,23 +64,15 @@ func main() {
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "type, t",
-					Usage: "Specify the filetype (json, yaml, or toml)",
+					Usage: "Specify the filetype (json, jsonc, yaml, toml, hcl, ini, properties, k8s-secret, ...)",
 				},
 			},
 			Action: func(c *cli.Context) error {
-				args := c.Args()
-				if len(args) > 1 {
-					return errors.New("ecfg decrypt only operates on one file at a time")
-				}
-				firstArg := ""
-				if len(args) == 1 {
-					firstArg = args[0]
-				}
-				fileType, err := determineFileType(c.String("t"), firstArg)
+				firstArg, input, fileType, err := singleFileArgs(c, "encrypt")
 				if err != nil {
 					return err
 				}
-				return encryptAction(firstArg, fileType)
+				return encryptAction(firstArg, input, fileType)
 			},
 		},
 		{
@@ -88,23 +86,41 @@ func main() {
 				},
 				cli.StringFlag{
 					Name:  "type, t",
-					Usage: "Specify the filetype (json, yaml, or toml)",
+					Usage: "Specify the filetype (json, jsonc, yaml, toml, hcl, ini, properties, k8s-secret, ...)",
+				},
+				cli.BoolFlag{
+					Name:  "keep-going",
+					Usage: "leave values which can't be decrypted as they are, and report them all at the end",
+				},
+				cli.StringFlag{
+					Name:  "placeholder",
+					Usage: "with --keep-going, replace values which can't be decrypted with the given string",
+				},
+				cli.StringFlag{
+					Name:  "trusted-signers",
+					Usage: "refuse to decrypt unless the file is signed by one of the keys listed in the given file",
+				},
+				cli.BoolFlag{
+					Name:  "allow-unencrypted",
+					Usage: "print values which haven't been encrypted as they are, rather than failing",
 				},
 			},
 			Action: func(c *cli.Context) error {
-				args := c.Args()
-				if len(args) > 1 {
-					return errors.New("ecfg decrypt only operates on one file at a time")
-				}
-				firstArg := ""
-				if len(args) == 1 {
-					firstArg = args[0]
+				opts, err := decryptOptions(c)
+				if err != nil {
+					return err
 				}
-				fileType, err := determineFileType(c.String("t"), firstArg)
+				firstArg, input, fileType, err := singleFileArgs(c, "decrypt")
 				if err != nil {
 					return err
 				}
-				return decryptAction(firstArg, c.GlobalString("keydir"), c.String("o"), fileType)
+				opts.FileType = fileType
+				err = decryptAction(firstArg, input, c.GlobalString("keydir"), c.String("o"), opts)
+				var partialErr *ecfg.PartialDecryptError
+				if errors.As(err, &partialErr) {
+					return cli.NewExitError(partialDecryptSummary(partialErr), exitPartialDecrypt)
+				}
+				return err
 			},
 		},
 		{
@@ -116,9 +132,79 @@ func main() {
 					Name:  "write, w",
 					Usage: "rather than printing both keys, print the public and write the private into the keydir",
 				},
+				cli.BoolFlag{
+					Name:  "signing",
+					Usage: "generate an ed25519 keypair for signing files, rather than an encryption keypair",
+				},
+				cli.BoolFlag{
+					Name:  "hybrid",
+					Usage: "generate a hybrid X25519 + ML-KEM-768 keypair, resistant to quantum attacks",
+				},
 			},
 			Action: func(c *cli.Context) error {
-				return keygenAction(c.Args(), c.GlobalString("keydir"), c.Bool("write"))
+				return keygenAction(c.Args(), c.GlobalString("keydir"), c.Bool("write"), c.Bool("signing"), c.Bool("hybrid"))
+			},
+		},
+		{
+			Name:  "sign",
+			Usage: "sign an ecfg file so that changes to it can be detected",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "read the signing key from the given file, rather than ECFG_SIGNING_KEY",
+				},
+				cli.StringFlag{
+					Name:  "type, t",
+					Usage: "Specify the filetype (json, jsonc, yaml, toml)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				firstArg, input, fileType, err := singleFileArgs(c, "sign")
+				if err != nil {
+					return err
+				}
+				return signAction(firstArg, input, c.String("key"), fileType)
+			},
+		},
+		{
+			Name:  "verify",
+			Usage: "check that an ecfg file is signed by a trusted key and unchanged since",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "trusted-signers",
+					Usage: "file listing the public keys of trusted signers, one per line",
+				},
+				cli.StringFlag{
+					Name:  "type, t",
+					Usage: "Specify the filetype (json, jsonc, yaml, toml)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				firstArg, input, fileType, err := singleFileArgs(c, "verify")
+				if err != nil {
+					return err
+				}
+				return verifyAction(firstArg, input, c.String("trusted-signers"), fileType)
+			},
+		},
+		{
+			Name:  "upgrade",
+			Usage: "re-encrypt the values of one or more ecfg files in a newer schema version",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "to-version",
+					Usage: "upgrade values to the given schema version, rather than the one ecfg encrypt would write",
+				},
+				cli.StringFlag{
+					Name:  "type, t",
+					Usage: "Specify the filetype (json, jsonc, yaml, toml, hcl, ini, properties, k8s-secret, ...)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) == 0 {
+					return errors.New("ecfg upgrade requires at least one file")
+				}
+				return upgradeAction(c.Args(), c.GlobalString("keydir"), c.String("t"), c.Int("to-version"))
 			},
 		},
 	}
@@ -128,33 +214,87 @@ func main() {
 	}
 }
 
-func determineFileType(typeArg string, firstArg string) (ecfg.FileType, error) {
-	switch typeArg {
-	case "json":
-		return ecfg.FileTypeJSON, nil
-	case "yaml":
-		return ecfg.FileTypeYAML, nil
-	case "toml":
-		return ecfg.FileTypeTOML, nil
-	case "":
-		if firstArg == "" {
-			return ecfg.FileTypeJSON, errors.New("--type must be passed when not inferrable from file name")
+// singleFileArgs returns the file argument of a command which operates on at
+// most one file, the contents of stdin if they're needed to determine the file
+// type, and the file type.
+func singleFileArgs(c *cli.Context, command string) (filePath string, stdin []byte, fileType ecfg.FileType, err error) {
+	args := c.Args()
+	if len(args) > 1 {
+		return "", nil, "", fmt.Errorf("ecfg %s only operates on one file at a time", command)
+	}
+	if len(args) == 1 {
+		filePath = args[0]
+	}
+	if stdin, err = readStdin(c.String("t"), filePath); err != nil {
+		return "", nil, "", err
+	}
+	fileType, err = determineFileType(c.String("t"), filePath, stdin)
+	return filePath, stdin, fileType, err
+}
+
+// decryptOptions returns the options given by the flags of `ecfg decrypt`,
+// other than its file type.
+func decryptOptions(c *cli.Context) (opts ecfg.StreamOptions, err error) {
+	if c.IsSet("placeholder") && !c.Bool("keep-going") {
+		return opts, errors.New("ecfg decrypt --placeholder requires --keep-going")
+	}
+	opts = ecfg.StreamOptions{
+		KeepGoing:        c.Bool("keep-going"),
+		Placeholder:      c.String("placeholder"),
+		AllowUnencrypted: c.Bool("allow-unencrypted"),
+	}
+	if signersFile := c.String("trusted-signers"); signersFile != "" {
+		if opts.TrustedSigners, err = readTrustedSigners(signersFile); err != nil {
+			return opts, err
 		}
-		if strings.HasSuffix(firstArg, "json") {
-			return ecfg.FileTypeJSON, nil
+	}
+	return opts, nil
+}
+
+// partialDecryptSummary lists each value which couldn't be decrypted, followed
+// by their number.
+func partialDecryptSummary(err *ecfg.PartialDecryptError) string {
+	var b strings.Builder
+	for _, valueErr := range err.Errors {
+		fmt.Fprintln(&b, valueErr)
+	}
+	if len(err.Errors) == 1 {
+		b.WriteString("1 value couldn't be decrypted")
+	} else {
+		fmt.Fprintf(&b, "%d values couldn't be decrypted", len(err.Errors))
+	}
+	return b.String()
+}
+
+// readStdin returns the document on stdin if it's needed to infer its type,
+// i.e. if neither a type nor a file path is given. Otherwise, it returns nil,
+// and stdin is left to be streamed.
+func readStdin(typeArg, filePath string) ([]byte, error) {
+	if typeArg != "" || filePath != "" {
+		return nil, nil
+	}
+	return ioutil.ReadAll(os.Stdin)
+}
+
+// determineFileType returns the type given with --type or, failing that, the
+// type detected from the name and content of the file at filePath, or from
+// stdin, the contents of stdin.
+func determineFileType(typeArg, filePath string, stdin []byte) (ecfg.FileType, error) {
+	if typeArg != "" {
+		if _, err := format.Lookup(typeArg); err != nil {
+			return "", fmt.Errorf("invalid filetype: specify one of %s", strings.Join(format.Names(), ", "))
 		}
-		if strings.HasSuffix(firstArg, "yaml") || strings.HasSuffix(firstArg, "yml") {
-			return ecfg.FileTypeYAML, nil
+		return ecfg.FileType(typeArg), nil
+	}
+	data := stdin
+	if filePath != "" {
+		if fileType, ok := ecfg.FileTypeForFilename(filePath); ok {
+			return fileType, nil
 		}
-		if strings.HasSuffix(firstArg, "toml") {
-			return ecfg.FileTypeTOML, nil
+		var err error
+		if data, err = ioutil.ReadFile(filePath); err != nil {
+			return "", err
 		}
-		return ecfg.FileTypeJSON, errors.New("can't infer filetype from filename. rename file or specify type with --type")
-	default:
-		return ecfg.FileTypeJSON, errors.New("invalid filetype: specify 'json', 'yaml', or 'toml'")
-	}
-	if firstArg == "" && typeArg == "" {
-		return ecfg.FileTypeJSON, errors.New("--type must be passed when not inferrable from file name")
 	}
-	return ecfg.FileTypeJSON, nil
+	return ecfg.DetectFileType(filePath, data)
 }
@@ -0,0 +1,134 @@
+package ecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Shopify/ecfg/pkg/format"
+	"github.com/Shopify/ecfg/pkg/toml"
+	"github.com/Shopify/ecfg/pkg/yaml"
+)
+
+// DetectFileType determines the type of an ecfg document. If name ends in a
+// suffix registered for a format (e.g. ".ecfg.json", ".ejson", or ".yml"),
+// that format is used. Otherwise, the content of the document is inspected:
+// its first significant line must look like the start of a JSON object, a
+// TOML table header or `key = value` pair, or a YAML mapping, and the whole
+// document must then parse as that format. If the content doesn't confidently
+// identify a single format, an error is returned.
+//
+// name may be empty, e.g. when the document is read from stdin.
+func DetectFileType(name string, data []byte) (FileType, error) {
+	if name != "" {
+		if fileType, ok := FileTypeForFilename(name); ok {
+			return fileType, nil
+		}
+	}
+
+	var candidates []FileType
+	for _, s := range sniffers {
+		if s.looksLike(firstSignificantLine(data)) && s.parses(data) {
+			candidates = append(candidates, s.fileType)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("can't infer filetype from file name or content. rename file or specify type with --type")
+	case 1:
+		return candidates[0], nil
+	default:
+		names := make([]string, len(candidates))
+		for i, c := range candidates {
+			names[i] = string(c)
+		}
+		return "", fmt.Errorf("content could be any of %s. specify type with --type", strings.Join(names, ", "))
+	}
+}
+
+type sniffer struct {
+	fileType FileType
+	// looksLike reports whether the first significant line of a document is
+	// characteristic of the format.
+	looksLike func(line []byte) bool
+	// parses reports whether the whole document is valid in the format.
+	parses func(data []byte) bool
+}
+
+var (
+	tomlTableHeader = regexp.MustCompile(`^\[\[?\s*[A-Za-z0-9_"'.\- ]+\s*\]\]?\s*(#.*)?$`)
+	tomlKeyValue    = regexp.MustCompile(`^([A-Za-z0-9_-]+|"[^"]*"|'[^']*')\s*=`)
+	yamlMappingKey  = regexp.MustCompile(`^([^\s#{\[\-?:'"][^:#]*|"[^"]*"|'[^']*')\s*:(\s|$)`)
+)
+
+// sniffers are the content-based checks used by DetectFileType. A document
+// which is valid JSON is also valid JSONC and YAML, so the JSONC and YAML
+// checks exclude what the JSON check accepts.
+var sniffers = []sniffer{
+	{
+		fileType:  FileTypeJSON,
+		looksLike: func(line []byte) bool { return bytes.HasPrefix(line, []byte("{")) },
+		parses:    json.Valid,
+	},
+	{
+		fileType: FileTypeJSONC,
+		looksLike: func(line []byte) bool {
+			return bytes.HasPrefix(line, []byte("{")) ||
+				bytes.HasPrefix(line, []byte("//")) ||
+				bytes.HasPrefix(line, []byte("/*"))
+		},
+		parses: func(data []byte) bool {
+			return !json.Valid(data) && handlerAccepts(FileTypeJSONC, data)
+		},
+	},
+	{
+		fileType: FileTypeTOML,
+		looksLike: func(line []byte) bool {
+			return tomlTableHeader.Match(line) || tomlKeyValue.Match(line)
+		},
+		parses: func(data []byte) bool {
+			var obj map[string]interface{}
+			return toml.Unmarshal(data, &obj) == nil
+		},
+	},
+	{
+		fileType: FileTypeYAML,
+		looksLike: func(line []byte) bool {
+			return bytes.Equal(line, []byte("---")) || yamlMappingKey.Match(line)
+		},
+		parses: func(data []byte) bool {
+			var obj map[string]interface{}
+			return yaml.Unmarshal(data, &obj) == nil && len(obj) > 0
+		},
+	},
+}
+
+// firstSignificantLine returns the first line of data which isn't blank or a
+// `#` comment, without surrounding whitespace.
+func firstSignificantLine(data []byte) []byte {
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 && line[0] != '#' {
+			return line
+		}
+	}
+	return nil
+}
+
+// handlerAccepts reports whether the handler for typ can process data.
+func handlerAccepts(typ FileType, data []byte) bool {
+	fh, err := format.Lookup(string(typ))
+	if err != nil {
+		return false
+	}
+	_, err = fh.TransformScalarValues(data, func(b []byte) ([]byte, error) { return b, nil })
+	return err == nil
+}
@@ -0,0 +1,52 @@
+package ecfg
+
+import (
+	"testing"
+)
+
+func TestDetectFileType(t *testing.T) {
+	cases := []struct {
+		name, data string
+		expected   FileType
+	}{
+		{"a.ecfg.json", `not even json`, FileTypeJSON},
+		{"a.ejson", ``, FileTypeJSON},
+		{"a.ecfg.yml", ``, FileTypeYAML},
+		{"", `{"_public_key": "k", "a": "b"}`, FileTypeJSON},
+		{"-", "\n  {\n  \"a\": \"b\"\n}\n", FileTypeJSON},
+		{"secrets", "// comment\n{\"a\": \"b\",}\n", FileTypeJSONC},
+		{"secrets", "{\"a\": \"b\", /* c */}", FileTypeJSONC},
+		{"secrets", "# comment\n_public_key = \"k\"\n[a]\nb = 1\n", FileTypeTOML},
+		{"secrets", "[a.b]\nc = \"d\"\n", FileTypeTOML},
+		{"secrets", "[[a]]\nc = \"d\"\n", FileTypeTOML},
+		{"secrets", "# comment\n_public_key: k\na:\n  - b\n", FileTypeYAML},
+		{"secrets", "---\na: b\n", FileTypeYAML},
+		{"secrets", "\"a b\": c\n", FileTypeYAML},
+	}
+	for _, tc := range cases {
+		actual, err := DetectFileType(tc.name, []byte(tc.data))
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.data, err)
+		} else if actual != tc.expected {
+			t.Errorf("%q: expected %s, got %s", tc.data, tc.expected, actual)
+		}
+	}
+}
+
+func TestDetectFileTypeUnsure(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`just some text`,
+		`{"a": "b"`,               // malformed JSON
+		"a = b\n",                 // INI, not TOML
+		"[a]\nb: c\n",             // TOML header, YAML body
+		"- a\n- b\n",              // YAML, but not a mapping
+		"a: b\n  c: d\n",          // malformed YAML
+		"foojson",                 // no longer matched by suffix
+		"# only a comment\n\n# x", // nothing significant
+	} {
+		if actual, err := DetectFileType("foojson", []byte(data)); err == nil {
+			t.Errorf("%q: expected error, but detected %s", data, actual)
+		}
+	}
+}
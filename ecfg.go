@@ -4,83 +4,159 @@
 package ecfg
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/hex"
-	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
+	"io"
 	"strings"
 
-	"github.com/Shopify/ecfg/pkg/crypto"
 	"github.com/Shopify/ecfg/pkg/format"
-	"github.com/Shopify/ecfg/pkg/json"
-	"github.com/Shopify/ecfg/pkg/toml"
-	"github.com/Shopify/ecfg/pkg/yaml"
+
+	// Register the remaining built-in formats.
+	_ "github.com/Shopify/ecfg/pkg/hcl"
+	_ "github.com/Shopify/ecfg/pkg/ini"
+	_ "github.com/Shopify/ecfg/pkg/json"
+	_ "github.com/Shopify/ecfg/pkg/properties"
+	_ "github.com/Shopify/ecfg/pkg/toml"
 )
 
-type FileType int
+// FileType names the format of an ecfg document. Any name registered with
+// format.Register is valid; the constants below name the built-in formats.
+type FileType string
 
 const (
-	FileTypeJSON = iota
-	FileTypeYAML
-	FileTypeTOML
+	FileTypeJSON       FileType = "json"
+	FileTypeJSONC      FileType = "jsonc"
+	FileTypeYAML       FileType = "yaml"
+	FileTypeTOML       FileType = "toml"
+	FileTypeK8sSecret  FileType = "k8s-secret"
+	FileTypeHCL        FileType = "hcl"
+	FileTypeINI        FileType = "ini"
+	FileTypeProperties FileType = "properties"
 )
 
-// GenerateKeypair is used to create a new ecfg keypair. It returns the keys as
-// hex-encoded strings, suitable for printing to the screen. hex.DecodeString
-// can be used to load the true representation if necessary.
-func GenerateKeypair() (pub string, priv string, err error) {
-	var kp crypto.Keypair
-	if err := kp.Generate(); err != nil {
-		return "", "", err
+// FileTypeForFilename infers the type of an ecfg document from its name,
+// using the suffixes registered with format.Register.
+func FileTypeForFilename(filename string) (FileType, bool) {
+	f, ok := format.LookupFilename(filename)
+	if !ok {
+		return "", false
 	}
-	return kp.PublicString(), kp.PrivateString(), nil
+	return FileType(f.Name), true
 }
 
-// EncryptFileInPlace takes a path to a file on disk, which must be a valid ecfg file
-// (see README.md for more on what constitutes a valid ecfg file). Any
-// encryptable-but-unencrypted fields in the file will be encrypted using the
-// public key embdded in the file, and the resulting text will be written over
-// the file present on disk.
-func EncryptFileInPlace(filePath string, fileType FileType) (int, error) {
-	data, err := readFile(filePath)
-	if err != nil {
-		return -1, err
+// EncryptOption configures the package-level functions which encrypt
+// documents.
+type EncryptOption func(*Client)
+
+// WithRand makes encryption read the randomness for ephemeral keypairs and
+// nonces from r rather than crypto/rand.Reader, as Client.Rand does. This is
+// only useful to make encrypted output reproducible in tests: r must be
+// unpredictable for the encryption to be secure.
+func WithRand(r io.Reader) EncryptOption {
+	return func(c *Client) {
+		c.Rand = r
 	}
+}
 
-	fileMode, err := getMode(filePath)
-	if err != nil {
-		return -1, err
+// encryptClient returns the Client configured by opts.
+func encryptClient(opts []EncryptOption) *Client {
+	if len(opts) == 0 {
+		return defaultClient
 	}
+	c := &Client{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
 
-	newdata, err := EncryptData(data, fileType)
-	if err != nil {
-		return -1, err
+// DecryptOption configures the package-level functions which decrypt
+// documents.
+type DecryptOption func(*Client)
+
+// KeepGoing makes decryption best-effort, as Client.KeepGoing does: values
+// which can't be decrypted are replaced with placeholder or, if it's empty,
+// left as written, and the document is returned along with a
+// *PartialDecryptError listing them.
+func KeepGoing(placeholder string) DecryptOption {
+	return func(c *Client) {
+		c.KeepGoing = true
+		c.Placeholder = placeholder
 	}
+}
 
-	if err := writeFile(filePath, newdata, fileMode); err != nil {
-		return -1, err
+// RequireSignature makes decryption refuse documents which haven't been signed
+// by one of the trusted keys, as Client.TrustedSigners does.
+func RequireSignature(trusted ...ed25519.PublicKey) DecryptOption {
+	return func(c *Client) {
+		c.TrustedSigners = trusted
 	}
+}
 
-	return len(newdata), nil
+// AllowUnencrypted makes decryption leave values which haven't been encrypted
+// at all as they are, rather than failing, as Client.AllowUnencrypted does.
+func AllowUnencrypted() DecryptOption {
+	return func(c *Client) {
+		c.AllowUnencrypted = true
+	}
 }
 
-func EncryptData(data []byte, fileType FileType) ([]byte, error) {
-	fh := handlerForType(fileType)
+// GenerateKeypair is used to create a new ecfg keypair. It returns the keys as
+// hex-encoded strings, suitable for printing to the screen. hex.DecodeString
+// can be used to load the true representation if necessary.
+func GenerateKeypair() (pub string, priv string, err error) {
+	return defaultClient.GenerateKeypair()
+}
 
-	var myKP crypto.Keypair
-	if err := myKP.Generate(); err != nil {
-		return nil, err
-	}
+// GenerateHybridKeypair is like GenerateKeypair, but creates a hybrid X25519 +
+// ML-KEM-768 keypair, whose values remain confidential unless both algorithms
+// are broken. The public key begins with "mlkem768x25519:", and the private
+// key is hex-encoded like any other. Values encrypted to a hybrid key have
+// schema version 3; decryption handles these and older values alike.
+func GenerateHybridKeypair() (pub string, priv string, err error) {
+	return defaultClient.GenerateHybridKeypair()
+}
 
-	pubkey, err := fh.ExtractPublicKey(data)
+// PublicKeyID returns the hex-encoded identifier of pub, a public key as
+// returned by GenerateKeypair or GenerateHybridKeypair, which names the file
+// holding its private key in a keydir. This is pub itself, except for hybrid
+// keys, whose identifier is their X25519 component.
+func PublicKeyID(pub string) (string, error) {
+	key, err := parsePublicKey(pub)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	return hex.EncodeToString(key.id[:]), nil
+}
 
-	encrypter := myKP.Encrypter(pubkey)
+// EncryptFileInPlace takes a path to a file on disk, which must be a valid ecfg file
+// (see README.md for more on what constitutes a valid ecfg file). Any
+// encryptable-but-unencrypted fields in the file will be encrypted using the
+// public key embdded in the file, and the resulting text will be written over
+// the file present on disk.
+func EncryptFileInPlace(filePath string, fileType FileType, opts ...EncryptOption) (int, error) {
+	return EncryptFileInPlaceContext(context.Background(), filePath, fileType, opts...)
+}
+
+// EncryptFileInPlaceContext is like EncryptFileInPlace, but stops and returns
+// ctx.Err() if ctx is done before the file has been encrypted, in which case
+// the file is left unchanged.
+func EncryptFileInPlaceContext(ctx context.Context, filePath string, fileType FileType, opts ...EncryptOption) (int, error) {
+	return encryptClient(opts).EncryptFileInPlace(ctx, filePath, fileType)
+}
+
+// EncryptData takes an ecfg document and returns the same document with all
+// encryptable-but-unencrypted values encrypted using the public key embedded
+// in it.
+func EncryptData(data []byte, fileType FileType, opts ...EncryptOption) ([]byte, error) {
+	return EncryptDataContext(context.Background(), data, fileType, opts...)
+}
 
-	return fh.TransformScalarValues(data, encrypter.Encrypt)
+// EncryptDataContext is like EncryptData, but stops and returns ctx.Err() if
+// ctx is done before every value has been encrypted.
+func EncryptDataContext(ctx context.Context, data []byte, fileType FileType, opts ...EncryptOption) ([]byte, error) {
+	return encryptClient(opts).EncryptData(ctx, data, fileType)
 }
 
 // DecryptFile takes a path to an encrypted ecfg file and returns the data
@@ -89,13 +165,14 @@ func EncryptData(data []byte, fileType FileType) ([]byte, error) {
 // keypath. There must exist a file in at least one of the keypath entries
 // whose name is the public key from the ecfg document, and whose contents are
 // the corresponding private key. See README.md for more details on this.
-func DecryptFile(filePath string, keypath []string, fileType FileType) ([]byte, error) {
-	data, err := readFile(filePath)
-	if err != nil {
-		return nil, err
-	}
+func DecryptFile(filePath string, keypath []string, fileType FileType, opts ...DecryptOption) ([]byte, error) {
+	return DecryptFileContext(context.Background(), filePath, keypath, fileType, opts...)
+}
 
-	return DecryptData(data, keypath, fileType)
+// DecryptFileContext is like DecryptFile, but stops and returns ctx.Err() if
+// ctx is done before the file has been decrypted.
+func DecryptFileContext(ctx context.Context, filePath string, keypath []string, fileType FileType, opts ...DecryptOption) ([]byte, error) {
+	return keypathClient(keypath, opts).DecryptFile(ctx, filePath, fileType)
 }
 
 // DecryptData takes a an encrypted ecfg document and returns the same
@@ -104,58 +181,30 @@ func DecryptFile(filePath string, keypath []string, fileType FileType) ([]byte,
 // There must exist a file in at least one of the keypath entries whose name is
 // the public key from the ecfg document, and whose contents are the
 // corresponding private key. See README.md for more details on this.
-func DecryptData(data []byte, keypath []string, fileType FileType) ([]byte, error) {
-	fh := handlerForType(fileType)
-
-	pubkey, err := fh.ExtractPublicKey(data)
-	if err != nil {
-		return nil, err
-	}
-
-	privkey, err := findPrivateKey(pubkey, keypath)
-	if err != nil {
-		return nil, err
-	}
-
-	myKP := crypto.Keypair{
-		Public:  pubkey,
-		Private: privkey,
-	}
-
-	decrypter := myKP.Decrypter()
+func DecryptData(data []byte, keypath []string, fileType FileType, opts ...DecryptOption) ([]byte, error) {
+	return DecryptDataContext(context.Background(), data, keypath, fileType, opts...)
+}
 
-	return fh.TransformScalarValues(data, decrypter.Decrypt)
+// DecryptDataContext is like DecryptData, but stops and returns ctx.Err() if
+// ctx is done before the private key has been found or before every value has
+// been decrypted.
+func DecryptDataContext(ctx context.Context, data []byte, keypath []string, fileType FileType, opts ...DecryptOption) ([]byte, error) {
+	return keypathClient(keypath, opts).DecryptData(ctx, data, fileType)
 }
 
 // DefaultKeypath is UserKeypath prefixed to SystemKeypath. For root, this will
 // be equal to SystemKeypath, and for other users, this will cause key lookups
 // to first try their own local keys, falling back to system keys if that
 // fails.
-func DefaultKeypath() (keypath []string) {
-	for _, elem := range UserKeypath() {
-		keypath = append(keypath, elem)
-	}
-	for _, elem := range SystemKeypath() {
-		keypath = append(keypath, elem)
-	}
-	return
+func DefaultKeypath() []string {
+	return defaultClient.DefaultKeypath()
 }
 
 // UserKeypath returns the user-specific locations at which to search for ecfg
 // keys. In most cases, this is empty for root, and ~/.ecfg/keys in other cases.
 // If XDG_CONFIG_HOME is set, $XDG_CONFIG_HOME/ecfg/keys is highest priority.
-func UserKeypath() (keypath []string) {
-	// no user keypath entries for root.
-	if getuid() == 0 {
-		return
-	}
-
-	xdgConfigHome := getenv("XDG_CONFIG_HOME")
-	if xdgConfigHome != "" {
-		keypath = append(keypath, filepath.Join(xdgConfigHome, "ecfg", "keys"))
-	}
-	keypath = append(keypath, filepath.Join(getenv("HOME"), ".ecfg", "keys"))
-	return
+func UserKeypath() []string {
+	return defaultClient.UserKeypath()
 }
 
 // SystemKeypath returns the default system-wide locations at which to search
@@ -167,64 +216,78 @@ func SystemKeypath() (keypath []string) {
 	return
 }
 
-func findPrivateKey(pubkey [32]byte, keypath []string) (privkey [32]byte, err error) {
-	keyString := os.Getenv("ECFG_PRIVATE_KEY")
-	if keyString == "" {
-		for _, keydir := range keypath {
-			keyFile := fmt.Sprintf("%s/%x", keydir, pubkey)
-			fileContents, err := readFile(keyFile)
-			if err == nil {
-				keyString = strings.TrimSpace(string(fileContents))
-				break
-			}
-		}
+// transformContext applies action to each encryptable value of data, checking
+// ctx before each one. If fh reports the key paths of values, each is bound
+// to its path (see binding); otherwise the binding is nil. Handlers which
+// transform values concurrently stop scheduling further values after the
+// first error, so cancellation takes effect promptly even for large
+// documents.
+func transformContext(ctx context.Context, fh format.FormatHandler, data []byte, action func(value, binding []byte) ([]byte, error)) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	if keyString == "" {
-		err = fmt.Errorf("private key not found in keypath")
-		return
+	var out []byte
+	var err error
+	if walker, ok := fh.(format.ScalarValueWalker); ok {
+		out, err = walker.WalkScalarValues(data, boundVisitor(ctx, action))
+	} else {
+		out, err = fh.TransformScalarValues(data, func(bs []byte) ([]byte, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return action(bs, nil)
+		})
 	}
-
-	bs, err := hex.DecodeString(keyString)
 	if err != nil {
-		return
+		return nil, contextErr(ctx, err)
 	}
+	return out, nil
+}
 
-	if len(bs) != 32 {
-		err = fmt.Errorf("invalid private key retrieved from keydir")
-		return
+// boundVisitor adapts action into a visitor for WalkScalarValues which binds
+// each value to its key path, checking ctx before each one.
+func boundVisitor(ctx context.Context, action func(value, binding []byte) ([]byte, error)) func(format.ScalarValue) ([]byte, error) {
+	return func(v format.ScalarValue) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return format.TransformAction(func(bs []byte) ([]byte, error) {
+			return action(bs, binding(v.Path))
+		})(v)
 	}
-
-	copy(privkey[:], bs)
-	return
 }
 
-func handlerForType(typ FileType) format.FormatHandler {
-	switch typ {
-	case FileTypeJSON:
-		return &json.FormatHandler{}
-	case FileTypeYAML:
-		return &yaml.FormatHandler{}
-	case FileTypeTOML:
-		return &toml.FormatHandler{}
-	default:
-		panic("bug: invalid file type")
+// binding returns what a value at path is bound to when it's encrypted: the
+// rendering of the path, with array indices left out (as in "hosts[]"), so
+// that inserting, removing or reordering list elements doesn't prevent the
+// others from being decrypted. Values at the root of a document, with an
+// empty path, aren't bound.
+func binding(path format.Path) []byte {
+	if len(path) == 0 {
+		return nil
 	}
+	var b strings.Builder
+	for i, el := range path {
+		if el.IsIndex {
+			b.WriteString("[]")
+			continue
+		}
+		key := format.Path{el}.String()
+		if i > 0 && !strings.HasPrefix(key, "[") {
+			b.WriteByte('.')
+		}
+		b.WriteString(key)
+	}
+	return []byte(b.String())
 }
 
-// for mocking in tests
-func _getMode(path string) (os.FileMode, error) {
-	fi, err := os.Stat(path)
+// contextErr returns ctx.Err() in place of err if ctx is done, since handlers
+// may wrap the error returned by a cancelled action.
+func contextErr(ctx context.Context, err error) error {
 	if err != nil {
-		return 0, err
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 	}
-	return fi.Mode(), nil
+	return err
 }
-
-// for mocking in tests
-var (
-	readFile  = ioutil.ReadFile
-	writeFile = ioutil.WriteFile
-	getMode   = _getMode
-	getuid    = os.Getuid
-	getenv    = os.Getenv
-)
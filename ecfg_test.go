@@ -1,12 +1,21 @@
 package ecfg
 
 import (
-	"io/ioutil"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync/atomic"
 	"testing"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+	"github.com/Shopify/ecfg/pkg/format"
 )
 
 func TestGenerateKeypair(t *testing.T) {
@@ -31,22 +40,18 @@ func assertNoError(t *testing.T, err error) {
 }
 
 func TestEncryptFileInPlace(t *testing.T) {
-	getMode = func(p string) (os.FileMode, error) {
-		return 0400, nil
-	}
-	defer func() { getMode = _getMode }()
+	fsys := memFS{}
+	c := &Client{FS: fsys}
+	ctx := context.Background()
 
-	_, err := EncryptFileInPlace("/does/not/exist", FileTypeJSON)
+	_, err := c.EncryptFileInPlace(ctx, "/does/not/exist", FileTypeJSON)
 	if !os.IsNotExist(err) {
 		t.Errorf("expected IsNotExist, got %v", err)
 	}
 
 	// invalid json file
-	readFile = func(p string) ([]byte, error) {
-		return []byte(`{"a": "b"]`), nil
-	}
-	_, err = EncryptFileInPlace("/doesnt/matter", FileTypeJSON)
-	readFile = ioutil.ReadFile
+	fsys["/doesnt/matter"] = []byte(`{"a": "b"]`)
+	_, err = c.EncryptFileInPlace(ctx, "/doesnt/matter", FileTypeJSON)
 	if err == nil {
 		t.Errorf("expected error, but none was received")
 	} else {
@@ -56,11 +61,8 @@ func TestEncryptFileInPlace(t *testing.T) {
 	}
 
 	// invalid key
-	readFile = func(p string) ([]byte, error) {
-		return []byte(`{"_public_key": "invalid"}`), nil
-	}
-	_, err = EncryptFileInPlace("/doesnt/matter", FileTypeJSON)
-	readFile = ioutil.ReadFile
+	fsys["/doesnt/matter"] = []byte(`{"_public_key": "invalid"}`)
+	_, err = c.EncryptFileInPlace(ctx, "/doesnt/matter", FileTypeJSON)
 	if err == nil {
 		t.Errorf("expected error, but none was received")
 	} else {
@@ -70,19 +72,10 @@ func TestEncryptFileInPlace(t *testing.T) {
 	}
 
 	// valid keypair
-	readFile = func(p string) ([]byte, error) {
-		return []byte(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "b"}`), nil
-	}
-
-	var output []byte
-	writeFile = func(path string, data []byte, mode os.FileMode) error {
-		output = data
-		return nil
-	}
-	_, err = EncryptFileInPlace("/doesnt/matter", FileTypeJSON)
-	readFile = ioutil.ReadFile
-	writeFile = ioutil.WriteFile
+	fsys["/doesnt/matter"] = []byte(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "b"}`)
+	_, err = c.EncryptFileInPlace(ctx, "/doesnt/matter", FileTypeJSON)
 	assertNoError(t, err)
+	output := fsys["/doesnt/matter"]
 	match := regexp.MustCompile(`{"_public_key": "8d8.*", "a": "EJ.*"}`)
 	if match.Find(output) == nil {
 		t.Errorf("unexpected output: %s", output)
@@ -91,18 +84,18 @@ func TestEncryptFileInPlace(t *testing.T) {
 }
 
 func TestDecryptFile(t *testing.T) {
+	fsys := memFS{}
+	c := &Client{FS: fsys, Keypath: []string{"b"}, Getenv: func(string) string { return "" }}
+	ctx := context.Background()
 
-	_, err := DecryptFile("/does/not/exist", []string{"/doesnt/matter"}, FileTypeJSON)
+	_, err := c.DecryptFile(ctx, "/does/not/exist", FileTypeJSON)
 	if !os.IsNotExist(err) {
 		t.Errorf("expected IsNotExist, but got %v", err)
 	}
 
 	// invalid json file
-	readFile = func(p string) ([]byte, error) {
-		return []byte(`{"a": "b"]`), nil
-	}
-	_, err = DecryptFile("/doesnt/matter", []string{"/doesnt/matter"}, FileTypeJSON)
-	readFile = ioutil.ReadFile
+	fsys["a"] = []byte(`{"a": "b"]`)
+	_, err = c.DecryptFile(ctx, "a", FileTypeJSON)
 	if err == nil {
 		t.Errorf("expected error, but none was received")
 	} else {
@@ -111,11 +104,8 @@ func TestDecryptFile(t *testing.T) {
 		}
 	}
 
-	readFile = func(p string) ([]byte, error) {
-		return []byte(`{"_public_key": "invalid"}`), nil
-	}
-	_, err = DecryptFile("/doesnt/matter", []string{"/doesnt/matter"}, FileTypeJSON)
-	readFile = ioutil.ReadFile
+	fsys["a"] = []byte(`{"_public_key": "invalid"}`)
+	_, err = c.DecryptFile(ctx, "a", FileTypeJSON)
 	if err == nil {
 		t.Errorf("expected error, but none was received")
 	} else {
@@ -125,14 +115,8 @@ func TestDecryptFile(t *testing.T) {
 	}
 
 	// valid keypair but no corresponding entry in keydir
-	readFile = func(p string) ([]byte, error) {
-		if p == "a" {
-			return []byte(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "b"}`), nil
-		}
-		return ioutil.ReadFile("/does/not/exist")
-	}
-	_, err = DecryptFile("a", []string{"b"}, FileTypeJSON)
-	readFile = ioutil.ReadFile
+	fsys["a"] = []byte(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "b"}`)
+	_, err = c.DecryptFile(ctx, "a", FileTypeJSON)
 	if err == nil {
 		t.Errorf("expected error, but none was received")
 	} else {
@@ -142,77 +126,377 @@ func TestDecryptFile(t *testing.T) {
 	}
 
 	// valid keypair and a corresponding entry in keydir
-	readFile = func(p string) ([]byte, error) {
-		if p == "a" {
-			return []byte(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"}`), nil
-		}
-		return []byte("c5caa31a5b8cb2be0074b37c56775f533b368b81d8fd33b94181f79bd6e47f87"), nil
-	}
-	out, err := DecryptFile("a", []string{"b"}, FileTypeJSON)
-	readFile = ioutil.ReadFile
+	fsys["a"] = []byte(`{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "EJ[1:KR1IxNZnTZQMP3OR1NdOpDQ1IcLD83FSuE7iVNzINDk=:XnYW1HOxMthBFMnxWULHlnY4scj5mNmX:ls1+kvwwu2ETz5C6apgWE7Q=]"}`)
+	fsys["b/8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d"] = []byte("c5caa31a5b8cb2be0074b37c56775f533b368b81d8fd33b94181f79bd6e47f87\n")
+	out, err := c.DecryptFile(ctx, "a", FileTypeJSON)
 	assertNoError(t, err)
 	if string(out) != `{"_public_key": "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d", "a": "b"}` {
 		t.Errorf("unexpected output")
 	}
+
+	_, err = DecryptFile("/does/not/exist", []string{"/doesnt/matter"}, FileTypeJSON)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected IsNotExist, but got %v", err)
+	}
+}
+
+func TestKeyPathBinding(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	docs := []struct {
+		fileType FileType
+		doc      string
+		swap     func(a, b string) string
+	}{
+		{FileTypeJSON, `{"_public_key": "` + testPublicKey + `", "a": "x", "b": {"c": "y"}}`, func(a, b string) string {
+			return `{"_public_key": "` + testPublicKey + `", "a": "` + b + `", "b": {"c": "` + a + `"}}`
+		}},
+		{FileTypeYAML, "_public_key: " + testPublicKey + "\na: x\nb:\n  c: y\n", func(a, b string) string {
+			return "_public_key: " + testPublicKey + "\na: " + b + "\nb:\n  c: " + a + "\n"
+		}},
+		{FileTypeTOML, "_public_key = \"" + testPublicKey + "\"\na = \"x\"\n[b]\nc = \"y\"\n", func(a, b string) string {
+			return "_public_key = \"" + testPublicKey + "\"\na = \"" + b + "\"\n[b]\nc = \"" + a + "\"\n"
+		}},
+	}
+	msg := regexp.MustCompile(`EJ\[[^\]"]*\]`)
+	for _, d := range docs {
+		encrypted, err := EncryptData([]byte(d.doc), d.fileType)
+		assertNoError(t, err)
+		values := msg.FindAllString(string(encrypted), -1)
+		if len(values) != 2 || !strings.HasPrefix(values[0], "EJ[2:") || !strings.HasPrefix(values[1], "EJ[2:") {
+			t.Errorf("%s: expected two bound values, got\n%s", d.fileType, encrypted)
+			continue
+		}
+		if _, err := DecryptData(encrypted, nil, d.fileType); err != nil {
+			t.Errorf("%s: unexpected error: %v", d.fileType, err)
+		}
+
+		_, err = DecryptData([]byte(d.swap(values[0], values[1])), nil, d.fileType)
+		var valueErr *ValueError
+		if !errors.As(err, &valueErr) || !errors.Is(err, crypto.ErrBindingMismatch) {
+			t.Errorf("%s: expected swapped values to fail with ErrBindingMismatch, got %v", d.fileType, err)
+		}
+	}
+
+	// List elements are bound to their list but not their index, so the list
+	// can be edited, but elements can't be moved to another list.
+	lists := map[FileType]string{
+		FileTypeJSON: `{"_public_key": "` + testPublicKey + `", "hosts": [%s], "ports": [%s]}`,
+		FileTypeYAML: "_public_key: " + testPublicKey + "\nhosts: [%s]\nports: [%s]\n",
+	}
+	for fileType, doc := range lists {
+		encrypted, err := EncryptData([]byte(fmt.Sprintf(doc, `"a", "b", "c"`, `"d"`)), fileType)
+		assertNoError(t, err)
+		values := msg.FindAllString(string(encrypted), -1)
+		if len(values) != 4 {
+			t.Fatalf("%s: expected four values, got\n%s", fileType, encrypted)
+		}
+		edited := fmt.Sprintf(doc, `"`+values[2]+`", "`+values[1]+`"`, `"`+values[3]+`"`)
+		decrypted, err := DecryptData([]byte(edited), nil, fileType)
+		if want := `"c", "b"`; err != nil || !strings.Contains(string(decrypted), want) {
+			t.Errorf("%s: expected edited list to decrypt to %s, got %v:\n%s", fileType, want, err, decrypted)
+		}
+		moved := fmt.Sprintf(doc, `"`+values[1]+`"`, `"`+values[3]+`", "`+values[0]+`"`)
+		if _, err := DecryptData([]byte(moved), nil, fileType); !errors.Is(err, crypto.ErrBindingMismatch) {
+			t.Errorf("%s: expected element moved to another list to fail with ErrBindingMismatch, got %v", fileType, err)
+		}
+	}
+
+	// Bound values can't be moved by relabelling them as unbound.
+	relabelled := map[FileType]string{
+		FileTypeJSON: `{"_public_key": "` + testPublicKey + `", "db_password": "%s", "public_banner": "%s"}`,
+		FileTypeYAML: "_public_key: " + testPublicKey + "\ndb_password: %s\npublic_banner: %s\n",
+	}
+	for fileType, doc := range relabelled {
+		encrypted, err := EncryptData([]byte(fmt.Sprintf(doc, "hunter2", "hello")), fileType)
+		assertNoError(t, err)
+		password := msg.FindString(string(encrypted))
+		moved := fmt.Sprintf(doc, "x", "EJ[1:"+password[len("EJ[2:"):])
+		out, err := DecryptData([]byte(moved), nil, fileType, AllowUnencrypted())
+		if !errors.Is(err, crypto.ErrDecryptionFailed) || strings.Contains(err.Error(), "hunter2") || strings.Contains(string(out), "hunter2") {
+			t.Errorf("%s: expected relabelled value to fail to decrypt, got %v:\n%s", fileType, err, out)
+		}
+	}
+
+	// Formats which don't report key paths produce unbound values.
+	encrypted, err := EncryptData([]byte("_public_key = "+testPublicKey+"\na = x\n"), FileTypeINI)
+	assertNoError(t, err)
+	if !strings.Contains(string(encrypted), "EJ[1:") {
+		t.Errorf("expected unbound value, got\n%s", encrypted)
+	}
+}
+
+func TestHybridKeys(t *testing.T) {
+	pub, priv, err := GenerateHybridKeypair()
+	assertNoError(t, err)
+	if !strings.HasPrefix(pub, crypto.HybridKeyPrefix) {
+		t.Fatalf("expected hybrid public key, got %s", pub)
+	}
+	t.Setenv("ECFG_PRIVATE_KEY", priv)
+
+	docs := []struct {
+		fileType FileType
+		doc      string
+	}{
+		{FileTypeJSON, `{"_public_key": "` + pub + `", "a": "x"}`},
+		{FileTypeYAML, "_public_key: " + pub + "\na: x\n"},
+		{FileTypeTOML, "_public_key = \"" + pub + "\"\na = \"x\"\n"},
+		{FileTypeINI, "_public_key = " + pub + "\na = x\n"},
+	}
+	for _, d := range docs {
+		encrypted, err := EncryptData([]byte(d.doc), d.fileType)
+		assertNoError(t, err)
+		if !strings.Contains(string(encrypted), "EJ[3:") {
+			t.Errorf("%s: expected schema version 3, got\n%s", d.fileType, encrypted)
+		}
+		decrypted, err := DecryptData(encrypted, nil, d.fileType)
+		if err != nil || strings.Contains(string(decrypted), "EJ[") {
+			t.Errorf("%s: unexpected result: %s, %v", d.fileType, decrypted, err)
+		}
+
+		var out bytes.Buffer
+		assertNoError(t, EncryptStream(strings.NewReader(d.doc), &out, StreamOptions{FileType: d.fileType}))
+		if _, err := DecryptData(out.Bytes(), nil, d.fileType); err != nil {
+			t.Errorf("%s: unexpected error decrypting stream output: %v", d.fileType, err)
+		}
+	}
+
+	// values encrypted to the X25519 component alone decrypt alongside
+	id, err := PublicKeyID(pub)
+	assertNoError(t, err)
+	classic, err := EncryptData([]byte(`{"_public_key": "`+id+`", "a": "x"}`), FileTypeJSON)
+	assertNoError(t, err)
+	hybrid, err := EncryptData([]byte(`{"_public_key": "`+pub+`", "b": "y"}`), FileTypeJSON)
+	assertNoError(t, err)
+	var a, b map[string]string
+	assertNoError(t, json.Unmarshal(classic, &a))
+	assertNoError(t, json.Unmarshal(hybrid, &b))
+	mixed := `{"_public_key": "` + pub + `", "a": "` + a["a"] + `", "b": "` + b["b"] + `"}`
+	out, err := DecryptData([]byte(mixed), nil, FileTypeJSON)
+	if want := `{"_public_key": "` + pub + `", "a": "x", "b": "y"}`; err != nil || string(out) != want {
+		t.Errorf("expected %s, got %s, %v", want, out, err)
+	}
+
+	signingPub, signingPriv, err := GenerateSigningKeypair()
+	assertNoError(t, err)
+	signer, err := ParseSignerKey(signingPub)
+	assertNoError(t, err)
+	key, err := ParseSigningKey(signingPriv)
+	assertNoError(t, err)
+	signed, err := SignData(hybrid, FileTypeJSON, key)
+	assertNoError(t, err)
+	if _, err := VerifyData(signed, FileTypeJSON, []ed25519.PublicKey{signer}); err != nil {
+		t.Errorf("unexpected error verifying document with hybrid key: %v", err)
+	}
+
+	_, classicPriv, err := GenerateKeypair()
+	assertNoError(t, err)
+	t.Setenv("ECFG_PRIVATE_KEY", classicPriv)
+	if _, err := DecryptData(hybrid, nil, FileTypeJSON); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("expected mismatched private key to fail, got %v", err)
+	}
+
+	if _, err := PublicKeyID(crypto.HybridKeyPrefix + "AAAA"); err != format.ErrPublicKeyInvalid {
+		t.Errorf("expected ErrPublicKeyInvalid, got %v", err)
+	}
+}
+
+func TestContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	doc := []byte(`{"_public_key": "` + testPublicKey + `", "a": "b"}`)
+	c := &Client{FS: memFS{"a": doc}, Keypath: []string{"b"}}
+
+	_, err := EncryptDataContext(ctx, doc, FileTypeJSON)
+	if err != context.Canceled {
+		t.Errorf("EncryptDataContext: expected context.Canceled, got %v", err)
+	}
+	_, err = c.EncryptFileInPlace(ctx, "a", FileTypeJSON)
+	if err != context.Canceled {
+		t.Errorf("EncryptFileInPlace: expected context.Canceled, got %v", err)
+	}
+	_, err = DecryptDataContext(ctx, doc, []string{"b"}, FileTypeJSON)
+	if err != context.Canceled {
+		t.Errorf("DecryptDataContext: expected context.Canceled, got %v", err)
+	}
+	_, err = c.DecryptFile(ctx, "a", FileTypeJSON)
+	if err != context.Canceled {
+		t.Errorf("DecryptFile: expected context.Canceled, got %v", err)
+	}
 }
 
-func stubKeypathStuff(uid int, xdgConfigHome, home string) func() {
-	getuid = func() int { return uid }
-	getenv = func(k string) string {
-		if k == "XDG_CONFIG_HOME" {
-			return xdgConfigHome
+func TestTransformContextCancelledDuringTransform(t *testing.T) {
+	cases := []struct {
+		fileType              FileType
+		header, entry, footer string
+	}{
+		{FileTypeJSON, `{"_public_key": "` + testPublicKey + `"`, `, "key%d": "value"`, "}"},
+		{FileTypeYAML, "_public_key: " + testPublicKey + "\n", "key%d: value\n", ""},
+		{FileTypeTOML, `_public_key = "` + testPublicKey + `"` + "\n", "key%d = \"value\"\n", ""},
+	}
+	for _, tc := range cases {
+		var doc bytes.Buffer
+		doc.WriteString(tc.header)
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&doc, tc.entry, i)
+		}
+		doc.WriteString(tc.footer)
+
+		fh, err := format.Lookup(string(tc.fileType))
+		assertNoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int32
+		_, err = transformContext(ctx, fh, doc.Bytes(), func(bs, _ []byte) ([]byte, error) {
+			if atomic.AddInt32(&calls, 1) == 10 {
+				cancel()
+			}
+			return bs, nil
+		})
+		cancel()
+		if err != context.Canceled {
+			t.Errorf("%s: expected context.Canceled, got %v", tc.fileType, err)
+		}
+		if n := atomic.LoadInt32(&calls); n >= 1000 {
+			t.Errorf("%s: expected transform to stop early, but all %d values were visited", tc.fileType, n)
 		}
-		return home
 	}
-	return func() {
-		getuid = os.Getuid
-		getenv = os.Getenv
+}
+
+func stubKeypathStuff(uid int, xdgConfigHome, home string) *Client {
+	return &Client{
+		Getuid: func() int { return uid },
+		Getenv: func(k string) string {
+			if k == "XDG_CONFIG_HOME" {
+				return xdgConfigHome
+			}
+			return home
+		},
 	}
 }
 
 func TestKeypathsForNonRootWithXDGConfigHomeSet(t *testing.T) {
-	defer stubKeypathStuff(501, "/Users/me/.config", "/Users/me")()
+	c := stubKeypathStuff(501, "/Users/me/.config", "/Users/me")
 	{
 		expected := []string{"/Users/me/.config/ecfg/keys", "/Users/me/.ecfg/keys"}
-		if !reflect.DeepEqual(expected, UserKeypath()) {
+		if !reflect.DeepEqual(expected, c.UserKeypath()) {
 			t.Error("invalid keypath")
 		}
 	}
 	{
 		expected := []string{"/Users/me/.config/ecfg/keys", "/Users/me/.ecfg/keys", "/etc/ecfg/keys", "/opt/ejson/keys"}
-		if !reflect.DeepEqual(expected, DefaultKeypath()) {
+		if !reflect.DeepEqual(expected, c.DefaultKeypath()) {
 			t.Error("invalid keypath")
 		}
 	}
 }
 
 func TestKeypathsForNonRootWithoutXDGConfigHomeSet(t *testing.T) {
-	defer stubKeypathStuff(501, "", "/Users/me")()
+	c := stubKeypathStuff(501, "", "/Users/me")
 	{
 		expected := []string{"/Users/me/.ecfg/keys"}
-		if !reflect.DeepEqual(expected, UserKeypath()) {
+		if !reflect.DeepEqual(expected, c.UserKeypath()) {
 			t.Error("invalid keypath")
 		}
 	}
 	{
 		expected := []string{"/Users/me/.ecfg/keys", "/etc/ecfg/keys", "/opt/ejson/keys"}
-		if !reflect.DeepEqual(expected, DefaultKeypath()) {
+		if !reflect.DeepEqual(expected, c.DefaultKeypath()) {
 			t.Error("invalid keypath")
 		}
 	}
 }
 
 func TestKeypathsForRoot(t *testing.T) {
-	defer stubKeypathStuff(0, "/root/.config", "/root")()
+	c := stubKeypathStuff(0, "/root/.config", "/root")
 	{
-		if len(UserKeypath()) > 0 {
+		if len(c.UserKeypath()) > 0 {
 			t.Error("invalid keypath")
 		}
 	}
 	{
 		expected := []string{"/etc/ecfg/keys", "/opt/ejson/keys"}
-		if !reflect.DeepEqual(expected, DefaultKeypath()) {
+		if !reflect.DeepEqual(expected, c.DefaultKeypath()) {
 			t.Error("invalid keypath")
 		}
 	}
 }
+
+func TestFileTypes(t *testing.T) {
+	cases := map[string]FileType{
+		"a.ecfg.json":           FileTypeJSON,
+		"a.ejson":               FileTypeJSON,
+		"a.jsonc":               FileTypeJSONC,
+		"a.json5":               FileTypeJSONC,
+		"a.ecfg.yml":            FileTypeYAML,
+		"a.toml":                FileTypeTOML,
+		"prod.tfvars":           FileTypeHCL,
+		"php.ini":               FileTypeINI,
+		"app.properties":        FileTypeProperties,
+		"secrets/foo.ecfg.yaml": FileTypeYAML,
+	}
+	for name, expected := range cases {
+		actual, ok := FileTypeForFilename(name)
+		if !ok || actual != expected {
+			t.Errorf("expected %s to be %s, got %q", name, expected, actual)
+		}
+	}
+	if _, ok := FileTypeForFilename("foojson"); ok {
+		t.Errorf("expected foojson not to be recognized")
+	}
+
+	// JSON5 is only partly supported, by the jsonc format, so it isn't a type
+	for _, name := range []string{"nope", "json5"} {
+		_, err := EncryptData([]byte(`{}`), FileType(name))
+		if err == nil || !strings.Contains(err.Error(), "unknown format") {
+			t.Errorf("%s: wanted unknown format error, but got %v", name, err)
+		}
+	}
+}
+
+// BenchmarkTransform encrypts and decrypts documents of 10k and 100k values
+// (about 1MB and 10MB) in each format whose output builder isn't streamed.
+// The reported ns/value should stay roughly constant as the documents grow.
+func BenchmarkTransform(b *testing.B) {
+	b.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	entries := map[FileType]func(i int) string{
+		FileTypeYAML: func(i int) string { return fmt.Sprintf("key%06d: %s\n", i, strings.Repeat("v", 90)) },
+		FileTypeTOML: func(i int) string { return fmt.Sprintf("key%06d = \"%s\"\n", i, strings.Repeat("v", 90)) },
+	}
+	headers := map[FileType]string{
+		FileTypeYAML: "_public_key: " + testPublicKey + "\n",
+		FileTypeTOML: `_public_key = "` + testPublicKey + `"` + "\n",
+	}
+	for _, fileType := range []FileType{FileTypeYAML, FileTypeTOML} {
+		for _, n := range []int{10000, 100000} {
+			var doc bytes.Buffer
+			doc.WriteString(headers[fileType])
+			for i := 0; i < n; i++ {
+				doc.WriteString(entries[fileType](i))
+			}
+			encrypted, err := EncryptData(doc.Bytes(), fileType)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			for _, bc := range []struct {
+				name      string
+				in        []byte
+				transform func([]byte) ([]byte, error)
+			}{
+				{"encrypt", doc.Bytes(), func(data []byte) ([]byte, error) { return EncryptData(data, fileType) }},
+				{"decrypt", encrypted, func(data []byte) ([]byte, error) { return DecryptData(data, nil, fileType) }},
+			} {
+				b.Run(fmt.Sprintf("%s/%s/%d", fileType, bc.name, n), func(b *testing.B) {
+					b.SetBytes(int64(len(bc.in)))
+					for i := 0; i < b.N; i++ {
+						if _, err := bc.transform(bc.in); err != nil {
+							b.Fatal(err)
+						}
+					}
+					b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/value")
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Package ecfgtest provides utilities for testing code which consumes ecfg
+// documents: ephemeral keypairs, key directories, encrypted fixtures and an
+// in-memory KeyProvider. Everything it creates is removed or forgotten when
+// the test which created it finishes.
+package ecfgtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/ecfg"
+)
+
+// PublicKeyPlaceholder stands in for the public key in the plaintext
+// documents passed to EncryptFixture, e.g. as `_public_key:
+// ECFGTEST_PUBLIC_KEY` in a YAML document.
+const PublicKeyPlaceholder = "ECFGTEST_PUBLIC_KEY"
+
+// Keypair is an encoded keypair, as used in ecfg documents and key files.
+type Keypair struct {
+	Public  string
+	Private string
+}
+
+// NewKeypair generates an ephemeral keypair.
+func NewKeypair(t testing.TB) Keypair {
+	t.Helper()
+	pub, priv, err := ecfg.GenerateKeypair()
+	if err != nil {
+		t.Fatalf("ecfgtest: generating keypair: %v", err)
+	}
+	return Keypair{Public: pub, Private: priv}
+}
+
+// NewHybridKeypair generates an ephemeral hybrid X25519 + ML-KEM-768 keypair.
+func NewHybridKeypair(t testing.TB) Keypair {
+	t.Helper()
+	pub, priv, err := ecfg.GenerateHybridKeypair()
+	if err != nil {
+		t.Fatalf("ecfgtest: generating keypair: %v", err)
+	}
+	return Keypair{Public: pub, Private: priv}
+}
+
+// Keydir returns a temporary directory containing a key file for each of
+// keypairs, suitable for use as a keypath entry. It's removed when the test
+// finishes.
+func Keydir(t testing.TB, keypairs ...Keypair) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, kp := range keypairs {
+		id, err := ecfg.PublicKeyID(kp.Public)
+		if err != nil {
+			t.Fatalf("ecfgtest: invalid public key: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, id), []byte(kp.Private), 0440); err != nil {
+			t.Fatalf("ecfgtest: writing key file: %v", err)
+		}
+	}
+	return dir
+}
+
+// SetPrivateKey sets ECFG_PRIVATE_KEY to the private key of kp for the
+// duration of the test. Like t.Setenv, it can't be used in parallel tests.
+func SetPrivateKey(t testing.TB, kp Keypair) {
+	t.Helper()
+	t.Setenv("ECFG_PRIVATE_KEY", kp.Private)
+}
+
+// EncryptFixture encrypts plaintext, a document of the given type, to a new
+// ephemeral keypair, returning the encrypted document and the hex-encoded
+// private key which decrypts it. The document's public key must be given
+// as PublicKeyPlaceholder, which is replaced by the generated one.
+func EncryptFixture(t testing.TB, plaintext string, fileType ecfg.FileType) (doc []byte, privateKey string) {
+	t.Helper()
+	if !bytes.Contains([]byte(plaintext), []byte(PublicKeyPlaceholder)) {
+		t.Fatalf("ecfgtest: fixture has no %s", PublicKeyPlaceholder)
+	}
+	kp := NewKeypair(t)
+	data := bytes.ReplaceAll([]byte(plaintext), []byte(PublicKeyPlaceholder), []byte(kp.Public))
+	doc, err := ecfg.EncryptData(data, fileType)
+	if err != nil {
+		t.Fatalf("ecfgtest: encrypting fixture: %v", err)
+	}
+	return doc, kp.Private
+}
+
+// KeyProvider is an in-memory ecfg.KeyProvider. It's safe for concurrent
+// use.
+type KeyProvider struct {
+	mu   sync.Mutex
+	keys map[[32]byte][32]byte
+}
+
+// NewKeyProvider returns a KeyProvider holding the private keys of
+// keypairs. They're forgotten when the test finishes.
+func NewKeyProvider(t testing.TB, keypairs ...Keypair) *KeyProvider {
+	t.Helper()
+	p := &KeyProvider{keys: make(map[[32]byte][32]byte)}
+	for _, kp := range keypairs {
+		if err := p.Add(kp); err != nil {
+			t.Fatalf("ecfgtest: %v", err)
+		}
+	}
+	t.Cleanup(p.clear)
+	return p
+}
+
+// Add adds the private key of kp to p.
+func (p *KeyProvider) Add(kp Keypair) error {
+	id, err := ecfg.PublicKeyID(kp.Public)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	pub, err := decodeKey(id)
+	if err != nil {
+		return fmt.Errorf("invalid public key: %v", err)
+	}
+	priv, err := decodeKey(kp.Private)
+	if err != nil {
+		return fmt.Errorf("invalid private key: %v", err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keys == nil {
+		p.keys = make(map[[32]byte][32]byte)
+	}
+	p.keys[pub] = priv
+	return nil
+}
+
+// PrivateKey returns the private key added for pubkey.
+func (p *KeyProvider) PrivateKey(ctx context.Context, pubkey [32]byte) ([32]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	priv, ok := p.keys[pubkey]
+	if !ok {
+		return [32]byte{}, fmt.Errorf("ecfgtest: no private key for public key %x", pubkey)
+	}
+	return priv, nil
+}
+
+// clear forgets the private keys.
+func (p *KeyProvider) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = nil
+}
+
+// Client returns an ecfg.Client which finds private keys only in a
+// KeyProvider holding those of keypairs.
+func Client(t testing.TB, keypairs ...Keypair) *ecfg.Client {
+	t.Helper()
+	return &ecfg.Client{KeyProvider: NewKeyProvider(t, keypairs...)}
+}
+
+func decodeKey(s string) (key [32]byte, err error) {
+	bs, err := hex.DecodeString(s)
+	if err != nil {
+		return
+	}
+	if len(bs) != 32 {
+		err = fmt.Errorf("key must be 32 bytes, got %d", len(bs))
+		return
+	}
+	copy(key[:], bs)
+	return
+}
+
+// InsecureDeterministicRand returns a reader of pseudorandom bytes determined
+// entirely by seed, for use with ecfg.WithRand or ecfg.Client.Rand to make
+// encrypted output reproducible, e.g. in golden-file tests.
+//
+// DO NOT USE THIS OUTSIDE TESTS. Anything encrypted with randomness from it
+// can be decrypted by anyone who knows or guesses the seed.
+func InsecureDeterministicRand(t testing.TB, seed string) io.Reader {
+	t.Helper()
+	t.Logf("ecfgtest: using INSECURE deterministic randomness (seed %q)", seed)
+	return &deterministicReader{seed: sha256.Sum256([]byte(seed))}
+}
+
+// deterministicReader produces SHA-256(seed || counter) for successive
+// values of counter.
+type deterministicReader struct {
+	mu      sync.Mutex
+	seed    [32]byte
+	counter uint64
+	buf     []byte
+}
+
+func (r *deterministicReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for n < len(p) {
+		if len(r.buf) == 0 {
+			var block [40]byte
+			copy(block[:], r.seed[:])
+			binary.BigEndian.PutUint64(block[32:], r.counter)
+			r.counter++
+			sum := sha256.Sum256(block[:])
+			r.buf = sum[:]
+		}
+		c := copy(p[n:], r.buf)
+		r.buf = r.buf[c:]
+		n += c
+	}
+	return n, nil
+}
@@ -0,0 +1,137 @@
+package ecfgtest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/ecfg"
+)
+
+const fixture = "_public_key: " + PublicKeyPlaceholder + "\npassword: hunter2\n"
+
+func TestEncryptFixture(t *testing.T) {
+	doc, priv := EncryptFixture(t, fixture, ecfg.FileTypeYAML)
+	if bytes.Contains(doc, []byte("hunter2")) || bytes.Contains(doc, []byte(PublicKeyPlaceholder)) {
+		t.Fatalf("expected encrypted document with a public key, got\n%s", doc)
+	}
+
+	t.Setenv("ECFG_PRIVATE_KEY", priv)
+	out, err := ecfg.DecryptData(doc, nil, ecfg.FileTypeYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(`password: "hunter2"`)) {
+		t.Errorf("unexpected decrypted document:\n%s", out)
+	}
+}
+
+func TestKeydir(t *testing.T) {
+	kp := NewKeypair(t)
+	dir := Keydir(t, kp)
+	data, err := os.ReadFile(filepath.Join(dir, kp.Public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != kp.Private {
+		t.Errorf("expected key file to hold the private key, got %q", data)
+	}
+
+	doc, err := ecfg.EncryptData([]byte(`{"_public_key": "`+kp.Public+`", "a": "b"}`), ecfg.FileTypeJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ECFG_PRIVATE_KEY", "")
+	if _, err := ecfg.DecryptData(doc, []string{dir}, ecfg.FileTypeJSON); err != nil {
+		t.Errorf("unexpected error decrypting with keydir: %v", err)
+	}
+}
+
+func TestHybridKeypair(t *testing.T) {
+	kp := NewHybridKeypair(t)
+	doc, err := ecfg.EncryptData([]byte(`{"_public_key": "`+kp.Public+`", "a": "b"}`), ecfg.FileTypeJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ECFG_PRIVATE_KEY", "")
+	if _, err := ecfg.DecryptData(doc, []string{Keydir(t, kp)}, ecfg.FileTypeJSON); err != nil {
+		t.Errorf("unexpected error decrypting with keydir: %v", err)
+	}
+	if _, err := Client(t, kp).DecryptData(context.Background(), doc, ecfg.FileTypeJSON); err != nil {
+		t.Errorf("unexpected error decrypting with provider: %v", err)
+	}
+}
+
+func TestKeyProvider(t *testing.T) {
+	kp, other := NewKeypair(t), NewKeypair(t)
+	var p *KeyProvider
+	t.Run("provider", func(t *testing.T) {
+		p = NewKeyProvider(t, kp)
+		c := &ecfg.Client{KeyProvider: p}
+		doc, err := c.EncryptData(context.Background(), []byte(`{"_public_key": "`+kp.Public+`", "a": "b"}`), ecfg.FileTypeJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.DecryptData(context.Background(), doc, ecfg.FileTypeJSON); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		doc, err = c.EncryptData(context.Background(), []byte(`{"_public_key": "`+other.Public+`", "a": "b"}`), ecfg.FileTypeJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.DecryptData(context.Background(), doc, ecfg.FileTypeJSON); err == nil {
+			t.Errorf("expected error for unknown public key")
+		}
+	})
+
+	var pub [32]byte
+	copy(pub[:], mustDecode(t, kp.Public))
+	if _, err := p.PrivateKey(context.Background(), pub); err == nil {
+		t.Errorf("expected keys to be forgotten after the test")
+	}
+
+	if err := p.Add(Keypair{Public: "zz", Private: kp.Private}); err == nil {
+		t.Errorf("expected error adding invalid keypair")
+	}
+}
+
+func mustDecode(t *testing.T, s string) []byte {
+	key, err := decodeKey(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key[:]
+}
+
+func TestInsecureDeterministicRand(t *testing.T) {
+	read := func(seed string) []byte {
+		b := make([]byte, 100)
+		if _, err := io.ReadFull(InsecureDeterministicRand(t, seed), b); err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	if !bytes.Equal(read("a"), read("a")) {
+		t.Errorf("expected the same bytes from the same seed")
+	}
+	if bytes.Equal(read("a"), read("b")) {
+		t.Errorf("expected different bytes from different seeds")
+	}
+
+	kp := NewKeypair(t)
+	doc := []byte(`{"_public_key": "` + kp.Public + `", "a": "b", "c": ["d", "e"]}`)
+	encrypt := func() []byte {
+		out, err := ecfg.EncryptData(doc, ecfg.FileTypeJSON, ecfg.WithRand(InsecureDeterministicRand(t, "golden")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	if first, second := encrypt(), encrypt(); !bytes.Equal(first, second) {
+		t.Errorf("expected reproducible output, got\n%s\nand\n%s", first, second)
+	}
+}
@@ -0,0 +1,72 @@
+package ecfg
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// ValueError reports a value which couldn't be encrypted or decrypted, giving
+// its key path and position. Its Err is typically crypto.ErrDecryptionFailed
+// or wraps crypto.ErrInvalidMessage, which errors.Is sees through it.
+//
+// Functions which read documents from files set its File field.
+type ValueError = format.ValueError
+
+// SyntaxError reports a document which couldn't be parsed, with the position
+// of the problem if the format handler knows it.
+//
+// Functions which read documents from files set its File field.
+type SyntaxError = format.SyntaxError
+
+// PartialDecryptError is returned along with the document by best-effort
+// decryption (see Client.KeepGoing) if some of its values couldn't be
+// decrypted. errors.Is and errors.As see through it to each ValueError.
+type PartialDecryptError struct {
+	// Errors lists the values which couldn't be decrypted, in document order.
+	Errors []*ValueError
+}
+
+func (e *PartialDecryptError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	msgs := []string{"couldn't decrypt " + strconv.Itoa(len(e.Errors)) + " values:"}
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e *PartialDecryptError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// withFile records name as the file in which err occurred, if it's or wraps a
+// *ValueError, *SyntaxError or *PartialDecryptError.
+func withFile(err error, name string) error {
+	var partialErr *PartialDecryptError
+	if errors.As(err, &partialErr) {
+		for _, valueErr := range partialErr.Errors {
+			if valueErr.File == "" {
+				valueErr.File = name
+			}
+		}
+		return err
+	}
+	var valueErr *ValueError
+	if errors.As(err, &valueErr) && valueErr.File == "" {
+		valueErr.File = name
+	}
+	var syntaxErr *SyntaxError
+	if errors.As(err, &syntaxErr) && syntaxErr.File == "" {
+		syntaxErr.File = name
+	}
+	return err
+}
@@ -0,0 +1,149 @@
+package ecfg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+)
+
+// foreignMessage returns a message encrypted to a key other than
+// testPublicKey.
+func foreignMessage(t *testing.T) string {
+	var kp, other crypto.Keypair
+	if err := kp.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := kp.Encrypter(other.Public).Encrypt([]byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func TestValueError(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	foreign := foreignMessage(t)
+	cases := []struct {
+		fileType     FileType
+		doc          string
+		path         string
+		line, column int
+		cause        error
+	}{
+		{FileTypeJSON, "{\n  \"_public_key\": \"" + testPublicKey + "\",\n  \"a\": {\"b\": \"" + foreign + "\"}\n}", "a.b", 3, 14, crypto.ErrDecryptionFailed},
+		{FileTypeYAML, "_public_key: " + testPublicKey + "\na:\n  - ok\n  - " + foreign + "\n", "a[1]", 4, 5, crypto.ErrDecryptionFailed},
+		{FileTypeTOML, "_public_key = \"" + testPublicKey + "\"\n[a]\nb = \"EJ[1:bad]\"\n", "a.b", 3, 5, crypto.ErrInvalidMessage},
+	}
+	for _, tc := range cases {
+		_, err := DecryptData([]byte(tc.doc), nil, tc.fileType, AllowUnencrypted())
+		var valueErr *ValueError
+		if !errors.As(err, &valueErr) {
+			t.Errorf("%s: expected *ValueError, got %v", tc.fileType, err)
+			continue
+		}
+		if valueErr.Path.String() != tc.path || valueErr.Line != tc.line || valueErr.Column != tc.column {
+			t.Errorf("%s: expected %s at %d:%d, got %v", tc.fileType, tc.path, tc.line, tc.column, err)
+		}
+		if !errors.Is(err, tc.cause) {
+			t.Errorf("%s: expected error to wrap %v, got %v", tc.fileType, tc.cause, err)
+		}
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	cases := []struct {
+		fileType FileType
+		doc      string
+		line     int
+	}{
+		{FileTypeJSON, "{\n  \"_public_key\": \"" + testPublicKey + "\",\n  \"a\": x\n}", 3},
+		{FileTypeYAML, "_public_key: " + testPublicKey + "\na: b\n  c: d\n", 3},
+		{FileTypeTOML, "_public_key = \"" + testPublicKey + "\"\na = \"b\"\nc = \n", 3},
+	}
+	for _, tc := range cases {
+		_, err := EncryptData([]byte(tc.doc), tc.fileType)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected *SyntaxError, got %v", tc.fileType, err)
+		} else if syntaxErr.Line != tc.line {
+			t.Errorf("%s: expected line %d, got %v", tc.fileType, tc.line, err)
+		}
+	}
+}
+
+func TestErrorFile(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	path := filepath.Join(t.TempDir(), "secrets.ejson")
+	doc := `{"_public_key": "` + testPublicKey + `", "a": "` + foreignMessage(t) + `"}`
+	if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := DecryptFile(path, nil, FileTypeJSON)
+	var valueErr *ValueError
+	if !errors.As(err, &valueErr) || valueErr.File != path {
+		t.Fatalf("expected *ValueError in %s, got %v", path, err)
+	}
+	if want := path + ":1:90: a: couldn't decrypt message"; err.Error() != want {
+		t.Errorf("expected %q, got %q", want, err.Error())
+	}
+
+	if err := LoadFile(path, &map[string]string{}); !errors.As(err, &valueErr) || valueErr.File != path {
+		t.Errorf("expected *ValueError in %s, got %v", path, err)
+	}
+}
+
+func TestPartialDecryptError(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	foreign := foreignMessage(t)
+	doc := []byte("_public_key: " + testPublicKey + "\na: " + foreign + "\nb: ok\nc:\n  - EJ[1:bad]\n")
+
+	if _, err := DecryptData(doc, nil, FileTypeYAML); err == nil {
+		t.Fatal("expected error without KeepGoing")
+	}
+
+	cases := []struct {
+		placeholder string
+		match       *regexp.Regexp
+	}{
+		{"", regexp.MustCompile(`(?s)a: EJ\[.*\nb: "ok"\nc:\n  - EJ\[1:bad\]\n$`)},
+		{"REDACTED", regexp.MustCompile(`(?s)a: "REDACTED"\nb: "ok"\nc:\n  - "REDACTED"\n$`)},
+	}
+	for _, tc := range cases {
+		out, err := DecryptData(doc, nil, FileTypeYAML, KeepGoing(tc.placeholder), AllowUnencrypted())
+		if !tc.match.Match(out) {
+			t.Errorf("%q: unexpected output:\n%s", tc.placeholder, out)
+		}
+		var partialErr *PartialDecryptError
+		if !errors.As(err, &partialErr) || len(partialErr.Errors) != 2 {
+			t.Fatalf("%q: expected *PartialDecryptError with 2 errors, got %v", tc.placeholder, err)
+		}
+		if first, second := partialErr.Errors[0], partialErr.Errors[1]; first.Path.String() != "a" || second.Path.String() != "c[0]" {
+			t.Errorf("%q: unexpected errors: %v", tc.placeholder, err)
+		}
+		if !errors.Is(err, crypto.ErrDecryptionFailed) || !errors.Is(err, crypto.ErrInvalidMessage) {
+			t.Errorf("%q: expected error to wrap both causes, got %v", tc.placeholder, err)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets.ecfg.yaml")
+	if err := os.WriteFile(path, doc, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := DecryptFile(path, nil, FileTypeYAML, KeepGoing(""), AllowUnencrypted())
+	want := "couldn't decrypt 2 values:\n" + path + ":2:4: a: couldn't decrypt message\n" + path + ":5:5: c[0]: invalid message format"
+	if err == nil || err.Error() != want {
+		t.Errorf("expected %q, got %v", want, err)
+	}
+
+	if _, err := DecryptData([]byte(`{"_public_key": "`+testPublicKey+`", "a": x}`), nil, FileTypeJSON, KeepGoing("")); !errors.As(err, new(*SyntaxError)) {
+		t.Errorf("expected syntax errors to abort, got %v", err)
+	}
+}
@@ -0,0 +1,360 @@
+package ecfg
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/ecfg/pkg/format"
+	"github.com/Shopify/ecfg/pkg/toml"
+	"github.com/Shopify/ecfg/pkg/yaml"
+)
+
+// encoders serialize Go values as documents of each type which can be
+// marshaled, without a public key. Each honors the struct tags of its own
+// format: `json`, `yaml` or `toml`.
+var encoders = map[FileType]func(v interface{}) ([]byte, error){
+	FileTypeJSON:  encodeJSON,
+	FileTypeJSONC: encodeJSON,
+	FileTypeYAML:  yaml.Marshal,
+	FileTypeTOML:  encodeTOML,
+}
+
+// Marshal serializes v, which must be a struct or map, as an ecfg document
+// of the given type whose values are encrypted to pubkey, a hex-encoded
+// public key. The document is produced by encoding/json (indented by two
+// spaces) or the bundled pkg/yaml and pkg/toml encoders, with `_public_key`
+// inserted as its first key.
+//
+// If no field of v is tagged `ecfg:"secret"`, every encryptable value is
+// encrypted, exactly as `ecfg encrypt` would encrypt the unencrypted
+// document. Otherwise, only the strings in tagged fields (including those
+// nested in tagged maps, slices and structs) and Secrets are encrypted, and
+// other values are left in plaintext. Unmarshal decodes such documents, but
+// decrypting them otherwise needs AllowUnencrypted; note also that `ecfg
+// encrypt` would encrypt the plaintext values if run on the document later,
+// unless their keys begin with an underscore. Only
+// strings and Secrets can be encrypted, so tagged fields holding anything
+// else, such as numbers or []byte, are an error. Secrets are encoded as their
+// value, to be encrypted, rather than redacted.
+func Marshal(v interface{}, fileType FileType, pubkey string, opts ...EncryptOption) ([]byte, error) {
+	return encryptClient(opts).Marshal(context.Background(), v, fileType, pubkey)
+}
+
+// Marshal is like the package-level Marshal, using c.Rand and c.Handlers.
+func (c *Client) Marshal(ctx context.Context, v interface{}, fileType FileType, pubkey string) ([]byte, error) {
+	encode, ok := encoders[fileType]
+	if !ok {
+		return nil, fmt.Errorf("can't marshal %s documents", fileType)
+	}
+	key, err := parsePublicKey(pubkey)
+	if err != nil {
+		return nil, err
+	}
+	fh, err := c.handler(fileType)
+	if err != nil {
+		return nil, err
+	}
+	fh = c.encryptHandler(fh)
+	walker, ok := fh.(format.ScalarValueWalker)
+	if !ok {
+		return nil, fmt.Errorf("can't marshal %s documents", fileType)
+	}
+
+	var secret func(format.Path) bool
+	r, err := newSecretReplacer()
+	if err != nil {
+		return nil, err
+	}
+	replaced, err := r.copy(reflect.ValueOf(v), false)
+	if err != nil {
+		return nil, err
+	}
+
+	unreplaced := v
+	if r.secrets {
+		r.reveal = true
+		revealed, err := r.copy(reflect.ValueOf(v), false)
+		if err != nil {
+			return nil, err
+		}
+		unreplaced = revealed.Interface()
+	}
+	doc, err := encodeWithPublicKey(encode, unreplaced, fileType, pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.tagged {
+		placeheld, err := encodeWithPublicKey(encode, replaced.Interface(), fileType, pubkey)
+		if err != nil {
+			return nil, err
+		}
+		if secret, err = r.secretPaths(walker, placeheld); err != nil {
+			return nil, err
+		}
+	}
+
+	encrypter, err := c.encrypter(key)
+	if err != nil {
+		return nil, err
+	}
+	encrypt := boundVisitor(ctx, encrypter.EncryptBound)
+	out, err := walker.WalkScalarValues(doc, func(sv format.ScalarValue) ([]byte, error) {
+		if secret != nil && !secret(sv.Path) {
+			return nil, nil
+		}
+		return encrypt(sv)
+	})
+	return out, contextErr(ctx, err)
+}
+
+// encodeWithPublicKey encodes v and inserts the public key as the first key
+// of the resulting document.
+func encodeWithPublicKey(encode func(interface{}) ([]byte, error), v interface{}, fileType FileType, pubkey string) ([]byte, error) {
+	doc, err := encode(v)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := decoders[fileType](doc, &obj); err != nil {
+		return nil, fmt.Errorf("can't marshal %T as an ecfg document: it must be a struct or map", v)
+	}
+	if _, ok := obj[format.PublicKeyField]; ok {
+		return nil, fmt.Errorf("can't marshal %T: it already has a %s key", v, format.PublicKeyField)
+	}
+
+	switch fileType {
+	case FileTypeJSON, FileTypeJSONC:
+		field := fmt.Sprintf("  %q: %q", format.PublicKeyField, pubkey)
+		if len(obj) == 0 {
+			return []byte("{\n" + field + "\n}\n"), nil
+		}
+		return append([]byte("{\n"+field+","), append(doc[1:], '\n')...), nil
+	case FileTypeYAML:
+		line := fmt.Sprintf("%s: %s\n", format.PublicKeyField, pubkey)
+		if len(obj) == 0 {
+			return []byte(line), nil
+		}
+		return append([]byte(line), doc...), nil
+	default:
+		line := fmt.Sprintf("%s = %q\n", format.PublicKeyField, pubkey)
+		return append([]byte(line), doc...), nil
+	}
+}
+
+func encodeJSON(v interface{}) ([]byte, error) {
+	return json.MarshalIndent(v, "", "  ")
+}
+
+func encodeTOML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// secretReplacer makes copies of Go values in which the strings of fields
+// tagged `ecfg:"secret"`, and Secrets, are replaced by unique placeholders,
+// so that the paths at which they're encoded can be found in the document.
+type secretReplacer struct {
+	prefix string
+	count  int
+	// tagged records whether any tagged field was found, and secrets whether
+	// any Secret was.
+	tagged  bool
+	secrets bool
+	// reveal makes copy replace Secrets with copies encoded as their value,
+	// and leave strings as they are, rather than using placeholders.
+	reveal bool
+}
+
+func newSecretReplacer() (*secretReplacer, error) {
+	var nonce [8]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	return &secretReplacer{prefix: "ecfgsecret" + hex.EncodeToString(nonce[:]) + "x"}, nil
+}
+
+func isSecretField(f reflect.StructField) bool {
+	for _, opt := range strings.Split(f.Tag.Get("ecfg"), ",") {
+		if opt == "secret" {
+			return true
+		}
+	}
+	return false
+}
+
+// copy returns a deep copy of v, replacing strings with placeholders if
+// secret is set. Values which can't be replaced, because they aren't encoded
+// as strings, are an error if secret is set.
+func (r *secretReplacer) copy(v reflect.Value, secret bool) (reflect.Value, error) {
+	if v.IsValid() && v.Type() == secretType {
+		r.secrets = true
+		b := v.Interface().(Secret).RevealBytes()
+		if !r.reveal {
+			b = []byte(r.placeholder())
+		}
+		return reflect.ValueOf(Secret{v: &secretValue{b: b, encode: true}}), nil
+	}
+	if secret && v.IsValid() && hasMarshaler(v.Type()) {
+		return v, unencryptable(v.Type())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		if !secret || r.reveal {
+			return v, nil
+		}
+		out := reflect.New(v.Type()).Elem()
+		out.SetString(r.placeholder())
+		return out, nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return v, nil
+		}
+		elem, err := r.copy(v.Elem(), secret)
+		if err != nil {
+			return v, err
+		}
+		out := reflect.New(v.Type()).Elem()
+		if v.Kind() == reflect.Ptr {
+			out.Set(reflect.New(v.Type().Elem()))
+			out.Elem().Set(elem)
+		} else {
+			out.Set(elem)
+		}
+		return out, nil
+	case reflect.Struct:
+		out := reflect.New(v.Type()).Elem()
+		out.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			fieldSecret := isSecretField(f)
+			if fieldSecret {
+				r.tagged = true
+			}
+			field, err := r.copy(v.Field(i), secret || fieldSecret)
+			if err != nil {
+				if fieldSecret && !secret {
+					err = fmt.Errorf("can't encrypt field %s tagged secret: %w", f.Name, err)
+				}
+				return v, err
+			}
+			out.Field(i).Set(field)
+		}
+		return out, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return v, nil
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			elem, err := r.copy(iter.Value(), secret)
+			if err != nil {
+				return v, err
+			}
+			out.SetMapIndex(iter.Key(), elem)
+		}
+		return out, nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return v, nil
+		}
+		if secret && v.Type().Elem().Kind() == reflect.Uint8 {
+			// encoded as a single base64 string
+			return v, unencryptable(v.Type())
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem, err := r.copy(v.Index(i), secret)
+			if err != nil {
+				return v, err
+			}
+			out.Index(i).Set(elem)
+		}
+		return out, nil
+	case reflect.Array:
+		out := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			elem, err := r.copy(v.Index(i), secret)
+			if err != nil {
+				return v, err
+			}
+			out.Index(i).Set(elem)
+		}
+		return out, nil
+	case reflect.Invalid:
+		return v, nil
+	default:
+		if secret {
+			return v, unencryptable(v.Type())
+		}
+		return v, nil
+	}
+}
+
+// placeholder returns a new placeholder.
+func (r *secretReplacer) placeholder() string {
+	r.count++
+	return fmt.Sprintf("%s%d", r.prefix, r.count-1)
+}
+
+var (
+	secretType        = reflect.TypeOf(Secret{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	yamlMarshalerType = reflect.TypeOf((*yaml.Marshaler)(nil)).Elem()
+)
+
+// hasMarshaler reports whether values of type t encode themselves, so that
+// their strings can't be replaced.
+func hasMarshaler(t reflect.Type) bool {
+	for _, i := range []reflect.Type{jsonMarshalerType, textMarshalerType, yamlMarshalerType} {
+		if t.Implements(i) || reflect.PtrTo(t).Implements(i) {
+			return true
+		}
+	}
+	return false
+}
+
+func unencryptable(t reflect.Type) error {
+	return fmt.Errorf("values of type %s can't be encrypted, only strings and Secrets", t)
+}
+
+// secretPaths finds the placeholders in doc, returning a function reporting
+// whether a path is one of theirs. Every placeholder must be encryptable, so
+// that no secret is left in plaintext.
+func (r *secretReplacer) secretPaths(walker format.ScalarValueWalker, doc []byte) (func(format.Path) bool, error) {
+	var (
+		mu    sync.Mutex
+		paths = make(map[string]bool, r.count)
+	)
+	_, err := walker.WalkScalarValues(doc, func(sv format.ScalarValue) ([]byte, error) {
+		if bytes.HasPrefix(sv.Value, []byte(r.prefix)) {
+			mu.Lock()
+			paths[sv.Path.String()] = true
+			mu.Unlock()
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) != r.count {
+		return nil, fmt.Errorf("can't encrypt %d of the fields tagged secret: keys beginning with an underscore are never encrypted", r.count-len(paths))
+	}
+	return func(p format.Path) bool { return paths[p.String()] }, nil
+}
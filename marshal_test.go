@@ -0,0 +1,146 @@
+package ecfg
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+)
+
+type marshalConfig struct {
+	User     string            `json:"user" yaml:"user" toml:"user"`
+	Password string            `json:"password" yaml:"password" toml:"password" ecfg:"secret"`
+	Tokens   map[string]string `json:"tokens" yaml:"tokens" toml:"tokens" ecfg:"secret"`
+}
+
+func TestMarshalAllStrings(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	v := map[string]interface{}{"user": "admin", "password": "hunter2", "_comment": "kept"}
+	// The documents `ecfg encrypt` would be run on.
+	plaintext := map[FileType]string{
+		FileTypeJSON: "{\n  \"_public_key\": \"" + testPublicKey + "\",\n  \"_comment\": \"kept\",\n  \"password\": \"hunter2\",\n  \"user\": \"admin\"\n}\n",
+		FileTypeYAML: "_public_key: " + testPublicKey + "\n_comment: kept\npassword: hunter2\nuser: admin\n",
+		FileTypeTOML: "_public_key = \"" + testPublicKey + "\"\n_comment = \"kept\"\npassword = \"hunter2\"\nuser = \"admin\"\n",
+	}
+	for fileType, plain := range plaintext {
+		out, err := Marshal(v, fileType, testPublicKey)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", fileType, err)
+			continue
+		}
+		if bytes.Contains(out, []byte("hunter2")) || bytes.Contains(out, []byte("admin")) || !bytes.Contains(out, []byte("kept")) {
+			t.Errorf("%s: expected every encryptable value to be encrypted:\n%s", fileType, out)
+		}
+		encrypted, err := EncryptData([]byte(plain), fileType)
+		assertNoError(t, err)
+		expected, err := DecryptData(encrypted, nil, fileType)
+		assertNoError(t, err)
+		decrypted, err := DecryptData(out, nil, fileType)
+		assertNoError(t, err)
+		if !bytes.Equal(decrypted, expected) {
+			t.Errorf("%s: expected decrypted document\n%s\ngot\n%s", fileType, expected, decrypted)
+		}
+	}
+}
+
+func TestMarshalSecretFields(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	v := marshalConfig{User: "admin", Password: "hunter2", Tokens: map[string]string{"github": "ghp_x"}}
+	for _, fileType := range []FileType{FileTypeJSON, FileTypeYAML, FileTypeTOML} {
+		out, err := Marshal(&v, fileType, testPublicKey)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", fileType, err)
+			continue
+		}
+		if !bytes.Contains(out, []byte("admin")) || bytes.Contains(out, []byte("hunter2")) || bytes.Contains(out, []byte("ghp_x")) {
+			t.Errorf("%s: expected only secret fields to be encrypted:\n%s", fileType, out)
+		}
+		if !strings.HasPrefix(string(out), "{\n  \"_public_key\"") && !strings.HasPrefix(string(out), "_public_key") {
+			t.Errorf("%s: expected _public_key first:\n%s", fileType, out)
+		}
+
+		var roundtripped marshalConfig
+		if err := Unmarshal(out, fileType, nil, &roundtripped); err != nil {
+			t.Errorf("%s: unexpected error: %v", fileType, err)
+		} else if !reflect.DeepEqual(roundtripped, v) {
+			t.Errorf("%s: expected %+v, got %+v", fileType, v, roundtripped)
+		}
+
+		// Decryption only leaves the unencrypted values alone if asked to.
+		if _, err := DecryptData(out, nil, fileType); !errors.Is(err, crypto.ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", fileType, err)
+		}
+		if decrypted, err := DecryptData(out, nil, fileType, AllowUnencrypted()); err != nil || !bytes.Contains(decrypted, []byte("hunter2")) {
+			t.Errorf("%s: unexpected decryption: %v\n%s", fileType, err, decrypted)
+		}
+	}
+	if v.Password != "hunter2" || v.Tokens["github"] != "ghp_x" {
+		t.Errorf("Marshal modified its argument: %+v", v)
+	}
+}
+
+func TestMarshalSecret(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	type tagged struct {
+		User     string `json:"user" yaml:"user" toml:"user"`
+		Password Secret `json:"password" yaml:"password" toml:"password" ecfg:"secret"`
+		Token    string `json:"token" yaml:"token" toml:"token" ecfg:"secret"`
+	}
+	for _, fileType := range []FileType{FileTypeJSON, FileTypeYAML, FileTypeTOML} {
+		for _, v := range []interface{}{
+			secretConfig{User: "admin", Password: NewSecretString("hunter2")},
+			&tagged{User: "admin", Password: NewSecretString("hunter2"), Token: "ghp_x"},
+		} {
+			out, err := Marshal(v, fileType, testPublicKey)
+			if err != nil {
+				t.Errorf("%s: %T: unexpected error: %v", fileType, v, err)
+				continue
+			}
+			if bytes.Contains(out, []byte("hunter2")) || bytes.Contains(out, []byte("REDACTED")) || bytes.Contains(out, []byte("ghp_x")) {
+				t.Errorf("%s: %T: expected the Secret to be encrypted:\n%s", fileType, v, out)
+			}
+
+			var roundtripped tagged
+			if err := Unmarshal(out, fileType, nil, &roundtripped); err != nil {
+				t.Errorf("%s: %T: unexpected error: %v", fileType, v, err)
+			} else if roundtripped.User != "admin" || roundtripped.Password.Reveal() != "hunter2" {
+				t.Errorf("%s: %T: unexpected result: %s, %s", fileType, v, roundtripped.User, roundtripped.Password.Reveal())
+			}
+		}
+	}
+}
+
+func TestMarshalErrors(t *testing.T) {
+	type underscore struct {
+		Secret string `json:"_secret" ecfg:"secret"`
+	}
+	type key struct {
+		Key []byte `json:"key" ecfg:"secret"`
+	}
+	type port struct {
+		Ports map[string]int `json:"ports" ecfg:"secret"`
+	}
+	cases := []struct {
+		v        interface{}
+		fileType FileType
+		pubkey   string
+		err      string
+	}{
+		{map[string]string{"a": "b"}, FileTypeJSON, "invalid", "invalid format"},
+		{[]string{"a"}, FileTypeJSON, testPublicKey, "must be a struct or map"},
+		{map[string]string{"_public_key": "x"}, FileTypeYAML, testPublicKey, "already has a _public_key"},
+		{underscore{"a"}, FileTypeJSON, testPublicKey, "keys beginning with an underscore"},
+		{map[string]string{"a": "b"}, FileTypeINI, testPublicKey, "can't marshal ini"},
+		{key{[]byte("topsecretbytes")}, FileTypeJSON, testPublicKey, "can't encrypt field Key tagged secret: values of type []uint8"},
+		{port{map[string]int{"http": 80}}, FileTypeYAML, testPublicKey, "can't encrypt field Ports tagged secret: values of type int"},
+	}
+	for _, tc := range cases {
+		_, err := Marshal(tc.v, tc.fileType, tc.pubkey)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%#v: wanted error containing %q, but got %v", tc.v, tc.err, err)
+		}
+	}
+}
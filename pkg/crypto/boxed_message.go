@@ -2,25 +2,38 @@ package crypto
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
 )
 
-var messageParser = regexp.MustCompile("\\AEJ\\[(\\d):([A-z0-9+=/]{44}):([A-z0-9+=/]{32}):(.+)\\]\\z")
+var messageParser = regexp.MustCompile("\\AEJ\\[(\\d+):(.+)\\]\\z")
+
+var boxFieldsParser = regexp.MustCompile("\\A([A-z0-9+=/]{44}):([A-z0-9+=/]{32}):(.+)\\z")
 
 // boxedMessage dumps and loads the wire format for encrypted messages. The
 // schema is fairly simple:
 //
 //   "EJ["
-//   SchemaVersion ( "1" )
+//   SchemaVersion :: decimal integer
 //   ":"
+//   Fields :: depends on SchemaVersion
+//   "]"
+//
+// The fields of each schema version are parsed and dumped by the codec
+// registered for it. Versions 1, 2 and 3 share the same fields:
+//
 //   EncrypterPublic :: base64-encoded 32-byte key
 //   ":"
 //   Nonce :: base64-encoded 24-byte nonce
 //   ":"
 //   Box :: base64-encoded encrypted message
-//   "]"
+//
+// In schema version 2, the encrypted message begins with a SHA-256 digest
+// binding it to its context (see Encrypter.EncryptBound), and the box is a
+// secretbox under a key derived for that version alone. In schema version 3,
+// the box is preceded by an ML-KEM-768 ciphertext (see HybridEncrypter).
 type boxedMessage struct {
 	SchemaVersion   int
 	EncrypterPublic [32]byte
@@ -30,53 +43,79 @@ type boxedMessage struct {
 
 // isBoxedMessage tests whether a value is formatted using the boxedMessage
 // format. This can be used to determine whether a string value requires
-// encryption or is already encrypted.
+// encryption or is already encrypted. Values of schema versions without a
+// codec are assumed to be encrypted by a newer version of ecfg, so that
+// they're left alone rather than encrypted again.
 func isBoxedMessage(data []byte) bool {
-	return messageParser.Find(data) != nil
+	matches := messageParser.FindSubmatch(data)
+	if matches == nil {
+		return false
+	}
+	version, err := strconv.Atoi(string(matches[1]))
+	if err != nil {
+		return false
+	}
+	c, err := lookupCodec(version)
+	if errors.Is(err, ErrUnsupportedVersion) {
+		return true
+	}
+	return err == nil && c.match(string(matches[2]))
+}
+
+// MessageVersion returns the schema version of message, if it's an encrypted
+// message as recognised by Encrypt, including one of an unsupported version.
+func MessageVersion(message []byte) (int, bool) {
+	if !isBoxedMessage(message) {
+		return 0, false
+	}
+	version, _ := strconv.Atoi(string(messageParser.FindSubmatch(message)[1]))
+	return version, true
 }
 
 // Dump dumps to the wire format
 func (b *boxedMessage) Dump() []byte {
-	pub := base64.StdEncoding.EncodeToString(b.EncrypterPublic[:])
-	nonce := base64.StdEncoding.EncodeToString(b.Nonce[:])
-	box := base64.StdEncoding.EncodeToString(b.Box)
-
-	str := fmt.Sprintf("EJ[%d:%s:%s:%s]",
-		b.SchemaVersion, pub, nonce, box)
-	return []byte(str)
+	c, err := lookupCodec(b.SchemaVersion)
+	if err != nil {
+		panic(err) // boxedMessages are only made by registered codecs
+	}
+	return []byte(fmt.Sprintf("EJ[%d:%s]", b.SchemaVersion, c.dump(b)))
 }
 
-// Load restores from the wire format.
+// Load restores from the wire format. Messages of schema versions without a
+// codec fail with an error wrapping ErrUnsupportedVersion.
 func (b *boxedMessage) Load(from []byte) error {
-	var ssver, spub, snonce, sbox string
-	var err error
-
-	allMatches := messageParser.FindAllStringSubmatch(string(from), -1) // -> [][][]byte
-	if len(allMatches) != 1 {
-		return fmt.Errorf("invalid message format")
-	}
-	matches := allMatches[0]
-	if len(matches) != 5 {
-		return fmt.Errorf("invalid message format")
+	matches := messageParser.FindSubmatch(from)
+	if matches == nil {
+		return ErrInvalidMessage
 	}
 
-	ssver = matches[1]
-	spub = matches[2]
-	snonce = matches[3]
-	sbox = matches[4]
-
-	b.SchemaVersion, err = strconv.Atoi(ssver)
+	version, err := strconv.Atoi(string(matches[1]))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+	c, err := lookupCodec(version)
 	if err != nil {
 		return err
 	}
+	b.SchemaVersion = version
+	return c.parse(string(matches[2]), b)
+}
+
+// parseBoxFields parses the fields shared by schema versions 1 to 3.
+func parseBoxFields(fields string, b *boxedMessage) error {
+	matches := boxFieldsParser.FindStringSubmatch(fields)
+	if matches == nil {
+		return ErrInvalidMessage
+	}
+	spub, snonce, sbox := matches[1], matches[2], matches[3]
 
 	pub, err := base64.StdEncoding.DecodeString(spub)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
 	}
 	pubBytes := []byte(pub)
 	if len(pubBytes) != 32 {
-		return fmt.Errorf("public key invalid")
+		return fmt.Errorf("%w: public key invalid", ErrInvalidMessage)
 	}
 	var public [32]byte
 	copy(public[:], pubBytes[0:32])
@@ -84,11 +123,11 @@ func (b *boxedMessage) Load(from []byte) error {
 
 	nnc, err := base64.StdEncoding.DecodeString(snonce)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
 	}
 	nonceBytes := []byte(nnc)
 	if len(nonceBytes) != 24 {
-		return fmt.Errorf("nonce invalid")
+		return fmt.Errorf("%w: nonce invalid", ErrInvalidMessage)
 	}
 	var nonce [24]byte
 	copy(nonce[:], nonceBytes[0:24])
@@ -96,9 +135,17 @@ func (b *boxedMessage) Load(from []byte) error {
 
 	box, err := base64.StdEncoding.DecodeString(sbox)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", ErrInvalidMessage, err)
 	}
 	b.Box = []byte(box)
 
 	return nil
 }
+
+// dumpBoxFields dumps the fields shared by schema versions 1 to 3.
+func dumpBoxFields(b *boxedMessage) string {
+	pub := base64.StdEncoding.EncodeToString(b.EncrypterPublic[:])
+	nonce := base64.StdEncoding.EncodeToString(b.Nonce[:])
+	box := base64.StdEncoding.EncodeToString(b.Box)
+	return pub + ":" + nonce + ":" + box
+}
@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -51,3 +52,17 @@ func TestBoxedMessageRoundtripping(t *testing.T) {
 		t.Errorf("isBoxedMessage incorrect")
 	}
 }
+
+func TestBoxedMessageLoadErrors(t *testing.T) {
+	for _, wire := range []string{
+		"EJ[garbage]",
+		"EJ[1:AQEB:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]",
+		"EJ[1:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgIC:AwMD]",
+		"EJ[1:AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:A]",
+	} {
+		var bm boxedMessage
+		if err := bm.Load([]byte(wire)); !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrInvalidMessage, got %v", wire, err)
+		}
+	}
+}
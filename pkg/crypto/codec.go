@@ -0,0 +1,153 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// ErrUnsupportedVersion means a message has a schema version for which no
+// codec is registered, typically because it was written by a newer version
+// of ecfg. Errors naming the version wrap it, along with ErrInvalidMessage.
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// codec implements one schema version of the wire format described on
+// boxedMessage.
+type codec struct {
+	version int
+	// match reports whether fields have the form of the fields of a
+	// message, without decoding them.
+	match func(fields string) bool
+	// parse decodes the fields of a message, the text between "EJ[V:" and
+	// the closing "]", into bm.
+	parse func(fields string, bm *boxedMessage) error
+	// dump encodes the fields of bm, as parse decodes them.
+	dump func(bm *boxedMessage) string
+	// seal encrypts message to the recipient of e, bound to binding.
+	seal func(e *Encrypter, message, binding []byte, nonce [24]byte) (*boxedMessage, error)
+	// open decrypts bm with d, checking that it's bound to binding.
+	open func(d *Decrypter, bm *boxedMessage, binding []byte) ([]byte, error)
+}
+
+// codecs holds the codec of each schema version. It's only modified by
+// registerCodec, from init functions, so needn't be locked.
+var codecs = make(map[int]*codec)
+
+// registerCodec makes c available for its schema version. If registerCodec is
+// called twice for the same version, it panics.
+func registerCodec(c *codec) {
+	if _, dup := codecs[c.version]; dup {
+		panic(fmt.Sprintf("crypto: registerCodec called twice for schema version %d", c.version))
+	}
+	codecs[c.version] = c
+}
+
+// lookupCodec returns the codec for version, or an error wrapping
+// ErrUnsupportedVersion and ErrInvalidMessage.
+func lookupCodec(version int) (*codec, error) {
+	c, ok := codecs[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %w %d", ErrInvalidMessage, ErrUnsupportedVersion, version)
+	}
+	return c, nil
+}
+
+// SchemaVersions returns the sorted schema versions which can be encrypted
+// and decrypted, for use with Encrypter.Version.
+func SchemaVersions() []int {
+	versions := make([]int, 0, len(codecs))
+	for v := range codecs {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+func init() {
+	registerCodec(&codec{
+		version: 1,
+		match:   boxFieldsParser.MatchString,
+		parse:   parseBoxFields,
+		dump:    dumpBoxFields,
+		seal:    sealV1,
+		open:    openV1,
+	})
+	registerCodec(&codec{
+		version: 2,
+		match:   boxFieldsParser.MatchString,
+		parse:   parseBoxFields,
+		dump:    dumpBoxFields,
+		seal:    sealV2,
+		open:    openV2,
+	})
+}
+
+// sealV1 encrypts message with nacl/box. Version 1 messages aren't bound, so
+// binding is ignored.
+func sealV1(e *Encrypter, message, binding []byte, nonce [24]byte) (*boxedMessage, error) {
+	return &boxedMessage{
+		SchemaVersion:   1,
+		EncrypterPublic: e.Keypair.Public,
+		Nonce:           nonce,
+		Box:             box.SealAfterPrecomputation(nil, message, &nonce, &e.SharedKey),
+	}, nil
+}
+
+func openV1(d *Decrypter, bm *boxedMessage, binding []byte) ([]byte, error) {
+	plaintext, ok := box.Open(nil, bm.Box, &bm.Nonce, &bm.EncrypterPublic, &d.Keypair.Private)
+	if !ok {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// sealV2 encrypts message, prefixed with its binding digest, with secretbox.
+// The key is derived from the shared key of sealV1 for schema version 2
+// alone, so that a message can't be opened as one of another version by
+// changing the version it's labelled with.
+func sealV2(e *Encrypter, message, binding []byte, nonce [24]byte) (*boxedMessage, error) {
+	if binding == nil {
+		return nil, errors.New("schema version 2 requires a binding")
+	}
+	key, err := v2Key(e.SharedKey)
+	if err != nil {
+		return nil, err
+	}
+	message = append(bindingDigest(e.PeerPublic, binding), message...)
+	return &boxedMessage{
+		SchemaVersion:   2,
+		EncrypterPublic: e.Keypair.Public,
+		Nonce:           nonce,
+		Box:             secretbox.Seal(nil, message, &nonce, &key),
+	}, nil
+}
+
+func openV2(d *Decrypter, bm *boxedMessage, binding []byte) ([]byte, error) {
+	var shared [32]byte
+	box.Precompute(&shared, &bm.EncrypterPublic, &d.Keypair.Private)
+	key, err := v2Key(shared)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, ok := secretbox.Open(nil, bm.Box, &bm.Nonce, &key)
+	if !ok {
+		return nil, ErrDecryptionFailed
+	}
+	if binding == nil {
+		return nil, ErrBindingMismatch
+	}
+	return checkBinding(plaintext, d.Keypair.Public, binding)
+}
+
+// v2Key derives the secretbox key of schema version 2 messages from the
+// nacl/box shared key.
+func v2Key(shared [32]byte) (key [32]byte, err error) {
+	k, err := hkdf.Key(sha256.New, shared[:], nil, "ecfg v2", len(key))
+	copy(key[:], k)
+	return key, err
+}
@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaVersions(t *testing.T) {
+	if versions := SchemaVersions(); !reflect.DeepEqual(versions, []int{1, 2, 3}) {
+		t.Errorf("unexpected schema versions: %v", versions)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	var kp Keypair
+	kp.Generate()
+	fields := "AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=:AgICAgICAgICAgICAgICAgICAgICAgIC:AwMD]"
+	for _, version := range []string{"9", "12"} {
+		wire := "EJ[" + version + ":" + fields
+		_, err := kp.Decrypter().Decrypt([]byte(wire))
+		if !errors.Is(err, ErrUnsupportedVersion) || !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("%s: expected ErrUnsupportedVersion, got %v", version, err)
+		} else if !strings.HasSuffix(err.Error(), "unsupported schema version "+version) {
+			t.Errorf("%s: expected error to name the version, got %q", version, err)
+		}
+
+		// messages from newer versions of ecfg aren't encrypted again
+		ct, err := kp.Encrypter(kp.Public).Encrypt([]byte(wire))
+		if err != nil || string(ct) != wire {
+			t.Errorf("%s: expected message to be left alone, got %s, %v", version, ct, err)
+		}
+	}
+}
+
+func TestEncrypterVersion(t *testing.T) {
+	var kpEphemeral, kpSecret Keypair
+	kpEphemeral.Generate()
+	kpSecret.Generate()
+	var kpHybrid HybridKeypair
+	assertNoError(t, kpHybrid.Generate())
+
+	encrypter := kpEphemeral.Encrypter(kpSecret.Public)
+	encrypter.Version = 1
+	ct, err := encrypter.EncryptBound([]byte("x"), []byte("a"))
+	assertNoError(t, err)
+	if !strings.HasPrefix(string(ct), "EJ[1:") {
+		t.Errorf("expected schema version 1, got %s", ct)
+	}
+	if pt, err := kpSecret.Decrypter().DecryptBound(ct, []byte("b")); err != nil || string(pt) != "x" {
+		t.Errorf("expected unbound message, got %s, %v", pt, err)
+	}
+
+	// hybrid keys can't be downgraded to their X25519 component
+	hybrid := kpEphemeral.HybridEncrypter(&kpHybrid.Public)
+	for _, version := range []int{1, 2} {
+		hybrid.Version = version
+		if ct, err := hybrid.EncryptBound([]byte("x"), []byte("a")); err == nil || !strings.Contains(err.Error(), "ML-KEM-768") {
+			t.Errorf("%d: expected hybrid key to refuse version, got %s, %v", version, ct, err)
+		}
+	}
+	hybrid.Version = 3
+	ct, err = hybrid.EncryptBound([]byte("x"), []byte("a"))
+	assertNoError(t, err)
+	if pt, err := kpHybrid.Decrypter().DecryptBound(ct, []byte("a")); err != nil || string(pt) != "x" || !strings.HasPrefix(string(ct), "EJ[3:") {
+		t.Errorf("expected version 3 message, got %s, %s, %v", ct, pt, err)
+	}
+
+	// version 2 needs a binding, and version 3 a hybrid key
+	for _, version := range []int{2, 3} {
+		encrypter.Version = version
+		if _, err := encrypter.Encrypt([]byte("x")); err == nil {
+			t.Errorf("%d: expected error", version)
+		}
+	}
+	encrypter.Version = 7
+	if _, err := encrypter.Encrypt([]byte("x")); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	var kpEphemeral, kpSecret Keypair
+	kpEphemeral.Generate()
+	kpSecret.Generate()
+	var kpHybrid HybridKeypair
+	assertNoError(t, kpHybrid.Generate())
+	classic := kpEphemeral.Encrypter(kpSecret.Public)
+	hybrid := kpEphemeral.HybridEncrypter(&kpHybrid.Public)
+
+	cases := []struct {
+		encrypter *Encrypter
+		version   int
+		bound     bool
+		err       string
+	}{
+		{classic, 0, false, ""},
+		{classic, 1, false, ""},
+		{classic, 2, true, ""},
+		{classic, 2, false, "requires a binding"},
+		{classic, 3, true, "requires a hybrid public key"},
+		{classic, 9, true, "unsupported schema version 9"},
+		{hybrid, 0, false, ""},
+		{hybrid, 3, false, ""},
+		{hybrid, 1, true, "drop the ML-KEM-768 component"},
+		{hybrid, 2, true, "drop the ML-KEM-768 component"},
+	}
+	for _, tc := range cases {
+		tc.encrypter.Version = tc.version
+		err := tc.encrypter.CheckVersion(tc.bound)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("version %d, bound %v, hybrid %v: wanted error %q, got %v", tc.version, tc.bound, tc.encrypter == hybrid, tc.err, err)
+		}
+	}
+}
@@ -11,9 +11,14 @@
 package crypto
 
 import (
+	"bytes"
+	"crypto/mlkem"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"io"
 
 	"golang.org/x/crypto/nacl/box"
 )
@@ -34,6 +39,16 @@ type Encrypter struct {
 	Keypair    *Keypair
 	PeerPublic [32]byte
 	SharedKey  [32]byte
+	// Rand is the source of nonces. If nil, crypto/rand.Reader is used.
+	Rand io.Reader
+	// Version is the schema version of the messages produced, one of
+	// SchemaVersions. If zero, it's chosen for each message: 3 for
+	// Encrypters from HybridEncrypter, otherwise 2 if the message is bound
+	// and 1 if not. Encrypters from HybridEncrypter refuse versions below 3,
+	// which would encrypt to the X25519 component alone.
+	Version int
+
+	peerMLKEM *mlkem.EncapsulationKey768
 }
 
 // Decrypter is generated from a keypair (a fixed keypair, generally, whose
@@ -42,17 +57,42 @@ type Encrypter struct {
 // Keypair instance.
 type Decrypter struct {
 	Keypair *Keypair
+	// AllowUnencrypted makes Decrypt and DecryptBound return messages which
+	// aren't encrypted at all (i.e. don't begin with "EJ[") unchanged, so
+	// that documents in which only some values are encrypted can be
+	// decrypted. Otherwise, such messages fail with ErrInvalidMessage.
+	AllowUnencrypted bool
+
+	mlkem *mlkem.DecapsulationKey768
 }
 
 // ErrDecryptionFailed means the decryption didn't work. This normally
 // indicates that the message was corrupted or the wrong keypair was used.
 var ErrDecryptionFailed = errors.New("couldn't decrypt message")
 
+// ErrInvalidMessage means a value which appeared to be encrypted isn't a
+// well-formed encrypted message. Errors describing the problem in more
+// detail wrap it.
+var ErrInvalidMessage = errors.New("invalid message format")
+
+// ErrBindingMismatch means a message was bound to a different context than
+// the one it was decrypted in, e.g. because it was moved to another key path.
+var ErrBindingMismatch = errors.New("message was encrypted for a different key path")
+
 // Generate generates a new Curve25519 keypair into a (presumably) empty Keypair
 // structure.
-func (k *Keypair) Generate() (err error) {
+func (k *Keypair) Generate() error {
+	return k.GenerateFrom(rand.Reader)
+}
+
+// GenerateFrom is like Generate, but reads the private key from r. If r is
+// nil, crypto/rand.Reader is used.
+func (k *Keypair) GenerateFrom(r io.Reader) (err error) {
+	if r == nil {
+		r = rand.Reader
+	}
 	var pub, priv *[32]byte
-	pub, priv, err = box.GenerateKey(rand.Reader)
+	pub, priv, err = box.GenerateKey(r)
 	if err != nil {
 		return
 	}
@@ -95,20 +135,72 @@ func newEncrypter(kp *Keypair, peerPublic [32]byte) *Encrypter {
 	}
 }
 
-func (e *Encrypter) encrypt(message []byte) (*boxedMessage, error) {
-	nonce, err := genNonce()
+func (e *Encrypter) encrypt(message, binding []byte) (*boxedMessage, error) {
+	nonce, err := genNonce(e.Rand)
 	if err != nil {
 		return nil, err
 	}
 
-	out := box.SealAfterPrecomputation(nil, []byte(message), &nonce, &e.SharedKey)
+	version := e.VersionFor(binding)
+	if e.peerMLKEM != nil && version < hybridVersion {
+		return nil, hybridDowngradeError(version)
+	}
+	c, err := lookupCodec(version)
+	if err != nil {
+		return nil, err
+	}
+	return c.seal(e, message, binding, nonce)
+}
+
+// VersionFor returns the schema version of the messages EncryptBound produces
+// when binding them to binding, as described on the Version field.
+func (e *Encrypter) VersionFor(binding []byte) int {
+	switch {
+	case e.Version != 0:
+		return e.Version
+	case e.peerMLKEM != nil:
+		return hybridVersion
+	case binding != nil:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// CheckVersion returns an error if messages can't be encrypted in the schema
+// version set as e.Version, given whether they'll be bound, so that callers
+// encrypting many messages can fail before encrypting any.
+func (e *Encrypter) CheckVersion(bound bool) error {
+	if e.Version == 0 {
+		return nil
+	}
+	if _, err := lookupCodec(e.Version); err != nil {
+		return err
+	}
+	switch {
+	case e.peerMLKEM != nil && e.Version < hybridVersion:
+		return hybridDowngradeError(e.Version)
+	case e.peerMLKEM == nil && e.Version == hybridVersion:
+		return fmt.Errorf("schema version %d requires a hybrid public key", hybridVersion)
+	case e.Version == 2 && !bound:
+		return errors.New("schema version 2 requires a binding")
+	}
+	return nil
+}
+
+func hybridDowngradeError(version int) error {
+	return fmt.Errorf("schema version %d can't be used with a hybrid public key: it would drop the ML-KEM-768 component", version)
+}
 
-	return &boxedMessage{
-		SchemaVersion:   1,
-		EncrypterPublic: e.Keypair.Public,
-		Nonce:           nonce,
-		Box:             out,
-	}, nil
+// bindingDigest returns the digest which is prepended to the plaintext of
+// messages of schema version 2 and later, binding them to the recipient's
+// public key and the given context.
+func bindingDigest(peerPublic [32]byte, binding []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("ecfg binding v2\x00"))
+	h.Write(peerPublic[:])
+	h.Write(binding)
+	return h.Sum(nil)
 }
 
 // Encrypt takes a plaintext message and returns an encrypted message. Unlike
@@ -117,10 +209,24 @@ func (e *Encrypter) encrypt(message []byte) (*boxedMessage, error) {
 // secure, it just doesn't allow for authorizing the encryptor. That's fine,
 // since authorization isn't a desired property of this particular cryptosystem.
 func (e *Encrypter) Encrypt(message []byte) ([]byte, error) {
+	return e.EncryptBound(message, nil)
+}
+
+// EncryptBound is like Encrypt, but binds the message to binding, typically
+// the key path of the value in its document, along with the recipient's public
+// key. The message, of schema version 2, can then only be decrypted with
+// DecryptBound and the same binding, so that it can't be moved elsewhere
+// undetected. If binding is nil, the message is unbound, of schema version 1,
+// as Encrypt produces.
+//
+// Encrypters obtained from HybridEncrypter always produce messages of schema
+// version 3, which are bound as version 2 messages are; a nil binding is
+// treated as empty. The Version field overrides these choices.
+func (e *Encrypter) EncryptBound(message, binding []byte) ([]byte, error) {
 	if isBoxedMessage(message) {
 		return message, nil
 	}
-	boxedMessage, err := e.encrypt(message)
+	boxedMessage, err := e.encrypt(message, binding)
 	if err != nil {
 		return nil, err
 	}
@@ -131,30 +237,53 @@ func (e *Encrypter) Encrypt(message []byte) ([]byte, error) {
 // generated by (*Encrypter)Encrypt(), which includes the nonce and public key
 // used to create the ciphertext. It returns the decrypted string. Note that,
 // unlike with encryption, Shared-key-precomputation is not used for decryption.
+//
+// Messages bound by EncryptBound can't be decrypted by Decrypt; they fail with
+// ErrBindingMismatch.
 func (d *Decrypter) Decrypt(message []byte) ([]byte, error) {
+	return d.DecryptBound(message, nil)
+}
+
+// DecryptBound is like Decrypt, but also decrypts messages bound to binding by
+// EncryptBound. Messages bound to anything else fail with ErrBindingMismatch.
+// Unbound messages, of schema version 1, are decrypted regardless of binding.
+func (d *Decrypter) DecryptBound(message, binding []byte) ([]byte, error) {
+	if d.AllowUnencrypted && !bytes.HasPrefix(message, []byte("EJ[")) {
+		return message, nil
+	}
 	var bm boxedMessage
 	if err := bm.Load(message); err != nil {
 		return nil, err
 	}
-	return d.decrypt(&bm)
+	return d.decrypt(&bm, binding)
 }
 
-func (d *Decrypter) decrypt(bm *boxedMessage) ([]byte, error) {
-	plaintext, ok := box.Open(nil, bm.Box, &bm.Nonce, &bm.EncrypterPublic, &d.Keypair.Private)
-	if !ok {
+func (d *Decrypter) decrypt(bm *boxedMessage, binding []byte) ([]byte, error) {
+	c, err := lookupCodec(bm.SchemaVersion)
+	if err != nil {
+		return nil, err
+	}
+	return c.open(d, bm, binding)
+}
+
+// checkBinding checks and removes the binding digest which begins plaintext.
+func checkBinding(plaintext []byte, public [32]byte, binding []byte) ([]byte, error) {
+	if len(plaintext) < sha256.Size {
 		return nil, ErrDecryptionFailed
 	}
+	digest, plaintext := plaintext[:sha256.Size], plaintext[sha256.Size:]
+	if subtle.ConstantTimeCompare(digest, bindingDigest(public, binding)) != 1 {
+		return nil, ErrBindingMismatch
+	}
 	return plaintext, nil
 }
 
-func genNonce() (nonce [24]byte, err error) {
-	var n int
-	n, err = rand.Read(nonce[0:24])
-	if err != nil {
-		return
+func genNonce(r io.Reader) (nonce [24]byte, err error) {
+	if r == nil {
+		r = rand.Reader
 	}
-	if n != 24 {
-		err = fmt.Errorf("not enough bytes returned from rand.Reader")
+	if _, err = io.ReadFull(r, nonce[0:24]); err != nil {
+		err = fmt.Errorf("not enough bytes returned from random source: %v", err)
 	}
 	return
 }
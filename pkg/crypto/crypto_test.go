@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -33,14 +34,14 @@ func TestKeypairGeneration(t *testing.T) {
 
 func TestNonceGeneration(t *testing.T) {
 	// generated nonces should be unique
-	n1, _ := genNonce()
-	n2, _ := genNonce()
+	n1, _ := genNonce(nil)
+	n2, _ := genNonce(nil)
 	if reflect.DeepEqual(n1, n2) {
 		t.Errorf("nonces were equal!")
 	}
 
 	// generated nonces should pass a super basic sanity check
-	n, err := genNonce()
+	n, err := genNonce(nil)
 	assertNoError(t, err)
 	text := fmt.Sprintf("%x", n)
 	if strings.Contains(text, "00000") {
@@ -82,6 +83,104 @@ func TestRoundtrip(t *testing.T) {
 	}
 }
 
+func TestBoundRoundtrip(t *testing.T) {
+	var kpEphemeral, kpSecret, kpOther Keypair
+	kpEphemeral.Generate()
+	kpSecret.Generate()
+	kpOther.Generate()
+
+	encrypter := kpEphemeral.Encrypter(kpSecret.Public)
+	decrypter := kpSecret.Decrypter()
+	message := []byte("hunter2")
+	ct, err := encrypter.EncryptBound(message, []byte("db.password"))
+	assertNoError(t, err)
+	if !strings.HasPrefix(string(ct), "EJ[2:") {
+		t.Errorf("expected schema version 2, got %s", ct)
+	}
+
+	pt, err := decrypter.DecryptBound(ct, []byte("db.password"))
+	assertNoError(t, err)
+	if !reflect.DeepEqual(pt, message) {
+		t.Errorf("unexpected plaintext: %s", pt)
+	}
+	for _, binding := range [][]byte{[]byte("public_banner"), nil} {
+		if _, err := decrypter.DecryptBound(ct, binding); !errors.Is(err, ErrBindingMismatch) {
+			t.Errorf("%q: expected ErrBindingMismatch, got %v", binding, err)
+		}
+	}
+
+	// the binding covers the recipient's public key, as well as the context
+	other := Keypair{Public: kpOther.Public, Private: kpSecret.Private}
+	if _, err := other.Decrypter().DecryptBound(ct, []byte("db.password")); !errors.Is(err, ErrBindingMismatch) {
+		t.Errorf("expected ErrBindingMismatch for another public key, got %v", err)
+	}
+
+	// unbound messages decrypt in any context
+	ct, err = encrypter.EncryptBound(message, nil)
+	assertNoError(t, err)
+	if pt, err := decrypter.DecryptBound(ct, []byte("anywhere")); err != nil || !reflect.DeepEqual(pt, message) {
+		t.Errorf("unexpected result decrypting unbound message: %s, %v", pt, err)
+	}
+}
+
+func TestRelabelledVersion(t *testing.T) {
+	var kpEphemeral Keypair
+	var kpHybrid HybridKeypair
+	kpEphemeral.Generate()
+	assertNoError(t, kpHybrid.Generate())
+	decrypter := kpHybrid.Decrypter()
+	binding := []byte("db.password")
+
+	for _, encrypter := range []*Encrypter{
+		kpEphemeral.Encrypter(kpHybrid.Public.X25519),
+		kpEphemeral.HybridEncrypter(&kpHybrid.Public),
+	} {
+		for _, b := range [][]byte{nil, binding} {
+			ct, err := encrypter.EncryptBound([]byte("hunter2"), b)
+			if err != nil {
+				continue // version 3 with a nil binding is covered below
+			}
+			version, _ := MessageVersion(ct)
+			for _, to := range SchemaVersions() {
+				if to == version {
+					continue
+				}
+				relabelled := []byte(fmt.Sprintf("EJ[%d:%s", to, ct[len("EJ[1:"):]))
+				for _, b := range [][]byte{nil, binding, []byte("public_banner")} {
+					pt, err := decrypter.DecryptBound(relabelled, b)
+					if err == nil || strings.Contains(string(pt)+err.Error(), "hunter2") {
+						t.Errorf("version %d relabelled as %d decrypted with binding %q: %q, %v", version, to, b, pt, err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecryptUnencrypted(t *testing.T) {
+	var kp Keypair
+	kp.Generate()
+	decrypter := kp.Decrypter()
+
+	// plaintext is an error by default
+	if _, err := decrypter.Decrypt([]byte("not encrypted")); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("expected ErrInvalidMessage, got %v", err)
+	}
+
+	// but left alone if allowed, just as Encrypt leaves ciphertext alone
+	decrypter.AllowUnencrypted = true
+	pt, err := decrypter.Decrypt([]byte("not encrypted"))
+	assertNoError(t, err)
+	if string(pt) != "not encrypted" {
+		t.Errorf("unexpected plaintext: %s", pt)
+	}
+
+	// but malformed ciphertext is an error
+	if _, err := decrypter.Decrypt([]byte("EJ[1:garbage]")); err == nil {
+		t.Errorf("expected error decrypting malformed message")
+	}
+}
+
 func ExampleEncrypt(peerPublic [32]byte) {
 	var kp Keypair
 	if err := kp.Generate(); err != nil {
@@ -0,0 +1,223 @@
+package crypto
+
+import (
+	"crypto/hkdf"
+	"crypto/mlkem"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// HybridKeyPrefix begins the printable form of a HybridPublicKey,
+// distinguishing it from a hex-encoded Curve25519 public key.
+const HybridKeyPrefix = "mlkem768x25519:"
+
+// hybridVersion is the schema version of messages encrypted to a
+// HybridPublicKey.
+const hybridVersion = 3
+
+func init() {
+	registerCodec(&codec{
+		version: hybridVersion,
+		match:   boxFieldsParser.MatchString,
+		parse:   parseBoxFields,
+		dump:    dumpBoxFields,
+		seal:    (*Encrypter).encryptHybrid,
+		open:    (*Decrypter).decryptHybrid,
+	})
+}
+
+// ErrInvalidHybridKey means a value which appeared to be a hybrid public key
+// isn't well-formed.
+var ErrInvalidHybridKey = errors.New("invalid hybrid public key")
+
+// HybridPublicKey models the public key of a hybrid X25519 + ML-KEM-768
+// keypair. Messages encrypted to it can only be decrypted by breaking both
+// Curve25519 and ML-KEM, so they're expected to remain confidential even if a
+// quantum computer capable of breaking Curve25519 is built.
+type HybridPublicKey struct {
+	// X25519 is the Curve25519 component, which also identifies the key.
+	X25519 [32]byte
+	// MLKEM is the ML-KEM-768 encapsulation key.
+	MLKEM *mlkem.EncapsulationKey768
+}
+
+// HybridKeypair models a hybrid X25519 + ML-KEM-768 keypair. Both private
+// keys are derived from a single 32-byte seed, which is all that needs to be
+// stored. To generate a new HybridKeypair, declare an empty one and call
+// Generate() on it.
+type HybridKeypair struct {
+	Public HybridPublicKey
+	Seed   [32]byte
+
+	x25519Private [32]byte
+	mlkemPrivate  *mlkem.DecapsulationKey768
+}
+
+// Generate generates a new hybrid keypair into a (presumably) empty
+// HybridKeypair structure.
+func (k *HybridKeypair) Generate() error {
+	return k.GenerateFrom(rand.Reader)
+}
+
+// GenerateFrom is like Generate, but reads the seed from r. If r is nil,
+// crypto/rand.Reader is used.
+func (k *HybridKeypair) GenerateFrom(r io.Reader) error {
+	if r == nil {
+		r = rand.Reader
+	}
+	var seed [32]byte
+	if _, err := io.ReadFull(r, seed[:]); err != nil {
+		return fmt.Errorf("not enough bytes returned from random source: %v", err)
+	}
+	return k.SetSeed(seed)
+}
+
+// SetSeed derives the keypair from seed, as returned by PrivateString.
+func (k *HybridKeypair) SetSeed(seed [32]byte) error {
+	x25519Seed, err := hkdf.Key(sha256.New, seed[:], nil, "ecfg mlkem768x25519 x25519", 32)
+	if err != nil {
+		return err
+	}
+	mlkemSeed, err := hkdf.Key(sha256.New, seed[:], nil, "ecfg mlkem768x25519 mlkem768", mlkem.SeedSize)
+	if err != nil {
+		return err
+	}
+	dk, err := mlkem.NewDecapsulationKey768(mlkemSeed)
+	if err != nil {
+		return err
+	}
+
+	k.Seed = seed
+	copy(k.x25519Private[:], x25519Seed)
+	curve25519.ScalarBaseMult(&k.Public.X25519, &k.x25519Private)
+	k.mlkemPrivate = dk
+	k.Public.MLKEM = dk.EncapsulationKey()
+	return nil
+}
+
+// PublicString returns the public key in the canonical printable form.
+func (k *HybridKeypair) PublicString() string {
+	return k.Public.String()
+}
+
+// PrivateString returns the seed in the canonical hex-encoded printable form.
+func (k *HybridKeypair) PrivateString() string {
+	return fmt.Sprintf("%x", k.Seed)
+}
+
+// Decrypter returns a Decrypter instance, used to decrypt properly formatted
+// messages from arbitrary encrypters. Since the X25519 component of the
+// keypair is an ordinary Curve25519 keypair, it also decrypts messages
+// encrypted to the X25519 component alone.
+func (k *HybridKeypair) Decrypter() *Decrypter {
+	return &Decrypter{
+		Keypair: &Keypair{Public: k.Public.X25519, Private: k.x25519Private},
+		mlkem:   k.mlkemPrivate,
+	}
+}
+
+// HybridEncrypter returns an Encrypter instance, given a hybrid public key, to
+// encrypt messages to the paired, unknown, private key. Messages are of schema
+// version 3, or a later version set as the Encrypter's Version, and their
+// ML-KEM component is encapsulated with randomness from crypto/rand
+// regardless of the Encrypter's Rand.
+func (k *Keypair) HybridEncrypter(peer *HybridPublicKey) *Encrypter {
+	e := newEncrypter(k, peer.X25519)
+	e.peerMLKEM = peer.MLKEM
+	return e
+}
+
+// ParseHybridPublicKey parses a hybrid public key in the printable form
+// returned by HybridPublicKey.String.
+func ParseHybridPublicKey(s string) (*HybridPublicKey, error) {
+	if !strings.HasPrefix(s, HybridKeyPrefix) {
+		return nil, ErrInvalidHybridKey
+	}
+	bs, err := base64.StdEncoding.DecodeString(s[len(HybridKeyPrefix):])
+	if err != nil || len(bs) != 32+mlkem.EncapsulationKeySize768 {
+		return nil, ErrInvalidHybridKey
+	}
+	ek, err := mlkem.NewEncapsulationKey768(bs[32:])
+	if err != nil {
+		return nil, ErrInvalidHybridKey
+	}
+	var pub HybridPublicKey
+	copy(pub.X25519[:], bs[:32])
+	pub.MLKEM = ek
+	return &pub, nil
+}
+
+// String returns the key in its printable form: HybridKeyPrefix followed by
+// the base64-encoded X25519 component and ML-KEM-768 encapsulation key.
+func (k *HybridPublicKey) String() string {
+	return HybridKeyPrefix + base64.StdEncoding.EncodeToString(k.Bytes())
+}
+
+// Bytes returns the X25519 component followed by the ML-KEM-768
+// encapsulation key.
+func (k *HybridPublicKey) Bytes() []byte {
+	return append(k.X25519[:len(k.X25519):len(k.X25519)], k.MLKEM.Bytes()...)
+}
+
+// encryptHybrid encrypts message, prefixed with a binding digest as in schema
+// version 2, with secretbox. The key combines the X25519 shared key with a
+// fresh ML-KEM-768 shared key, whose ciphertext precedes the box.
+func (e *Encrypter) encryptHybrid(message, binding []byte, nonce [24]byte) (*boxedMessage, error) {
+	if e.peerMLKEM == nil {
+		return nil, fmt.Errorf("schema version %d requires a hybrid public key", hybridVersion)
+	}
+	mlkemShared, mlkemCiphertext := e.peerMLKEM.Encapsulate()
+	key := hybridKey(e.SharedKey, mlkemShared, mlkemCiphertext, e.Keypair.Public, e.PeerPublic)
+
+	message = append(bindingDigest(e.PeerPublic, binding), message...)
+	return &boxedMessage{
+		SchemaVersion:   hybridVersion,
+		EncrypterPublic: e.Keypair.Public,
+		Nonce:           nonce,
+		Box:             secretbox.Seal(mlkemCiphertext, message, &nonce, &key),
+	}, nil
+}
+
+// decryptHybrid is the inverse of encryptHybrid.
+func (d *Decrypter) decryptHybrid(bm *boxedMessage, binding []byte) ([]byte, error) {
+	if d.mlkem == nil || len(bm.Box) < mlkem.CiphertextSize768 {
+		return nil, ErrDecryptionFailed
+	}
+	mlkemCiphertext, sealed := bm.Box[:mlkem.CiphertextSize768], bm.Box[mlkem.CiphertextSize768:]
+	mlkemShared, err := d.mlkem.Decapsulate(mlkemCiphertext)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	var x25519Shared [32]byte
+	box.Precompute(&x25519Shared, &bm.EncrypterPublic, &d.Keypair.Private)
+	key := hybridKey(x25519Shared, mlkemShared, mlkemCiphertext, bm.EncrypterPublic, d.Keypair.Public)
+
+	plaintext, ok := secretbox.Open(nil, sealed, &bm.Nonce, &key)
+	if !ok {
+		return nil, ErrDecryptionFailed
+	}
+	return checkBinding(plaintext, d.Keypair.Public, binding)
+}
+
+// hybridKey combines the shared keys of both components, along with the
+// ML-KEM ciphertext and both X25519 public keys, into a secretbox key.
+func hybridKey(x25519Shared [32]byte, mlkemShared, mlkemCiphertext []byte, encrypterPublic, peerPublic [32]byte) (key [32]byte) {
+	h := sha256.New()
+	h.Write([]byte("ecfg mlkem768x25519 v3\x00"))
+	h.Write(mlkemShared)
+	h.Write(x25519Shared[:])
+	h.Write(mlkemCiphertext)
+	h.Write(encrypterPublic[:])
+	h.Write(peerPublic[:])
+	h.Sum(key[:0])
+	return
+}
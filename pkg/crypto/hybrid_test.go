@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHybridRoundtrip(t *testing.T) {
+	var kpEphemeral, kpClassic Keypair
+	var kpSecret HybridKeypair
+	kpEphemeral.Generate()
+	kpClassic.Generate()
+	assertNoError(t, kpSecret.Generate())
+
+	pub, err := ParseHybridPublicKey(kpSecret.PublicString())
+	assertNoError(t, err)
+	encrypter := kpEphemeral.HybridEncrypter(pub)
+	decrypter := kpSecret.Decrypter()
+	message := []byte("hunter2")
+
+	for _, binding := range [][]byte{[]byte("db.password"), nil} {
+		ct, err := encrypter.EncryptBound(message, binding)
+		assertNoError(t, err)
+		if !strings.HasPrefix(string(ct), "EJ[3:") {
+			t.Errorf("%q: expected schema version 3, got %s", binding, ct)
+		}
+		pt, err := decrypter.DecryptBound(ct, binding)
+		assertNoError(t, err)
+		if !reflect.DeepEqual(pt, message) {
+			t.Errorf("%q: unexpected plaintext: %s", binding, pt)
+		}
+		if _, err := decrypter.DecryptBound(ct, []byte("public_banner")); !errors.Is(err, ErrBindingMismatch) {
+			t.Errorf("%q: expected ErrBindingMismatch, got %v", binding, err)
+		}
+		if _, err := kpClassic.Decrypter().DecryptBound(ct, binding); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("%q: expected a classic key to fail, got %v", binding, err)
+		}
+	}
+
+	// messages encrypted to the X25519 component alone also decrypt
+	ct, err := kpEphemeral.Encrypter(pub.X25519).Encrypt(message)
+	assertNoError(t, err)
+	if pt, err := decrypter.Decrypt(ct); err != nil || !reflect.DeepEqual(pt, message) {
+		t.Errorf("unexpected result decrypting version 1 message: %s, %v", pt, err)
+	}
+}
+
+func TestHybridKeypairFromSeed(t *testing.T) {
+	var kp, restored HybridKeypair
+	assertNoError(t, kp.Generate())
+	assertNoError(t, restored.SetSeed(kp.Seed))
+	if restored.PublicString() != kp.PublicString() {
+		t.Errorf("expected the seed to determine the public key")
+	}
+	if !strings.HasPrefix(kp.PublicString(), HybridKeyPrefix) || len(kp.PrivateString()) != 64 {
+		t.Errorf("unexpected key format: %s, %s", kp.PublicString(), kp.PrivateString())
+	}
+
+	var other HybridKeypair
+	assertNoError(t, other.GenerateFrom(bytes.NewReader(make([]byte, 32))))
+	if other.PublicString() == kp.PublicString() {
+		t.Errorf("expected distinct seeds to give distinct keys")
+	}
+}
+
+func TestParseHybridPublicKey(t *testing.T) {
+	var kp HybridKeypair
+	assertNoError(t, kp.Generate())
+	s := kp.PublicString()
+	for _, bad := range []string{
+		"",
+		s[len(HybridKeyPrefix):],
+		s[:len(s)-4],
+		HybridKeyPrefix + "!!!!",
+	} {
+		if _, err := ParseHybridPublicKey(bad); !errors.Is(err, ErrInvalidHybridKey) {
+			t.Errorf("%.40q: expected ErrInvalidHybridKey, got %v", bad, err)
+		}
+	}
+}
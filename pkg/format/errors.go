@@ -0,0 +1,72 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ValueError reports a value of a document which couldn't be transformed,
+// e.g. because it couldn't be decrypted. Handlers return it for errors
+// returned by actions adapted with TransformAction.
+type ValueError struct {
+	// File is the name of the document, if known.
+	File string
+	// Path is the key path of the value.
+	Path Path
+	// Line and Column give the 1-based position at which the value begins,
+	// or are zero if unknown.
+	Line, Column int
+	// Err is the error returned by the action.
+	Err error
+}
+
+func (e *ValueError) Error() string {
+	parts := []string{position(e.File, e.Line, e.Column), e.Path.String(), e.Err.Error()}
+	return joinNonEmpty(parts)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// SyntaxError reports a document which couldn't be parsed.
+type SyntaxError struct {
+	// File is the name of the document, if known.
+	File string
+	// Line and Column give the 1-based position at which the problem was
+	// found. Either may be zero if unknown.
+	Line, Column int
+	// Msg describes the problem.
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return joinNonEmpty([]string{position(e.File, e.Line, e.Column), e.Msg})
+}
+
+// position renders a location in a document, e.g. `secrets.ejson:3:12`, or
+// `line 3, column 12` if the name of the document isn't known.
+func position(file string, line, column int) string {
+	switch {
+	case line <= 0:
+		return file
+	case file == "" && column <= 0:
+		return "line " + strconv.Itoa(line)
+	case file == "":
+		return "line " + strconv.Itoa(line) + ", column " + strconv.Itoa(column)
+	case column <= 0:
+		return file + ":" + strconv.Itoa(line)
+	default:
+		return file + ":" + strconv.Itoa(line) + ":" + strconv.Itoa(column)
+	}
+}
+
+func joinNonEmpty(parts []string) string {
+	var nonEmpty []string
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, ": ")
+}
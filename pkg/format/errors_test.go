@@ -0,0 +1,29 @@
+package format
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorMessages(t *testing.T) {
+	cause := errors.New("couldn't decrypt message")
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&ValueError{Path: Path{Key("db"), Key("password")}, Line: 3, Column: 12, Err: cause}, "line 3, column 12: db.password: couldn't decrypt message"},
+		{&ValueError{File: "secrets.ejson", Path: Path{Key("a")}, Line: 3, Column: 12, Err: cause}, "secrets.ejson:3:12: a: couldn't decrypt message"},
+		{&ValueError{File: "secrets.ejson", Path: Path{Key("a")}, Err: cause}, "secrets.ejson: a: couldn't decrypt message"},
+		{&ValueError{Err: cause}, "couldn't decrypt message"},
+		{&SyntaxError{Line: 2, Column: 1, Msg: "invalid json"}, "line 2, column 1: invalid json"},
+		{&SyntaxError{File: "a.toml", Line: 2, Column: 1, Msg: "bad"}, "a.toml:2:1: bad"},
+		{&SyntaxError{Line: 4, Msg: "toml error: bad"}, "line 4: toml error: bad"},
+		{&SyntaxError{File: "a.toml", Line: 4, Msg: "bad"}, "a.toml:4: bad"},
+		{&SyntaxError{Msg: "invalid json"}, "invalid json"},
+	}
+	for _, tc := range cases {
+		if got := tc.err.Error(); got != tc.want {
+			t.Errorf("expected %q, got %q", tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+)
+
+// SetFieldHelper implements FieldEditor.SetField for formats in which each
+// top-level field is written on a line of its own as its key, which may be
+// quoted, an assignment operator and its value, e.g. YAML and TOML. The line
+// setting name is replaced by one setting it to quoted, the value already
+// rendered in the syntax of the format, with the operator assign, e.g. ": ".
+// If there's no such line, one is inserted before the line setting
+// PublicKeyField, with the same indentation. Only lines before the first for
+// which stop returns true are considered, and if several set the same key, the
+// least indented is taken to be the top-level one.
+//
+// SetFieldHelper returns false if neither line is found. Callers should parse
+// the output to make sure that the field was set as intended.
+func SetFieldHelper(data []byte, name, quoted, assign string, stop func(line []byte) bool) ([]byte, bool) {
+	return SetFieldBeforeHelper(data, name, quoted, assign, PublicKeyField, stop)
+}
+
+// SetFieldBeforeHelper is like SetFieldHelper, but inserts a new field before
+// the line setting anchor rather than PublicKeyField.
+func SetFieldBeforeHelper(data []byte, name, quoted, assign, anchor string, stop func(line []byte) bool) ([]byte, bool) {
+	field, pk := fieldLine{indent: -1}, fieldLine{indent: -1}
+	for start := 0; start < len(data); {
+		end := bytes.IndexByte(data[start:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += start
+		}
+		line := bytes.TrimSuffix(data[start:end], []byte("\r"))
+		if stop != nil && stop(line) {
+			break
+		}
+		field.match(line, start, name, assign)
+		pk.match(line, start, anchor, assign)
+		start = end + 1
+	}
+
+	entry := name + assign + quoted
+	switch {
+	case field.indent >= 0:
+		repl := append(append([]byte{}, data[field.start:field.start+field.indent]...), entry...)
+		return splice(data, field.start, field.end, repl), true
+	case pk.indent >= 0:
+		repl := append(append([]byte{}, data[pk.start:pk.start+pk.indent]...), entry...)
+		return splice(data, pk.start, pk.start, append(repl, '\n')), true
+	default:
+		return nil, false
+	}
+}
+
+// fieldLine locates a line setting a top-level field.
+type fieldLine struct {
+	start, end int
+	indent     int // -1 if not found
+}
+
+// match records line, which begins at offset start, if it sets name and is
+// less indented than any line recorded before.
+func (f *fieldLine) match(line []byte, start int, name, assign string) {
+	trimmed := bytes.TrimLeft(line, " \t")
+	indent := len(line) - len(trimmed)
+	if f.indent >= 0 && indent >= f.indent {
+		return
+	}
+	op := strings.TrimSpace(assign)
+	for _, key := range []string{name, `"` + name + `"`, "'" + name + "'"} {
+		if rest := bytes.TrimLeft(bytes.TrimPrefix(trimmed, []byte(key)), " \t"); len(rest) < len(trimmed) && bytes.HasPrefix(rest, []byte(op)) {
+			f.start, f.end, f.indent = start, start+len(line), indent
+			return
+		}
+	}
+}
+
+// splice returns a copy of data with data[start:end] replaced by repl.
+func splice(data []byte, start, end int, repl []byte) []byte {
+	out := make([]byte, 0, len(data)-(end-start)+len(repl))
+	out = append(out, data[:start]...)
+	out = append(out, repl...)
+	return append(out, data[end:]...)
+}
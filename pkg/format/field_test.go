@@ -0,0 +1,39 @@
+package format
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSetFieldHelper(t *testing.T) {
+	stop := func(line []byte) bool { return bytes.HasPrefix(line, []byte("---")) }
+	cases := []struct {
+		in       string
+		expected string
+		ok       bool
+	}{
+		{"a: b\n  _public_key: x\n_public_key: k\n", "a: b\n  _public_key: x\n_sig: \"s\"\n_public_key: k\n", true},
+		{"  '_sig' : old\n  _public_key: k", "  _sig: \"s\"\n  _public_key: k", true},
+		{"_public_key2: k\n", "", false},
+		{"---\n_public_key: k\n", "", false},
+	}
+	for _, tc := range cases {
+		out, ok := SetFieldHelper([]byte(tc.in), "_sig", `"s"`, ": ", stop)
+		if ok != tc.ok || string(out) != tc.expected {
+			t.Errorf("%q: expected %q, %v, got %q, %v", tc.in, tc.expected, tc.ok, out, ok)
+		}
+	}
+}
+
+func TestFieldHelper(t *testing.T) {
+	obj := map[string]interface{}{"a": "b", "c": 1}
+	if v, ok, err := FieldHelper(obj, "a"); v != "b" || !ok || err != nil {
+		t.Errorf("unexpected result for a: %q, %v, %v", v, ok, err)
+	}
+	if _, ok, err := FieldHelper(obj, "b"); ok || err != nil {
+		t.Errorf("unexpected result for b: %v, %v", ok, err)
+	}
+	if _, _, err := FieldHelper(obj, "c"); err == nil {
+		t.Errorf("expected error for c")
+	}
+}
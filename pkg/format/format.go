@@ -3,12 +3,16 @@ package format
 import (
 	"encoding/hex"
 	"errors"
+	"fmt"
 )
 
 const (
 	// PublicKeyField is the key name at which the public key should be
 	// stored in an ecfg document.
 	PublicKeyField = "_public_key"
+	// SignatureField is the key name at which a document's signature, if
+	// any, is stored.
+	SignatureField = "_ecfg_signature"
 )
 
 // ErrPublicKeyMissing indicates that the PublicKeyField key was not found
@@ -24,6 +28,67 @@ type FormatHandler interface {
 	ExtractPublicKey([]byte) ([32]byte, error)
 }
 
+// ConcurrentHandler is implemented by handlers which transform values
+// concurrently, on a Pipeline. Their actions may be called from several
+// goroutines at once and in no particular order, so must be safe for
+// concurrent use, but the output is always written in document order.
+//
+// Such handlers take their number of workers from a Workers field, where zero
+// means GOMAXPROCS. Limiting a handler to a single worker makes it call its
+// action on one value at a time, in document order, which matters when the
+// results depend on that order: for example, when values are encrypted with a
+// deterministic source of randomness.
+type ConcurrentHandler interface {
+	FormatHandler
+	// WithWorkers returns a copy of the handler which transforms at most n
+	// values at once.
+	WithWorkers(n int) FormatHandler
+}
+
+// FieldEditor is implemented by handlers which can read and write top-level
+// string fields of a document other than PublicKeyField, such as
+// SignatureField. Like PublicKeyField, such fields should begin with an
+// underscore, so that their values aren't encrypted.
+type FieldEditor interface {
+	FormatHandler
+	// Field returns the value of the top-level field name, and whether it's
+	// present. An error is returned if the document is malformed or the
+	// field's value isn't a string.
+	Field(data []byte, name string) (value string, ok bool, err error)
+	// SetField returns data with the top-level field name set to value,
+	// leaving the rest of the document as it is. If the field isn't present,
+	// it's inserted just before PublicKeyField, which must be.
+	SetField(data []byte, name, value string) ([]byte, error)
+}
+
+// PublicKeyStringExtractor is implemented by handlers which can return the
+// PublicKeyField of a document as written, so that keys other than hex-encoded
+// Curve25519 keys, such as hybrid X25519 + ML-KEM-768 keys, can be parsed by
+// the caller. ExtractPublicKey fails for such keys with ErrPublicKeyInvalid.
+type PublicKeyStringExtractor interface {
+	FormatHandler
+	// ExtractPublicKeyString finds the PublicKeyField value wherever
+	// ExtractPublicKey would, returning it as written. Handlers share the
+	// lookup between the two, typically with PublicKeyStringHelper and
+	// ExtractPublicKeyHelper, so that they can't disagree about which
+	// value is the document's key.
+	ExtractPublicKeyString([]byte) (string, error)
+}
+
+// PublicKeyStringHelper returns the PublicKeyField of obj, a decoded document,
+// as PublicKeyStringExtractor.ExtractPublicKeyString does.
+func PublicKeyStringHelper(obj map[string]interface{}) (string, error) {
+	k, ok := obj[PublicKeyField]
+	if !ok {
+		return "", ErrPublicKeyMissing
+	}
+	ks, ok := k.(string)
+	if !ok {
+		return "", ErrPublicKeyInvalid
+	}
+	return ks, nil
+}
+
 func ExtractPublicKeyHelper(obj map[string]interface{}) (key [32]byte, err error) {
 	var (
 		ks string
@@ -57,3 +122,17 @@ invalid:
 	err = ErrPublicKeyInvalid
 	return
 }
+
+// FieldHelper returns the value of the field name of obj, a decoded document,
+// as FieldEditor.Field does.
+func FieldHelper(obj map[string]interface{}, name string) (string, bool, error) {
+	v, ok := obj[name]
+	if !ok {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf("%s must be a string", name)
+	}
+	return s, true, nil
+}
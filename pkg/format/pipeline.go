@@ -0,0 +1,152 @@
+package format
+
+import (
+	"io"
+	"runtime"
+	"sync"
+)
+
+// maxPendingBytes is the number of bytes passed to Pipeline.Append which are
+// collected before being queued for output, so that documents are never held
+// in memory in full.
+const maxPendingBytes = 4096
+
+// Pipeline transforms the values of a document concurrently on a bounded pool
+// of workers, writing the results, interleaved with the unchanged parts of the
+// document, to an io.Writer in the order in which they were submitted.
+//
+// Once any transformation fails, work which hasn't started yet is abandoned,
+// nothing further is written, and the error is returned by Go and Close.
+type Pipeline struct {
+	out     io.Writer
+	pending []byte
+	queue   chan *task // in output order
+	jobs    chan *task
+	done    chan struct{}
+	workers sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+type task struct {
+	fn     func() ([]byte, error)
+	result []byte
+	done   chan struct{}
+}
+
+// NewPipeline returns a Pipeline which writes to out, running at most workers
+// transformations at once. If workers is zero or negative, GOMAXPROCS is used.
+// With a single worker, transformations run one at a time in the order in
+// which they were submitted.
+func NewPipeline(out io.Writer, workers int) *Pipeline {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &Pipeline{
+		out:   out,
+		queue: make(chan *task, 4*workers),
+		jobs:  make(chan *task, workers),
+		done:  make(chan struct{}),
+	}
+	p.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.write()
+	return p
+}
+
+func (p *Pipeline) work() {
+	defer p.workers.Done()
+	for t := range p.jobs {
+		if p.Err() == nil {
+			res, err := t.fn()
+			if err != nil {
+				p.fail(err)
+			}
+			t.result = res
+		}
+		close(t.done)
+	}
+}
+
+func (p *Pipeline) write() {
+	defer close(p.done)
+	for t := range p.queue {
+		<-t.done
+		if p.Err() != nil {
+			continue
+		}
+		if _, err := p.out.Write(t.result); err != nil {
+			p.fail(err)
+		}
+	}
+}
+
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Err returns the first error encountered so far, if any.
+func (p *Pipeline) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Append queues bs to be written unchanged. It never fails; errors writing to
+// the underlying writer are returned by Go and Close.
+func (p *Pipeline) Append(bs []byte) {
+	p.pending = append(p.pending, bs...)
+	if len(p.pending) >= maxPendingBytes {
+		p.flushPending()
+	}
+}
+
+// AppendByte queues b to be written unchanged.
+func (p *Pipeline) AppendByte(b byte) {
+	p.pending = append(p.pending, b)
+	if len(p.pending) >= maxPendingBytes {
+		p.flushPending()
+	}
+}
+
+// Go queues the result of fn to be written once it has been run by a worker.
+// If the pipeline has already failed, fn is not queued and the error is
+// returned, so that callers can stop early.
+func (p *Pipeline) Go(fn func() ([]byte, error)) error {
+	if err := p.Err(); err != nil {
+		return err
+	}
+	p.flushPending()
+	t := &task{fn: fn, done: make(chan struct{})}
+	p.queue <- t
+	p.jobs <- t
+	return nil
+}
+
+func (p *Pipeline) flushPending() {
+	if len(p.pending) == 0 {
+		return
+	}
+	t := &task{result: p.pending, done: make(chan struct{})}
+	close(t.done)
+	p.queue <- t
+	p.pending = nil
+}
+
+// Close writes everything queued, waits for the workers to exit, and returns
+// the first error encountered. The pipeline can't be used afterwards.
+func (p *Pipeline) Close() error {
+	p.flushPending()
+	close(p.queue)
+	close(p.jobs)
+	<-p.done
+	p.workers.Wait()
+	return p.Err()
+}
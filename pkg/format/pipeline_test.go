@@ -0,0 +1,92 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPipelineOrdering(t *testing.T) {
+	var out bytes.Buffer
+	p := NewPipeline(&out, 4)
+	var expected bytes.Buffer
+	for i := 0; i < 100; i++ {
+		i := i
+		p.AppendByte('<')
+		if err := p.Go(func() ([]byte, error) {
+			// Finish out of order.
+			time.Sleep(time.Duration(100-i) * 10 * time.Microsecond)
+			return []byte(fmt.Sprint(i)), nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		p.Append([]byte(">"))
+		fmt.Fprintf(&expected, "<%d>", i)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != expected.String() {
+		t.Errorf("unexpected output: %s", out.String())
+	}
+}
+
+func TestPipelineConcurrencyIsBounded(t *testing.T) {
+	var running, peak int32
+	p := NewPipeline(&bytes.Buffer{}, 3)
+	for i := 0; i < 50; i++ {
+		p.Go(func() ([]byte, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				old := atomic.LoadInt32(&peak)
+				if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil, nil
+		})
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peak > 3 {
+		t.Errorf("%d transformations ran at once, but only 3 workers were allowed", peak)
+	}
+}
+
+func TestPipelineFailure(t *testing.T) {
+	boom := errors.New("boom")
+	var ran int32
+	var out bytes.Buffer
+	p := NewPipeline(&out, 2)
+	p.Append([]byte("a"))
+	var err error
+	for i := 0; i < 1000 && err == nil; i++ {
+		i := i
+		err = p.Go(func() ([]byte, error) {
+			atomic.AddInt32(&ran, 1)
+			if i == 10 {
+				return nil, boom
+			}
+			time.Sleep(100 * time.Microsecond)
+			return []byte("b"), nil
+		})
+	}
+	if closeErr := p.Close(); closeErr != boom {
+		t.Errorf("expected Close to return the first error, got %v", closeErr)
+	}
+	if err != boom {
+		t.Errorf("expected Go to stop accepting work after the failure, got %v", err)
+	}
+	if n := atomic.LoadInt32(&ran); n >= 1000 {
+		t.Errorf("outstanding work wasn't cancelled")
+	}
+	if bytes.Count(out.Bytes(), []byte("b")) > 10 {
+		t.Errorf("output written after the failure: %s", out.String())
+	}
+}
@@ -0,0 +1,87 @@
+package format
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Format associates a FormatHandler with the name used to select it (e.g.
+// with `ecfg encrypt --type`) and the filename suffixes it's inferred from.
+type Format struct {
+	Name     string
+	Suffixes []string
+	Handler  FormatHandler
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]*Format)
+)
+
+// Register makes a format handler available by name and, for files whose
+// names end in one of suffixes (e.g. ".json"), by filename. Handlers are
+// normally registered from the init function of the package implementing
+// them; the built-in JSON, YAML, and TOML handlers are registered this way.
+//
+// If Register is called twice with the same name, or if handler is nil, it
+// panics.
+func Register(name string, suffixes []string, handler FormatHandler) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if handler == nil {
+		panic("format: Register handler is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic("format: Register called twice for format " + name)
+	}
+	registry[name] = &Format{
+		Name:     name,
+		Suffixes: append([]string(nil), suffixes...),
+		Handler:  handler,
+	}
+}
+
+// Lookup returns the handler registered under name.
+func Lookup(name string) (FormatHandler, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	f, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown format %q", name)
+	}
+	return f.Handler, nil
+}
+
+// LookupFilename returns the format whose registered suffix matches the end
+// of filename. If several do, the longest suffix wins, so that a format
+// registered for ".ecfg.json" takes precedence over one for ".json".
+func LookupFilename(filename string) (*Format, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	var (
+		best    *Format
+		bestLen int
+	)
+	for _, f := range registry {
+		for _, suffix := range f.Suffixes {
+			if strings.HasSuffix(filename, suffix) && len(suffix) > bestLen {
+				best, bestLen = f, len(suffix)
+			}
+		}
+	}
+	return best, best != nil
+}
+
+// Names returns the sorted names of all registered formats.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,58 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+)
+
+type nopHandler struct{}
+
+func (nopHandler) TransformScalarValues(data []byte, _ func([]byte) ([]byte, error)) ([]byte, error) {
+	return data, nil
+}
+
+func (nopHandler) ExtractPublicKey([]byte) ([32]byte, error) {
+	return [32]byte{}, nil
+}
+
+func TestRegistry(t *testing.T) {
+	xml, ecfgXML := &nopHandler{}, &nopHandler{}
+	Register("test-xml", []string{".xml"}, xml)
+	Register("test-ecfg-xml", []string{".ecfg.xml"}, ecfgXML)
+
+	h, err := Lookup("test-xml")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if h != xml {
+		t.Errorf("wrong handler returned by name")
+	}
+	if _, err := Lookup("test-nope"); err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+
+	// the longest matching suffix wins
+	if f, ok := LookupFilename("a/b.xml"); !ok || f.Name != "test-xml" {
+		t.Errorf("wrong format returned for a/b.xml: %v", f)
+	}
+	if f, ok := LookupFilename("a/b.ecfg.xml"); !ok || f.Name != "test-ecfg-xml" {
+		t.Errorf("wrong format returned for a/b.ecfg.xml: %v", f)
+	}
+	if _, ok := LookupFilename("a/bxml"); ok {
+		t.Errorf("suffixes should match exactly")
+	}
+
+	names := Names()
+	if !reflect.DeepEqual(names, []string{"test-ecfg-xml", "test-xml"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("expected duplicate registration to panic")
+			}
+		}()
+		Register("test-xml", nil, xml)
+	}()
+}
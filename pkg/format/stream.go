@@ -0,0 +1,29 @@
+package format
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrPublicKeyLate is returned by StreamScalarValues when a value to be
+// visited precedes the PublicKeyField in the document.
+var ErrPublicKeyLate = errors.New("public key must precede all encryptable values when streaming")
+
+// ScalarValueStreamer is implemented by format handlers which can transform a
+// document incrementally, so that only a bounded portion of it is held in
+// memory at once, regardless of its size.
+type ScalarValueStreamer interface {
+	// StreamScalarValues reads a document from r and writes it to w, with
+	// values replaced as they would be by WalkScalarValues. publicKey is
+	// called with the value of the top-level PublicKeyField as soon as it has
+	// been read, before any value following it is visited; if publicKey is
+	// non-nil and a value to be visited is found first, ErrPublicKeyLate is
+	// returned. If an error is returned, part of the output may already have
+	// been written to w.
+	StreamScalarValues(
+		r io.Reader,
+		w io.Writer,
+		publicKey func(string) error,
+		visit func(ScalarValue) ([]byte, error),
+	) error
+}
@@ -0,0 +1,175 @@
+package format
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ScalarValueWalker is implemented by format handlers which can report where
+// each actionable value lives in the document, in addition to transforming it.
+//
+// WalkScalarValues calls visit for each value that TransformScalarValues
+// would pass to its action, in document order, and returns the document with
+// each value replaced by the result of visit. If visit returns a nil slice
+// and a nil error, the value is left exactly as written. An error returned by
+// visit aborts the walk and is returned as-is.
+//
+// Handlers may call visit concurrently from multiple goroutines, so visit must
+// be safe for concurrent use.
+type ScalarValueWalker interface {
+	WalkScalarValues(data []byte, visit func(ScalarValue) ([]byte, error)) ([]byte, error)
+}
+
+// ScalarValue describes an actionable value found by WalkScalarValues.
+type ScalarValue struct {
+	// Path is the sequence of keys and indices leading to the value from the
+	// root of the document.
+	Path Path
+	// Line and Column give the 1-based position at which the value, including
+	// any opening quote, begins in the source. Column counts characters, not
+	// bytes.
+	Line, Column int
+	// Kind records how the value was written in the source.
+	Kind ScalarKind
+	// Value is the content of the scalar, with quotes removed and escape
+	// sequences decoded.
+	Value []byte
+}
+
+// ScalarKind describes the syntax in which a scalar was written.
+type ScalarKind int
+
+const (
+	// ScalarPlain values are unquoted, e.g. YAML plain scalars and INI values.
+	ScalarPlain ScalarKind = iota
+	// ScalarDoubleQuoted values are enclosed in double quotes, and may contain
+	// escape sequences.
+	ScalarDoubleQuoted
+	// ScalarSingleQuoted values are enclosed in single quotes.
+	ScalarSingleQuoted
+	// ScalarBlock values span several lines, e.g. YAML literal and folded
+	// blocks, TOML multi-line strings, and HCL heredocs.
+	ScalarBlock
+)
+
+func (k ScalarKind) String() string {
+	switch k {
+	case ScalarPlain:
+		return "plain"
+	case ScalarDoubleQuoted:
+		return "double-quoted"
+	case ScalarSingleQuoted:
+		return "single-quoted"
+	case ScalarBlock:
+		return "block"
+	default:
+		return "ScalarKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// PathElement is one step of a Path: either a mapping key or, if IsIndex is
+// set, an index into a sequence.
+type PathElement struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// Key returns a PathElement for a mapping key.
+func Key(k string) PathElement {
+	return PathElement{Key: k}
+}
+
+// Index returns a PathElement for a sequence index.
+func Index(i int) PathElement {
+	return PathElement{Index: i, IsIndex: true}
+}
+
+// Path locates a value within a document.
+type Path []PathElement
+
+// String renders the path as dot-separated keys with bracketed indices, e.g.
+// `database.hosts[0]`. Keys that aren't made up solely of letters, digits,
+// underscores, and dashes are rendered as quoted strings in brackets, e.g.
+// `servers["a.b"].ip`, so that distinct paths always render differently.
+func (p Path) String() string {
+	var b strings.Builder
+	for i, el := range p {
+		switch {
+		case el.IsIndex:
+			b.WriteString("[" + strconv.Itoa(el.Index) + "]")
+		case isBareKey(el.Key):
+			if i > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(el.Key)
+		default:
+			b.WriteString("[" + strconv.Quote(el.Key) + "]")
+		}
+	}
+	return b.String()
+}
+
+// Append returns a new path with el appended, leaving p unmodified.
+func (p Path) Append(el PathElement) Path {
+	out := make(Path, len(p)+1)
+	copy(out, p)
+	out[len(p)] = el
+	return out
+}
+
+func isBareKey(k string) bool {
+	if k == "" {
+		return false
+	}
+	for _, r := range k {
+		if !(r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// Positioner converts byte offsets into a document to 1-based line and column
+// numbers. Offsets must be passed in non-decreasing order, which allows a
+// whole document to be processed in a single pass.
+type Positioner struct {
+	data      []byte
+	offset    int
+	line      int
+	lineStart int
+}
+
+// NewPositioner returns a Positioner for data.
+func NewPositioner(data []byte) *Positioner {
+	return &Positioner{data: data, line: 1}
+}
+
+// Position returns the line and column of the byte at offset.
+func (p *Positioner) Position(offset int) (line, column int) {
+	for ; p.offset < offset && p.offset < len(p.data); p.offset++ {
+		if p.data[p.offset] == '\n' {
+			p.line++
+			p.lineStart = p.offset + 1
+		}
+	}
+	return p.line, utf8.RuneCount(p.data[p.lineStart:offset]) + 1
+}
+
+// TransformAction adapts an action as passed to TransformScalarValues into a
+// visitor for WalkScalarValues, so that the former can be implemented in terms
+// of the latter. Errors returned by action are wrapped in a *ValueError
+// locating the value.
+func TransformAction(action func([]byte) ([]byte, error)) func(ScalarValue) ([]byte, error) {
+	return func(v ScalarValue) ([]byte, error) {
+		out, err := action(v.Value)
+		if err != nil {
+			return nil, &ValueError{Path: v.Path, Line: v.Line, Column: v.Column, Err: err}
+		}
+		if out == nil {
+			out = []byte{} // nil would leave the value unchanged
+		}
+		return out, nil
+	}
+}
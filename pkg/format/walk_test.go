@@ -0,0 +1,82 @@
+package format
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPathString(t *testing.T) {
+	cases := []struct {
+		path Path
+		out  string
+	}{
+		{nil, ""},
+		{Path{Key("a")}, "a"},
+		{Path{Key("a"), Key("b_c-d")}, "a.b_c-d"},
+		{Path{Key("a"), Index(0), Key("b")}, "a[0].b"},
+		{Path{Index(1), Index(2)}, "[1][2]"},
+		{Path{Key("a.b"), Key("c")}, `["a.b"].c`},
+		{Path{Key("a"), Key("")}, `a[""]`},
+		{Path{Key("a"), Key(`x "y"`)}, `a["x \"y\""]`},
+	}
+	for _, tc := range cases {
+		if s := tc.path.String(); s != tc.out {
+			t.Errorf("expected %s, got %s", tc.out, s)
+		}
+	}
+}
+
+func TestPathAppend(t *testing.T) {
+	base := make(Path, 1, 2)
+	base[0] = Key("a")
+	b := base.Append(Key("b"))
+	c := base.Append(Key("c"))
+	if b.String() != "a.b" || c.String() != "a.c" {
+		t.Errorf("appending modified the original path: %s, %s", b, c)
+	}
+}
+
+func TestPositioner(t *testing.T) {
+	data := []byte("ab\ncdé\n\nf")
+	pos := NewPositioner(data)
+	cases := []struct{ offset, line, column int }{
+		{0, 1, 1},
+		{1, 1, 2},
+		{3, 2, 1},
+		{7, 2, 4}, // after the two bytes of é
+		{9, 4, 1},
+	}
+	for _, tc := range cases {
+		line, column := pos.Position(tc.offset)
+		if line != tc.line || column != tc.column {
+			t.Errorf("offset %d: expected %d:%d, got %d:%d", tc.offset, tc.line, tc.column, line, column)
+		}
+	}
+}
+
+func TestTransformAction(t *testing.T) {
+	visit := TransformAction(func([]byte) ([]byte, error) { return nil, nil })
+	out, err := visit(ScalarValue{Value: []byte("a")})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if out == nil || len(out) != 0 {
+		t.Errorf("expected an empty, non-nil result, got %#v", out)
+	}
+}
+
+func TestTransformActionError(t *testing.T) {
+	actionErr := errors.New("boom")
+	visit := TransformAction(func([]byte) ([]byte, error) { return []byte("x"), actionErr })
+	out, err := visit(ScalarValue{Path: Path{Key("a"), Index(1)}, Line: 3, Column: 7, Value: []byte("a")})
+	var valueErr *ValueError
+	if !errors.As(err, &valueErr) || !errors.Is(err, actionErr) {
+		t.Fatalf("expected *ValueError wrapping the action's error, got %#v", err)
+	}
+	if valueErr.Path.String() != "a[1]" || valueErr.Line != 3 || valueErr.Column != 7 {
+		t.Errorf("unexpected location: %+v", valueErr)
+	}
+	if out != nil {
+		t.Errorf("expected no output on error, got %q", out)
+	}
+}
@@ -0,0 +1,316 @@
+package hcl
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenNewline
+	tokenIdent
+	tokenString  // "quoted" string
+	tokenHeredoc // <<EOT ... EOT
+	tokenOpenBrace
+	tokenCloseBrace
+	tokenOpenBracket
+	tokenCloseBracket
+	tokenOpenParen
+	tokenCloseParen
+	tokenEqual
+	tokenColon
+	tokenComma
+	tokenOther // numbers, operators, and anything else we don't care about
+)
+
+// token is a lexical token of an HCL document. For strings and heredocs,
+// value holds the parsed content, and template indicates whether the literal
+// contains interpolation (`${...}`) or directive (`%{...}`) sequences.
+type token struct {
+	typ        tokenType
+	start, end int
+	value      string
+	template   bool
+	heredoc    *heredoc
+}
+
+// heredoc records how a heredoc was written, so it can be written back the
+// same way.
+type heredoc struct {
+	opener string // `<<EOT` or `<<-EOT`
+	marker string
+	indent string // the whitespace before the closing marker
+	lines  int
+}
+
+// lexer splits an HCL document into the tokens the scalar value transformer
+// needs to understand the document structure. It is deliberately lenient: any
+// byte sequence it doesn't recognize becomes a tokenOther.
+type lexer struct {
+	input []byte
+	pos   int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case c == '#' || l.hasPrefix("//"):
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		case l.hasPrefix("/*"):
+			end := bytes.Index(l.input[l.pos+2:], []byte("*/"))
+			if end < 0 {
+				return token{}, l.errorf("unterminated comment")
+			}
+			l.pos += 2 + end + 2
+		default:
+			return l.lexToken()
+		}
+	}
+	return token{typ: tokenEOF, start: l.pos, end: l.pos}, nil
+}
+
+var singleByteTokens = map[byte]tokenType{
+	'\n': tokenNewline,
+	'{':  tokenOpenBrace,
+	'}':  tokenCloseBrace,
+	'[':  tokenOpenBracket,
+	']':  tokenCloseBracket,
+	'(':  tokenOpenParen,
+	')':  tokenCloseParen,
+	':':  tokenColon,
+	',':  tokenComma,
+}
+
+func (l *lexer) lexToken() (token, error) {
+	start := l.pos
+	c := l.input[l.pos]
+	switch {
+	case c == '"':
+		return l.lexString()
+	case l.hasPrefix("<<"):
+		return l.lexHeredoc()
+	case c == '=' && !l.hasPrefix("==") && !l.hasPrefix("=>"):
+		l.pos++
+		return token{typ: tokenEqual, start: start, end: l.pos}, nil
+	case isIdentStart(c):
+		for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) {
+			l.pos++
+		}
+		return token{typ: tokenIdent, start: start, end: l.pos, value: string(l.input[start:l.pos])}, nil
+	}
+	if typ, ok := singleByteTokens[c]; ok {
+		l.pos++
+		return token{typ: typ, start: start, end: l.pos}, nil
+	}
+	l.pos++
+	return token{typ: tokenOther, start: start, end: l.pos}, nil
+}
+
+// lexString reads a quoted string, decoding escape sequences. Template
+// sequences are skipped over, taking care of quotes nested within them.
+func (l *lexer) lexString() (token, error) {
+	tok := token{typ: tokenString, start: l.pos}
+	var buf bytes.Buffer
+	l.pos++ // opening quote
+	for {
+		if l.pos >= len(l.input) || l.input[l.pos] == '\n' {
+			return token{}, l.errorf("unterminated string")
+		}
+		c := l.input[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			tok.end = l.pos
+			tok.value = buf.String()
+			return tok, nil
+		case c == '\\':
+			if err := l.lexEscape(&buf); err != nil {
+				return token{}, err
+			}
+		case l.hasPrefix("$${") || l.hasPrefix("%%{"):
+			buf.WriteByte(c)
+			buf.WriteByte('{')
+			l.pos += 3
+		case l.hasPrefix("${") || l.hasPrefix("%{"):
+			tok.template = true
+			start := l.pos
+			if err := l.skipTemplate(); err != nil {
+				return token{}, err
+			}
+			buf.Write(l.input[start:l.pos])
+		default:
+			buf.WriteByte(c)
+			l.pos++
+		}
+	}
+}
+
+func (l *lexer) lexEscape(buf *bytes.Buffer) error {
+	if l.pos+1 >= len(l.input) {
+		return l.errorf("unterminated string")
+	}
+	c := l.input[l.pos+1]
+	l.pos += 2
+	switch c {
+	case 'n':
+		buf.WriteByte('\n')
+	case 'r':
+		buf.WriteByte('\r')
+	case 't':
+		buf.WriteByte('\t')
+	case '"', '\\':
+		buf.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if l.pos+n > len(l.input) {
+			return l.errorf("invalid escape sequence")
+		}
+		r, err := strconv.ParseUint(string(l.input[l.pos:l.pos+n]), 16, 32)
+		if err != nil {
+			return l.errorf("invalid escape sequence")
+		}
+		buf.WriteRune(rune(r))
+		l.pos += n
+	default:
+		return l.errorf("invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+// skipTemplate advances past a `${...}` or `%{...}` sequence, which may itself
+// contain braces and quoted strings.
+func (l *lexer) skipTemplate() error {
+	l.pos += 2
+	depth := 1
+	for l.pos < len(l.input) {
+		switch l.input[l.pos] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				l.pos++
+				return nil
+			}
+		case '"':
+			if _, err := l.lexString(); err != nil {
+				return err
+			}
+			continue
+		}
+		l.pos++
+	}
+	return l.errorf("unterminated template sequence")
+}
+
+// lexHeredoc reads a `<<EOT` or `<<-EOT` heredoc. The token ends at the end of
+// the closing marker, not including the following newline.
+func (l *lexer) lexHeredoc() (token, error) {
+	tok := token{typ: tokenHeredoc, start: l.pos}
+	l.pos += 2
+	indented := false
+	if l.hasPrefix("-") {
+		indented = true
+		l.pos++
+	}
+	markerStart := l.pos
+	for l.pos < len(l.input) && isIdentChar(l.input[l.pos]) {
+		l.pos++
+	}
+	marker := string(l.input[markerStart:l.pos])
+	if marker == "" || l.pos >= len(l.input) || l.input[l.pos] != '\n' {
+		return token{}, l.errorf("invalid heredoc")
+	}
+	l.pos++
+
+	var lines []string
+	for {
+		if l.pos >= len(l.input) {
+			return token{}, l.errorf("unterminated heredoc %s", marker)
+		}
+		eol := bytes.IndexByte(l.input[l.pos:], '\n')
+		if eol < 0 {
+			eol = len(l.input) - l.pos
+		}
+		line := string(l.input[l.pos : l.pos+eol])
+		trimmed := string(bytes.TrimSpace([]byte(line)))
+		if trimmed == marker {
+			tok.heredoc = &heredoc{
+				opener: string(l.input[tok.start : markerStart+len(marker)]),
+				marker: marker,
+				indent: line[:len(line)-len(strings.TrimLeft(line, " \t"))],
+			}
+			l.pos += eol
+			break
+		}
+		lines = append(lines, line)
+		l.pos += eol + 1
+	}
+	tok.end = l.pos
+
+	if indented {
+		lines = unindent(lines)
+	}
+	tok.heredoc.lines = len(lines)
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	tok.value = buf.String()
+	tok.template = bytes.Contains(buf.Bytes(), []byte("${")) || bytes.Contains(buf.Bytes(), []byte("%{"))
+	return tok, nil
+}
+
+// unindent removes the longest run of leading spaces and tabs common to all
+// non-blank lines, as HCL does for `<<-` heredocs.
+func unindent(lines []string) []string {
+	common := -1
+	for _, line := range lines {
+		if len(bytes.TrimSpace([]byte(line))) == 0 {
+			continue
+		}
+		n := len(line) - len(bytes.TrimLeft([]byte(line), " \t"))
+		if common < 0 || n < common {
+			common = n
+		}
+	}
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		if len(line) >= common && common > 0 {
+			out[i] = line[common:]
+		} else {
+			out[i] = line
+		}
+	}
+	return out
+}
+
+func (l *lexer) hasPrefix(s string) bool {
+	return bytes.HasPrefix(l.input[l.pos:], []byte(s))
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	line := 1 + bytes.Count(l.input[:l.pos], []byte("\n"))
+	return fmt.Errorf("hcl error: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c == '-' || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,379 @@
+// Package hcl implements an ecfg format handler for HCL documents, such as
+// Terraform variable (`*.tfvars`) files.
+//
+// Like the TOML and YAML handlers, this locates the byte ranges of
+// encryptable string literals and replaces only those ranges, so comments and
+// layout are preserved exactly. String literals containing interpolation or
+// directive sequences (`${...}`, `%{...}`) are treated as opaque and never
+// encrypted, since they are expressions rather than values.
+package hcl
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// FormatHandler simply exposes the methods required of format.FormatHandler.
+type FormatHandler struct{}
+
+var _ format.FormatHandler = &FormatHandler{}
+
+func init() {
+	format.Register("hcl", []string{".tfvars", ".hcl"}, &FormatHandler{})
+}
+
+// stringValue is a string literal or heredoc found in value position.
+type stringValue struct {
+	tok         token
+	key         string // the attribute or object key the value belongs to
+	topLevel    bool   // directly assigned to an attribute of the top-level body
+	encryptable bool
+}
+
+// content returns the text action is called on. The final newline of a
+// heredoc is left out, since it's added back when the heredoc is written.
+func (v stringValue) content() string {
+	if v.tok.typ == tokenHeredoc {
+		return strings.TrimSuffix(v.tok.value, "\n")
+	}
+	return v.tok.value
+}
+
+type frameKind int
+
+const (
+	frameBody   frameKind = iota // top-level or block body: attributes and blocks
+	frameObject                  // { key = value, ... }
+	frameTuple                   // [ value, ... ]
+	frameParen                   // ( ... ), e.g. function call arguments
+)
+
+type itemState int
+
+const (
+	expectKey itemState = iota
+	afterKey
+	inValue
+)
+
+type frame struct {
+	kind     frameKind
+	state    itemState
+	key      string
+	suppress bool // the current key begins with an underscore
+	opaque   bool // within an expression; nothing here is encrypted
+	start    int  // index of the first of values belonging to the current value
+	terms    int  // number of terms in the current value so far
+}
+
+// TransformScalarValues replaces each encryptable string value with the
+// result of calling action on its content. A string is encryptable if it's the
+// whole value of an attribute, object element or tuple element, and the key it
+// belongs to doesn't begin with an underscore. Strings that are part of a
+// larger expression, such as function call arguments, conditionals or
+// operands, are left alone. As with the other formats, the underscore rule
+// does not propagate into nested objects. Heredocs are written back as
+// heredocs.
+func (h *FormatHandler) TransformScalarValues(
+	data []byte,
+	action func([]byte) ([]byte, error),
+) ([]byte, error) {
+	values, err := stringValues(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		out  bytes.Buffer
+		prev = 0
+	)
+	for _, v := range values {
+		if !v.encryptable {
+			continue
+		}
+		out.Write(data[prev:v.tok.start])
+		val, err := action([]byte(v.content()))
+		if err != nil {
+			return nil, err
+		}
+		if v.tok.typ == tokenHeredoc {
+			doc, ok := writeHeredoc(v.tok.heredoc, val)
+			if !ok {
+				l := &lexer{input: data, pos: v.tok.start}
+				return nil, l.errorf("value can't be written back as a %s heredoc", v.tok.heredoc.opener)
+			}
+			out.WriteString(doc)
+		} else {
+			out.WriteString(quote(val))
+		}
+		prev = v.tok.end
+	}
+	out.Write(data[prev:])
+
+	return out.Bytes(), nil
+}
+
+// ExtractPublicKey finds the _public_key attribute in the top-level body of an
+// ecfg document and parses it into a key usable with the crypto library.
+func (h *FormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return
+	}
+	return format.ExtractPublicKeyHelper(obj)
+}
+
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *FormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields returns the _public_key attribute of the top-level body.
+func (h *FormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	values, err := stringValues(data)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	for _, v := range values {
+		if v.topLevel && v.key == format.PublicKeyField {
+			obj[format.PublicKeyField] = v.tok.value
+			break
+		}
+	}
+	return obj, nil
+}
+
+// stringValues walks the token stream of an HCL document, tracking just
+// enough of its structure to know which key each string literal belongs to,
+// and whether it makes up the whole of its value.
+func stringValues(data []byte) ([]stringValue, error) {
+	var values []stringValue
+	l := &lexer{input: data}
+	stack := []*frame{{kind: frameBody}}
+
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if tok.typ == tokenEOF {
+			if len(stack) != 1 {
+				return nil, fmt.Errorf("hcl error: unexpected end of document")
+			}
+			return values, nil
+		}
+
+		f := stack[len(stack)-1]
+		push := func(kind frameKind, key string, suppress bool) {
+			stack = append(stack, &frame{
+				kind:     kind,
+				key:      key,
+				suppress: suppress,
+				opaque:   f.opaque || kind == frameParen,
+				start:    len(values),
+			})
+		}
+		pop := func() error {
+			if len(stack) == 1 {
+				return l.errorf("unbalanced brackets")
+			}
+			stack = stack[:len(stack)-1]
+			parent := stack[len(stack)-1]
+			if (parent.kind == frameBody || parent.kind == frameObject) && parent.state == afterKey {
+				// end of a block body
+				parent.state = expectKey
+			}
+			return nil
+		}
+		// term counts tok as part of the current value of f. Once a value has
+		// more than one term, it's an expression, and none of the strings in
+		// it are encrypted.
+		term := func() {
+			f.terms++
+			if f.terms > 1 {
+				for i := f.start; i < len(values); i++ {
+					values[i].encryptable = false
+				}
+			}
+		}
+		// value handles a token that's part of a value, returning false if
+		// tok isn't one.
+		value := func() bool {
+			switch tok.typ {
+			case tokenString, tokenHeredoc:
+				term()
+				values = append(values, stringValue{
+					tok:         tok,
+					key:         f.key,
+					topLevel:    len(stack) == 1,
+					encryptable: f.terms == 1 && !f.suppress && !tok.template && !isEmptyHeredoc(tok),
+				})
+			case tokenOpenBrace:
+				term()
+				push(frameObject, "", false)
+			case tokenOpenBracket:
+				term()
+				push(frameTuple, f.key, f.suppress)
+			case tokenOpenParen:
+				term()
+				push(frameParen, f.key, f.suppress)
+			case tokenCloseBrace, tokenCloseBracket, tokenCloseParen, tokenNewline, tokenComma:
+				return false
+			default:
+				term()
+			}
+			return true
+		}
+
+		if f.opaque {
+			switch tok.typ {
+			case tokenOpenBrace:
+				push(frameObject, "", false)
+			case tokenOpenBracket:
+				push(frameTuple, "", false)
+			case tokenOpenParen:
+				push(frameParen, "", false)
+			case tokenCloseBrace, tokenCloseBracket, tokenCloseParen:
+				if err := pop(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if f.kind == frameTuple {
+			if f.terms == 0 && tok.typ == tokenIdent && tok.value == "for" {
+				// a for expression
+				f.opaque = true
+				continue
+			}
+			if value() {
+				continue
+			}
+			switch tok.typ {
+			case tokenComma:
+				f.start, f.terms = len(values), 0
+			case tokenCloseBracket, tokenCloseParen, tokenCloseBrace:
+				if err := pop(); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		switch f.state {
+		case expectKey:
+			switch tok.typ {
+			case tokenIdent, tokenString:
+				f.key = tok.value
+				f.state = afterKey
+			case tokenCloseBrace:
+				if err := pop(); err != nil {
+					return nil, err
+				}
+			case tokenNewline, tokenComma:
+			default:
+				return nil, l.errorf("unexpected token %q", data[tok.start:tok.end])
+			}
+		case afterKey:
+			switch tok.typ {
+			case tokenEqual, tokenColon:
+				f.state = inValue
+				f.suppress = strings.HasPrefix(f.key, "_")
+				f.start, f.terms = len(values), 0
+			case tokenIdent, tokenString:
+				if f.kind == frameObject && f.key == "for" {
+					// a for expression
+					f.opaque = true
+				}
+				// otherwise, a block label
+			case tokenOpenBrace:
+				push(frameBody, "", false)
+			default:
+				return nil, l.errorf("unexpected token %q", data[tok.start:tok.end])
+			}
+		case inValue:
+			if value() {
+				continue
+			}
+			switch tok.typ {
+			case tokenNewline, tokenComma:
+				f.state = expectKey
+			case tokenCloseBrace:
+				if err := pop(); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+func isEmptyHeredoc(tok token) bool {
+	return tok.typ == tokenHeredoc && tok.heredoc.lines == 0
+}
+
+// writeHeredoc renders val as a heredoc written the same way as h, returning
+// false if it wouldn't read back as val; for example, if one of its lines is
+// the closing marker.
+func writeHeredoc(h *heredoc, val []byte) (string, bool) {
+	var buf bytes.Buffer
+	buf.WriteString(h.opener)
+	buf.WriteByte('\n')
+	indented := strings.HasPrefix(h.opener, "<<-")
+	for _, line := range strings.Split(string(val), "\n") {
+		if indented && line != "" {
+			buf.WriteString(h.indent)
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(h.indent)
+	buf.WriteString(h.marker)
+
+	l := &lexer{input: buf.Bytes()}
+	tok, err := l.lexHeredoc()
+	if err != nil || tok.end != buf.Len() || tok.value != string(val)+"\n" {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// quote renders a value as an HCL quoted string literal, escaping anything
+// that would otherwise be interpreted as a template sequence.
+func quote(val []byte) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for len(val) > 0 {
+		r, size := utf8.DecodeRune(val)
+		switch {
+		case r == '"' || r == '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case (r == '$' || r == '%') && len(val) > 1 && val[1] == '{':
+			buf.WriteRune(r)
+			buf.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&buf, `\u%04x`, r)
+		default:
+			buf.Write(val[:size])
+		}
+		val = val[size:]
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
@@ -0,0 +1,191 @@
+package hcl
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const inHCL = `# Terraform variables. Boom.
+_public_key = "6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08"
+
+region   = "us-east-1" // trailing comment
+replicas = 3
+enabled  = true
+
+/* block
+   comment */
+db = {
+  password = "hunter2"
+  "quoted" = "q\"uoé"
+  _note    = "left alone"
+  port     = 5432
+  nested   = { token = "abc" }
+}
+
+_hosts = ["a", "b"]
+hosts  = [
+  "alpha",
+  "omega", # last
+]
+
+template = "${var.prefix}-name"
+escaped  = "$${literal}"
+
+motd = <<-EOT
+  hello
+    world
+  EOT
+
+service "web" {
+  api_key = "k"
+}
+`
+
+const outHCL = `# Terraform variables. Boom.
+_public_key = "6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08"
+
+region   = "ENC[us-east-1]" // trailing comment
+replicas = 3
+enabled  = true
+
+/* block
+   comment */
+db = {
+  password = "ENC[hunter2]"
+  "quoted" = "ENC[q\"uoé]"
+  _note    = "left alone"
+  port     = 5432
+  nested   = { token = "ENC[abc]" }
+}
+
+_hosts = ["a", "b"]
+hosts  = [
+  "ENC[alpha]",
+  "ENC[omega]", # last
+]
+
+template = "${var.prefix}-name"
+escaped  = "ENC[$${literal}]"
+
+motd = <<-EOT
+  ENC[hello
+    world]
+  EOT
+
+service "web" {
+  api_key = "ENC[k]"
+}
+`
+
+func TestTransform(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("ENC[%s]", a)), nil
+	}
+	fh := FormatHandler{}
+	out, err := fh.TransformScalarValues([]byte(inHCL), xform)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != outHCL {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+}
+
+func TestTransformExpressions(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("ENC[%s]", a)), nil
+	}
+	fh := FormatHandler{}
+	for in, expected := range map[string]string{
+		`cert = file("certs/a.pem")`:                  `cert = file("certs/a.pem")`,
+		`v = lookup(var.m, "key", "def")`:             `v = lookup(var.m, "key", "def")`,
+		`a = var.x ? "y" : "z"`:                       `a = var.x ? "y" : "z"`,
+		`a = "x" == var.y`:                            `a = "x" == var.y`,
+		`a = ("x")`:                                   `a = ("x")`,
+		`a = { b = "c" }[var.k]`:                      `a = { b = "c" }[var.k]`,
+		`a = ["x", upper("y"), "z" + 1, { b = "c" }]`: `a = ["ENC[x]", upper("y"), "z" + 1, { b = "ENC[c]" }]`,
+		`a = [for s in ["x"] : upper(s)]`:             `a = [for s in ["x"] : upper(s)]`,
+		`a = {for k, v in { b = "c" } : k => v}`:      `a = {for k, v in { b = "c" } : k => v}`,
+		`a = { b = "c", d = "e" == var.f }`:           `a = { b = "ENC[c]", d = "e" == var.f }`,
+		"a = <<EOT\nEOT\n":                            "a = <<EOT\nEOT\n",
+		"a = <<EOT\nx\n\nEOT\n":                       "a = <<EOT\nENC[x\n]\nEOT\n",
+		"a = <<EOT\nx\nEOT\nb = \"y\"\n":              "a = <<EOT\nENC[x]\nEOT\nb = \"ENC[y]\"\n",
+	} {
+		out, err := fh.TransformScalarValues([]byte(in), xform)
+		if err != nil {
+			t.Errorf("%q: unexpected err: %v", in, err)
+		} else if string(out) != expected {
+			t.Errorf("%q: expected %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func TestTransformHeredocRoundTrip(t *testing.T) {
+	fh := FormatHandler{}
+	for _, in := range []string{
+		"a = <<EOT\nline one\n  line two\nEOT\n",
+		"a = <<-EOT\n    line one\n\n      line two\n    EOT\n",
+	} {
+		var plain []string
+		enc, err := fh.TransformScalarValues([]byte(in), func(a []byte) ([]byte, error) {
+			plain = append(plain, string(a))
+			return []byte("ENCRYPTED"), nil
+		})
+		if err != nil {
+			t.Fatalf("%q: unexpected err: %v", in, err)
+		}
+		out, err := fh.TransformScalarValues(enc, func(a []byte) ([]byte, error) {
+			return []byte(plain[0]), nil
+		})
+		if err != nil || string(out) != in {
+			t.Errorf("%q: round trip gave %q, %v", in, out, err)
+		}
+	}
+
+	// a value containing the closing marker can't be written as a heredoc
+	_, err := fh.TransformScalarValues([]byte("a = <<EOT\nx\nEOT\n"), func(a []byte) ([]byte, error) {
+		return []byte("x\nEOT\ny"), nil
+	})
+	if err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return a, nil
+	}
+	fh := FormatHandler{}
+	for _, in := range []string{
+		`a = "unterminated`,
+		`a = { b = "c"`,
+		`a = "b" }`,
+		`a = <<EOT
+never closed
+`,
+		`/* a = "b"`,
+	} {
+		if _, err := fh.TransformScalarValues([]byte(in), xform); err == nil {
+			t.Errorf("expected error for %q, but none was received", in)
+		}
+	}
+}
+
+func TestKeyExtraction(t *testing.T) {
+	fh := FormatHandler{}
+	expected := [32]byte{109, 121, 183, 229, 0, 115, 229, 230, 106, 69, 129, 237, 8, 191, 29, 154, 3, 128, 108, 196, 100, 140, 255, 235, 109, 247, 27, 87, 117, 229, 235, 8}
+	key, err := fh.ExtractPublicKey([]byte(inHCL))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(key, expected) {
+		t.Errorf("unexpected key: %#v", key)
+	}
+
+	// a nested _public_key doesn't count
+	_, err = fh.ExtractPublicKey([]byte(`a = { _public_key = "6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08" }`))
+	if err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
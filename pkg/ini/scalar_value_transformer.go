@@ -0,0 +1,231 @@
+// Package ini implements an ecfg format handler for INI files, as read by PHP,
+// Python's configparser, and many others.
+//
+// The document is handled line by line: `[section]` headers, `key = value`
+// (or `key: value`) entries, and comment lines beginning with `;` or `#`.
+// Only the byte ranges of encryptable values are replaced, so everything else
+// is preserved exactly.
+package ini
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// FormatHandler simply exposes the methods required of format.FormatHandler.
+type FormatHandler struct{}
+
+var _ format.FormatHandler = &FormatHandler{}
+
+func init() {
+	format.Register("ini", []string{".ini"}, &FormatHandler{})
+}
+
+type entry struct {
+	section    string
+	key        string
+	value      string
+	start, end int // byte range of the value as written, including quotes
+}
+
+// TransformScalarValues replaces the value of each entry whose key doesn't
+// begin with an underscore with the result of calling action on it. Empty
+// values are left alone. Values are written back without quotes unless
+// quoting is needed to preserve them.
+func (h *FormatHandler) TransformScalarValues(
+	data []byte,
+	action func([]byte) ([]byte, error),
+) ([]byte, error) {
+	entries, err := entries(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		out  bytes.Buffer
+		prev = 0
+	)
+	for _, e := range entries {
+		if strings.HasPrefix(e.key, "_") || e.value == "" {
+			continue
+		}
+		out.Write(data[prev:e.start])
+		val, err := action([]byte(e.value))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.ContainsAny(val, "\r\n") {
+			return nil, fmt.Errorf("ini error: value of %s contains a line break, which can't be represented", e.key)
+		}
+		quoted, ok := quote(string(val))
+		if !ok {
+			return nil, fmt.Errorf("ini error: value of %s needs quoting, but contains both single and double quotes, which can't be represented", e.key)
+		}
+		out.WriteString(quoted)
+		prev = e.end
+	}
+	out.Write(data[prev:])
+
+	return out.Bytes(), nil
+}
+
+// ExtractPublicKey finds the _public_key entry in the global section (i.e.
+// before any section header) of an ecfg document and parses it into a key
+// usable with the crypto library.
+func (h *FormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return
+	}
+	return format.ExtractPublicKeyHelper(obj)
+}
+
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *FormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields returns the _public_key entry of the global section.
+func (h *FormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	entries, err := entries(data)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	for _, e := range entries {
+		if e.section == "" && e.key == format.PublicKeyField {
+			obj[format.PublicKeyField] = e.value
+			break
+		}
+	}
+	return obj, nil
+}
+
+func entries(data []byte) ([]entry, error) {
+	var (
+		entries []entry
+		section string
+	)
+	for lineStart, lineNo := 0, 1; lineStart < len(data); lineNo++ {
+		lineEnd := bytes.IndexByte(data[lineStart:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(data)
+		} else {
+			lineEnd += lineStart
+		}
+		line := data[lineStart:lineEnd]
+		trimmed := bytes.TrimSpace(line)
+
+		switch {
+		case len(trimmed) == 0, trimmed[0] == ';', trimmed[0] == '#':
+			// blank or comment
+		case trimmed[0] == '[':
+			end := bytes.IndexByte(trimmed, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("ini error: line %d: unterminated section header", lineNo)
+			}
+			section = string(bytes.TrimSpace(trimmed[1:end]))
+		default:
+			e, err := parseEntry(line, lineStart)
+			if err != nil {
+				return nil, fmt.Errorf("ini error: line %d: %v", lineNo, err)
+			}
+			if e != nil {
+				e.section = section
+				entries = append(entries, *e)
+			}
+		}
+		lineStart = lineEnd + 1
+	}
+	return entries, nil
+}
+
+// parseEntry parses a single `key = value` line. offset is the position of the
+// line in the document. It returns nil for a bare key with no separator.
+func parseEntry(line []byte, offset int) (*entry, error) {
+	sep := bytes.IndexAny(line, "=:")
+	if sep < 0 {
+		return nil, nil
+	}
+	e := &entry{key: string(bytes.TrimSpace(line[:sep]))}
+
+	i := sep + 1
+	for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+		i++
+	}
+	rest := line[i:]
+	e.start = offset + i
+
+	if len(rest) > 0 && (rest[0] == '"' || rest[0] == '\'') {
+		value, n, err := unquote(rest)
+		if err != nil {
+			return nil, err
+		}
+		e.value = value
+		e.end = e.start + n
+		return e, nil
+	}
+
+	// Unquoted values run to the end of the line, less any inline comment
+	// (introduced by whitespace followed by ';' or '#') and trailing space.
+	n := len(rest)
+	for j := 1; j < len(rest); j++ {
+		if (rest[j] == ';' || rest[j] == '#') && (rest[j-1] == ' ' || rest[j-1] == '\t') {
+			n = j
+			break
+		}
+	}
+	value := bytes.TrimRight(rest[:n], " \t\r")
+	e.value = string(value)
+	e.end = e.start + len(value)
+	return e, nil
+}
+
+// unquote parses a quoted value at the start of s, returning its content and
+// length as written. Double-quoted values may contain `\"` and `\\` escapes;
+// single-quoted values are taken literally.
+func unquote(s []byte) (string, int, error) {
+	q := s[0]
+	var buf bytes.Buffer
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == q:
+			return buf.String(), i + 1, nil
+		case q == '"' && c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			buf.WriteByte(s[i+1])
+			i++
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted value")
+}
+
+// quote returns the value unchanged when it would be read back as-is when
+// written bare. Otherwise, it's double-quoted, or single-quoted if it contains
+// a double quote or backslash. Escape sequences are never written, since
+// parsers disagree about them; a value that can't be quoted without one isn't
+// ok.
+func quote(val string) (string, bool) {
+	needsQuotes := val == "" ||
+		strings.TrimSpace(val) != val ||
+		val[0] == '"' || val[0] == '\'' ||
+		strings.ContainsAny(val, ";#")
+	switch {
+	case !needsQuotes:
+		return val, true
+	case !strings.ContainsAny(val, `"\`):
+		return `"` + val + `"`, true
+	case !strings.Contains(val, "'"):
+		return "'" + val + "'", true
+	}
+	return "", false
+}
@@ -0,0 +1,121 @@
+package ini
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const inINI = `; Global settings. Boom.
+_public_key = 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+title = My App
+
+[database]
+host     = db.internal ; the primary
+password = "p;ss w0rd"
+_note    = not secret
+empty    =
+flag
+
+# Quoting
+[quotes]
+single = 'a "b" \n'
+escaped = "say \"hi\""
+colon: value
+`
+
+const outINI = `; Global settings. Boom.
+_public_key = 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+title = ENC[My App]
+
+[database]
+host     = ENC[db.internal] ; the primary
+password = "ENC[p;ss w0rd]"
+_note    = not secret
+empty    =
+flag
+
+# Quoting
+[quotes]
+single = ENC[a "b" \n]
+escaped = ENC[say "hi"]
+colon: ENC[value]
+`
+
+func TestTransform(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("ENC[%s]", a)), nil
+	}
+	fh := FormatHandler{}
+	out, err := fh.TransformScalarValues([]byte(inINI), xform)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != outINI {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return a, nil
+	}
+	fh := FormatHandler{}
+	for _, in := range []string{"[section\n", "a = \"unterminated\n"} {
+		if _, err := fh.TransformScalarValues([]byte(in), xform); err == nil {
+			t.Errorf("expected error for %q, but none was received", in)
+		}
+	}
+
+	newline := func(a []byte) ([]byte, error) {
+		return []byte("a\nb"), nil
+	}
+	if _, err := fh.TransformScalarValues([]byte("a = b\n"), newline); err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
+
+func TestTransformQuoting(t *testing.T) {
+	fh := FormatHandler{}
+	for val, expected := range map[string]string{
+		`plain`:    `a = plain`,
+		`say "hi"`: `a = say "hi"`,
+		` padded `: `a = " padded "`,
+		`p;ss`:     `a = "p;ss"`,
+		`"p;ss"`:   `a = '"p;ss"'`,
+		`C:\dir;x`: `a = 'C:\dir;x'`,
+		`it's #1`:  `a = "it's #1"`,
+	} {
+		out, err := fh.TransformScalarValues([]byte("a = x"), func([]byte) ([]byte, error) {
+			return []byte(val), nil
+		})
+		if err != nil || string(out) != expected {
+			t.Errorf("%q: expected %q, got %q, %v", val, expected, out, err)
+		}
+	}
+
+	_, err := fh.TransformScalarValues([]byte("a = x"), func([]byte) ([]byte, error) {
+		return []byte(`it's "#1"`), nil
+	})
+	if err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
+
+func TestKeyExtraction(t *testing.T) {
+	fh := FormatHandler{}
+	expected := [32]byte{109, 121, 183, 229, 0, 115, 229, 230, 106, 69, 129, 237, 8, 191, 29, 154, 3, 128, 108, 196, 100, 140, 255, 235, 109, 247, 27, 87, 117, 229, 235, 8}
+	key, err := fh.ExtractPublicKey([]byte(inINI))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(key, expected) {
+		t.Errorf("unexpected key: %#v", key)
+	}
+
+	// only the global section counts
+	_, err = fh.ExtractPublicKey([]byte("[a]\n_public_key = 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08\n"))
+	if err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
@@ -0,0 +1,184 @@
+package json
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+var (
+	_ format.FieldEditor = &FormatHandler{}
+	_ format.FieldEditor = &JSONCFormatHandler{}
+)
+
+// Field returns the value of the top-level string field name.
+func (h *FormatHandler) Field(data []byte, name string) (string, bool, error) {
+	return field(data, name)
+}
+
+// SetField sets the top-level string field name to value. A new field is
+// inserted before _public_key, on a line of its own if _public_key is on one.
+func (h *FormatHandler) SetField(data []byte, name, value string) ([]byte, error) {
+	return setField(data, data, name, value)
+}
+
+// Field behaves exactly like (*FormatHandler).Field, but tolerates comments
+// and trailing commas.
+func (h *JSONCFormatHandler) Field(data []byte, name string) (string, bool, error) {
+	masked, err := maskJSONC(data)
+	if err != nil {
+		return "", false, err
+	}
+	return field(masked, name)
+}
+
+// SetField behaves exactly like (*FormatHandler).SetField, but tolerates
+// comments and trailing commas, which are preserved.
+func (h *JSONCFormatHandler) SetField(data []byte, name, value string) ([]byte, error) {
+	masked, err := maskJSONC(data)
+	if err != nil {
+		return nil, err
+	}
+	return setField(data, masked, name, value)
+}
+
+func field(scan []byte, name string) (string, bool, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(scan, &obj); err != nil {
+		return "", false, syntaxError(scan, err)
+	}
+	return format.FieldHelper(obj, name)
+}
+
+// setField implements SetField. Fields are located in scan, which differs
+// from data only as described on walkScalarValues, and the output is copied
+// from data.
+func setField(data, scan []byte, name, value string) ([]byte, error) {
+	if _, _, err := field(scan, name); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := topLevelFields(scan)
+	if f, ok := fields[name]; ok {
+		return splice(data, f.valueStart, f.valueEnd, encoded), nil
+	}
+	pk, ok := fields[format.PublicKeyField]
+	if !ok {
+		return nil, format.ErrPublicKeyMissing
+	}
+	key, err := json.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	lineStart := bytes.LastIndexByte(data[:pk.keyStart], '\n') + 1
+	indent := data[lineStart:pk.keyStart]
+	sep := []byte(", ")
+	if len(bytes.Trim(indent, " \t")) == 0 {
+		sep = append([]byte(",\n"), indent...)
+	}
+	entry := append(append(append(key, ": "...), encoded...), sep...)
+	return splice(data, pk.keyStart, pk.keyStart, entry), nil
+}
+
+// jsonField locates a field of a JSON object by the offsets of its key and
+// of the start and end of its value.
+type jsonField struct {
+	keyStart, valueStart, valueEnd int
+}
+
+// topLevelFields locates the fields of the top-level object of scan, which
+// must be valid JSON. If a key is repeated, its last field is returned, as
+// it's the one encoding/json decodes.
+func topLevelFields(scan []byte) map[string]jsonField {
+	fields := make(map[string]jsonField)
+	i := skipSpace(scan, 0)
+	if i >= len(scan) || scan[i] != '{' {
+		return fields
+	}
+	for i++; ; {
+		i = skipSpace(scan, i)
+		if i >= len(scan) || scan[i] == '}' {
+			return fields
+		}
+		if scan[i] == ',' {
+			i++
+			continue
+		}
+		keyStart := i
+		i = skipString(scan, i)
+		var name string
+		if err := json.Unmarshal(scan[keyStart:i], &name); err != nil {
+			return fields
+		}
+		i = skipSpace(scan, skipSpace(scan, i)+1) // skip the colon
+		valueStart := i
+		i = skipValue(scan, i)
+		fields[name] = jsonField{keyStart: keyStart, valueStart: valueStart, valueEnd: i}
+	}
+}
+
+func skipSpace(scan []byte, i int) int {
+	for i < len(scan) && isSpace(scan[i]) {
+		i++
+	}
+	return i
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// skipString returns the offset following the string which begins at
+// scan[i].
+func skipString(scan []byte, i int) int {
+	for i++; i < len(scan); i++ {
+		switch scan[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return i
+}
+
+// skipValue returns the offset following the value which begins at scan[i].
+func skipValue(scan []byte, i int) int {
+	depth := 0
+	for i < len(scan) {
+		switch c := scan[i]; {
+		case c == '"':
+			i = skipString(scan, i)
+			if depth == 0 {
+				return i
+			}
+			continue
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			if depth == 0 {
+				return i
+			}
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case depth == 0 && (c == ',' || isSpace(c)):
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// splice returns a copy of data with data[start:end] replaced by repl.
+func splice(data []byte, start, end int, repl []byte) []byte {
+	out := make([]byte, 0, len(data)-(end-start)+len(repl))
+	out = append(out, data[:start]...)
+	out = append(out, repl...)
+	return append(out, data[end:]...)
+}
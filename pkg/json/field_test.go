@@ -0,0 +1,62 @@
+package json
+
+import (
+	"testing"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+func TestSetField(t *testing.T) {
+	cases := []struct {
+		fh       format.FieldEditor
+		in       string
+		expected string
+	}{
+		{
+			&FormatHandler{},
+			"{\n  \"_public_key\": \"k\",\n  \"a\": \"b\"\n}",
+			"{\n  \"_sig\": \"s\",\n  \"_public_key\": \"k\",\n  \"a\": \"b\"\n}",
+		},
+		{
+			&FormatHandler{},
+			`{"a": {"_public_key": "x"}, "_public_key": "k"}`,
+			`{"a": {"_public_key": "x"}, "_sig": "s", "_public_key": "k"}`,
+		},
+		{
+			&FormatHandler{},
+			"{\n  \"_sig\": \"\\\"}\" ,\n  \"_public_key\": \"k\"\n}",
+			"{\n  \"_sig\": \"s\" ,\n  \"_public_key\": \"k\"\n}",
+		},
+		{
+			&JSONCFormatHandler{},
+			"{\n  // \"_sig\": \"x\",\n  \"_public_key\": \"k\", /* key */\n}",
+			"{\n  // \"_sig\": \"x\",\n  \"_sig\": \"s\",\n  \"_public_key\": \"k\", /* key */\n}",
+		},
+	}
+	for _, tc := range cases {
+		out, err := tc.fh.SetField([]byte(tc.in), "_sig", "s")
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if string(out) != tc.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tc.in, tc.expected, out)
+		}
+		if v, ok, err := tc.fh.Field(out, "_sig"); err != nil || !ok || v != "s" {
+			t.Errorf("%q: expected to read back the field, got %q, %v, %v", tc.in, v, ok, err)
+		}
+	}
+}
+
+func TestSetFieldErrors(t *testing.T) {
+	fh := &FormatHandler{}
+	for _, in := range []string{
+		`{"a": "b"}`,
+		`{"_sig": 1, "_public_key": "k"}`,
+		`{"_public_key": "k"`,
+	} {
+		if _, err := fh.SetField([]byte(in), "_sig", "s"); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
@@ -5,6 +5,29 @@ import (
 )
 
 // FormatHandler simply exposes the methods reqwuired of format.FormatHandler.
-type FormatHandler struct{}
+type FormatHandler struct {
+	// Workers is as described on format.ConcurrentHandler.
+	Workers int
+}
 
-var _ format.FormatHandler = &FormatHandler{}
+var (
+	_ format.FormatHandler       = &FormatHandler{}
+	_ format.ScalarValueWalker   = &FormatHandler{}
+	_ format.ScalarValueStreamer = &FormatHandler{}
+	_ format.ConcurrentHandler   = &FormatHandler{}
+)
+
+// WithWorkers implements format.ConcurrentHandler.
+func (h *FormatHandler) WithWorkers(n int) format.FormatHandler {
+	c := *h
+	c.Workers = n
+	return &c
+}
+
+func init() {
+	format.Register("json", []string{".json", ".ejson"}, &FormatHandler{})
+	// .json5 files are accepted as JSONC, which is enough for those using only
+	// comments and trailing commas. There's no "json5" type, since the rest of
+	// JSON5 isn't supported.
+	format.Register("jsonc", []string{".jsonc", ".json5"}, &JSONCFormatHandler{})
+}
@@ -0,0 +1,179 @@
+package json
+
+import (
+	"encoding/json"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// JSONCFormatHandler handles JSON documents containing `//` and `/* */`
+// comments and trailing commas in objects and arrays, as permitted by JSONC
+// and JSON5. The other syntactic extensions of JSON5 (single-quoted strings,
+// unquoted keys, hexadecimal numbers, etc.) are not supported, so only JSON5
+// documents which avoid them can be handled.
+//
+// Comments and trailing commas are preserved verbatim in the output, as is
+// anything following the top-level value.
+type JSONCFormatHandler struct {
+	// Workers is as described on format.ConcurrentHandler.
+	Workers int
+}
+
+var (
+	_ format.FormatHandler     = &JSONCFormatHandler{}
+	_ format.ScalarValueWalker = &JSONCFormatHandler{}
+	_ format.ConcurrentHandler = &JSONCFormatHandler{}
+)
+
+// WithWorkers implements format.ConcurrentHandler.
+func (h *JSONCFormatHandler) WithWorkers(n int) format.FormatHandler {
+	c := *h
+	c.Workers = n
+	return &c
+}
+
+// TransformScalarValues behaves exactly like
+// (*FormatHandler).TransformScalarValues, but tolerates comments and trailing
+// commas.
+func (h *JSONCFormatHandler) TransformScalarValues(
+	data []byte,
+	action func([]byte) ([]byte, error),
+) ([]byte, error) {
+	masked, err := maskJSONC(data)
+	if err != nil {
+		return nil, err
+	}
+	return walkScalarValues(data, masked, format.TransformAction(action), true, h.Workers)
+}
+
+// WalkScalarValues behaves exactly like (*FormatHandler).WalkScalarValues, but
+// tolerates comments and trailing commas.
+func (h *JSONCFormatHandler) WalkScalarValues(
+	data []byte,
+	visit func(format.ScalarValue) ([]byte, error),
+) ([]byte, error) {
+	masked, err := maskJSONC(data)
+	if err != nil {
+		return nil, err
+	}
+	return walkScalarValues(data, masked, visit, true, h.Workers)
+}
+
+// ExtractPublicKey finds the _public_key value in an ecfg document and
+// parses it into a key usable with the crypto library.
+func (h *JSONCFormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return
+	}
+	return format.ExtractPublicKeyHelper(obj)
+}
+
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *JSONCFormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields decodes the top-level object of data, less its comments.
+func (h *JSONCFormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	masked, err := maskJSONC(data)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(masked, &obj); err != nil {
+		return nil, syntaxError(masked, err)
+	}
+	return obj, nil
+}
+
+// Standardize returns a copy of the JSONC document data as plain JSON, with
+// comments and trailing commas replaced by spaces, so that it can be decoded
+// with encoding/json. Line and column numbers are unchanged.
+func Standardize(data []byte) ([]byte, error) {
+	return maskJSONC(data)
+}
+
+// maskJSONC returns a copy of data in which comments and trailing commas have
+// been overwritten with spaces, turning JSONC into plain JSON without changing
+// the offset of any other byte. Newlines inside block comments are kept so
+// that line numbers are unaffected.
+func maskJSONC(data []byte) ([]byte, error) {
+	const (
+		stateCode = iota
+		stateString
+		stateStringEscape
+		stateLineComment
+		stateBlockComment
+	)
+
+	out := make([]byte, len(data))
+	copy(out, data)
+
+	state := stateCode
+	// indices of the last two non-space, non-comment bytes
+	lastSignificant, prevSignificant := -1, -1
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch state {
+		case stateString:
+			if c == '\\' {
+				state = stateStringEscape
+			} else if c == '"' {
+				state = stateCode
+			}
+		case stateStringEscape:
+			state = stateString
+		case stateLineComment:
+			if c == '\n' {
+				state = stateCode
+			} else {
+				out[i] = ' '
+			}
+		case stateBlockComment:
+			if c == '*' && i+1 < len(data) && data[i+1] == '/' {
+				out[i], out[i+1] = ' ', ' '
+				i++
+				state = stateCode
+			} else if c != '\n' {
+				out[i] = ' '
+			}
+		default:
+			switch {
+			case c == '"':
+				state = stateString
+			case c == '/' && i+1 < len(data) && data[i+1] == '/':
+				out[i], out[i+1] = ' ', ' '
+				i++
+				state = stateLineComment
+				continue
+			case c == '/' && i+1 < len(data) && data[i+1] == '*':
+				out[i], out[i+1] = ' ', ' '
+				i++
+				state = stateBlockComment
+				continue
+			case c == '}' || c == ']':
+				// A trailing comma must follow a value: `{,}` is still invalid.
+				if lastSignificant >= 0 && out[lastSignificant] == ',' &&
+					prevSignificant >= 0 && out[prevSignificant] != '{' && out[prevSignificant] != '[' {
+					out[lastSignificant] = ' '
+				}
+			case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+				continue
+			}
+			prevSignificant, lastSignificant = lastSignificant, i
+		}
+	}
+
+	switch state {
+	case stateString, stateStringEscape:
+		return nil, &format.SyntaxError{Msg: "invalid json: unterminated string"}
+	case stateBlockComment:
+		return nil, &format.SyntaxError{Msg: "invalid json: unterminated comment"}
+	}
+	return out, nil
+}
@@ -0,0 +1,78 @@
+package json
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONCScalarValueTransformer(t *testing.T) {
+	action := func(a []byte) ([]byte, error) {
+		return []byte{'E'}, nil
+	}
+
+	for _, tc := range jsoncTestCases {
+		fh := &JSONCFormatHandler{}
+		act, err := fh.TransformScalarValues([]byte(tc.in), action)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if string(act) != tc.out {
+			t.Errorf("unexpected output: '%s'; wanted '%s'", string(act), tc.out)
+		}
+	}
+}
+
+// "E" means encrypted.
+var jsoncTestCases = []testCase{
+	{`{"a": "b"}`, `{"a": "E"}`},                                                       // plain json
+	{`{"a": "b",}`, `{"a": "E",}`},                                                     // trailing comma in object
+	{`{"a": ["b", "c",],}`, `{"a": ["E", "E",],}`},                                     // trailing comma in array
+	{`{"a": "b" /* c */ , }`, `{"a": "E" /* c */ , }`},                                 // comma before comment
+	{"{\n// \"a\": \"b\"\n\"c\": \"d\"\n}\n", "{\n// \"a\": \"b\"\n\"c\": \"E\"\n}\n"}, // line comment
+	{`{/* "a": "b", */ "c": "d"}`, `{/* "a": "b", */ "c": "E"}`},                       // block comment
+	{`{"a": "// not a comment"}`, `{"a": "E"}`},                                        // comment syntax in strings
+	{`{"a": "b\"/*"}`, `{"a": "E"}`},                                                   // escaped quotes
+	{`{"_a": "b", // why` + "\n}", `{"_a": "b", // why` + "\n}"},                       // commenting
+	{`{"a": "b"} // trailing`, `{"a": "E"} // trailing`},
+	{"{\"a\": \"b\" // note\n}\n", "{\"a\": \"E\" // note\n}\n"}, // trailing comment
+}
+
+func TestJSONCInvalid(t *testing.T) {
+	action := func(a []byte) ([]byte, error) {
+		return a, nil
+	}
+	fh := &JSONCFormatHandler{}
+	for _, in := range []string{`{"a": "b" /* c }`, `{"a": "b",,}`, `{,}`} {
+		if _, err := fh.TransformScalarValues([]byte(in), action); err == nil {
+			t.Errorf("expected error for %s, but none was received", in)
+		}
+	}
+}
+
+func TestStandardize(t *testing.T) {
+	in := "// c\n{\"a\": [1, 2,], /* d */ \"b\": \"//\",}"
+	expected := "    \n{\"a\": [1, 2 ],         \"b\": \"//\" }"
+	act, err := Standardize([]byte(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(act) != expected {
+		t.Errorf("expected %q, got %q", expected, act)
+	}
+}
+
+func TestJSONCKeyExtraction(t *testing.T) {
+	fh := JSONCFormatHandler{}
+	in := `{
+  // our key
+  "_public_key": "6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08",
+}`
+	expected := [32]byte{109, 121, 183, 229, 0, 115, 229, 230, 106, 69, 129, 237, 8, 191, 29, 154, 3, 128, 108, 196, 100, 140, 255, 235, 109, 247, 27, 87, 117, 229, 235, 8}
+	key, err := fh.ExtractPublicKey([]byte(in))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(key, expected) {
+		t.Errorf("unexpected key: %#v", key)
+	}
+}
@@ -9,9 +9,46 @@ import (
 // ExtractPublicKey finds the _public_key value in an ecfg document and
 // parses it into a key usable with the crypto library.
 func (h *FormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
-	var obj map[string]interface{}
-	if err = json.Unmarshal(data, &obj); err != nil {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
 		return
 	}
 	return format.ExtractPublicKeyHelper(obj)
 }
+
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *FormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields decodes the top-level object of data.
+func (h *FormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, syntaxError(data, err)
+	}
+	return obj, nil
+}
+
+// syntaxError converts err, as returned by json.Unmarshal(data, ...), into a
+// *format.SyntaxError if it reports that data is malformed.
+func syntaxError(data []byte, err error) error {
+	jsonErr, ok := err.(*json.SyntaxError)
+	if !ok {
+		return err
+	}
+	// The error occurred after reading Offset bytes.
+	offset := int(jsonErr.Offset) - 1
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(data) {
+		offset = len(data)
+	}
+	line, column := format.NewPositioner(data).Position(offset)
+	return &format.SyntaxError{Line: line, Column: column, Msg: "invalid json: " + jsonErr.Error()}
+}
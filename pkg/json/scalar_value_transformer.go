@@ -11,8 +11,13 @@
 package json
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"unicode/utf8"
 
+	"github.com/Shopify/ecfg/pkg/format"
 	"github.com/dustin/gojson"
 )
 
@@ -27,91 +32,305 @@ import (
 // Note that this  underscore-to-disable-encryption syntax does not propagate
 // down the hierarchy to children.
 // That is:
-//   * In {"_a": "b"}, Action will not be run at all.
-//   * In {"a": "b"}, Action will be run with "b", and the return value will
-//      replace "b".
-//   * In {"k": {"a": ["b"]}, Action will run on "b".
-//   * In {"_k": {"a": ["b"]}, Action run on "b".
-//   * In {"k": {"_a": ["b"]}, Action will not run.
+//   - In {"_a": "b"}, Action will not be run at all.
+//   - In {"a": "b"}, Action will be run with "b", and the return value will
+//     replace "b".
+//   - In {"k": {"a": ["b"]}, Action will run on "b".
+//   - In {"_k": {"a": ["b"]}, Action run on "b".
+//   - In {"k": {"_a": ["b"]}, Action will not run.
 func (h *FormatHandler) TransformScalarValues(
 	data []byte,
 	action func([]byte) ([]byte, error),
 ) ([]byte, error) {
-	var (
-		inLiteral    bool
-		literalStart int
-		isComment    bool
-		scanner      json.Scanner
-	)
-	scanner.Reset()
-	pline := newPipeline()
+	return walkScalarValues(data, data, format.TransformAction(action), false, h.Workers)
+}
+
+// WalkScalarValues is like TransformScalarValues, but passes visit the path
+// and position of each actionable node along with its content. Nodes are
+// visited concurrently, by up to h.Workers goroutines.
+func (h *FormatHandler) WalkScalarValues(
+	data []byte,
+	visit func(format.ScalarValue) ([]byte, error),
+) ([]byte, error) {
+	return walkScalarValues(data, data, visit, false, h.Workers)
+}
+
+// StreamScalarValues is like WalkScalarValues, but reads the document from r
+// and writes the result to w as it goes, so that only a bounded portion of the
+// document is held in memory at any time. publicKey is called with the value
+// of the top-level _public_key field as soon as it has been read.
+func (h *FormatHandler) StreamScalarValues(
+	r io.Reader,
+	w io.Writer,
+	publicKey func(string) error,
+	visit func(format.ScalarValue) ([]byte, error),
+) error {
+	wk := newWalker(w, visit, publicKey, false, h.Workers)
+	br := bufio.NewReader(r)
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			wk.pline.Close()
+			return err
+		}
+		done, err := wk.step(c, c)
+		if err != nil {
+			wk.pline.Close()
+			return err
+		}
+		if done {
+			return wk.pline.Close()
+		}
+	}
+	if err := wk.eof(); err != nil {
+		wk.pline.Close()
+		return err
+	}
+	return wk.pline.Close()
+}
+
+// walkScalarValues implements WalkScalarValues. The scanner is run over scan,
+// which must be the same length as data and differ only in bytes that are
+// insignificant to the document structure (see maskJSONC), while output is
+// always copied from data. If verbatim is set, whitespace (and anything masked
+// out of scan) between a value and the following token, and any bytes
+// following the top-level value, are preserved in the output.
+func walkScalarValues(
+	data, scan []byte,
+	visit func(format.ScalarValue) ([]byte, error),
+	verbatim bool,
+	workers int,
+) ([]byte, error) {
+	var buf bytes.Buffer
+	wk := newWalker(&buf, visit, nil, verbatim, workers)
 	for i, c := range data {
-		switch v := scanner.Step(&scanner, int(c)); v {
-		case json.ScanContinue, json.ScanSkipSpace:
-			// Uninteresting byte. Just advance to next.
-		case json.ScanBeginLiteral:
-			inLiteral = true
-			literalStart = i
-		case json.ScanObjectKey:
-			// The literal we just finished reading was a Key. Decide whether it was a
-			// encryptable by checking whether the first byte after the '"' was an
-			// underscore, then append it verbatim to the output buffer.
-			inLiteral = false
-			isComment = data[literalStart+1] == '_'
-			pline.appendBytes(data[literalStart:i])
-		case json.ScanError:
-			// Some error happened; just bail.
-			pline.flush()
-			return nil, fmt.Errorf("invalid json")
-		case json.ScanEnd:
+		done, err := wk.step(c, scan[i])
+		if err != nil {
+			wk.pline.Close()
+			return nil, err
+		}
+		if done {
 			// We successfully hit the end of input.
-			return pline.flush()
-		default:
-			if inLiteral {
-				inLiteral = false
-				// We finished reading some literal, and it wasn't a Key, meaning it's
-				// potentially encryptable. If it was a string, and the most recent Key
-				// encountered didn't begin with a '_', we are to encrypt it. In any
-				// other case, we append it verbatim to the output buffer.
-				if isComment || data[literalStart] != '"' {
-					pline.appendBytes(data[literalStart:i])
-				} else {
-					res := make(chan promiseResult)
-					go func(subData []byte) {
-						actioned, err := runAction(subData, action)
-						res <- promiseResult{actioned, err}
-						close(res)
-					}(data[literalStart:i])
-					pline.appendPromise(res)
-				}
+			if verbatim {
+				wk.pline.Append(data[i:])
+			}
+			if err := wk.pline.Close(); err != nil {
+				return nil, err
+			}
+			return buf.Bytes(), nil
+		}
+	}
+	if err := wk.eof(); err != nil {
+		wk.pline.Close()
+		return nil, err
+	}
+	if err := wk.pline.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// walker holds the state of a walk over a JSON document, which is fed to it a
+// byte at a time.
+type walker struct {
+	visit        func(format.ScalarValue) ([]byte, error)
+	publicKey    func(string) error
+	sawPublicKey bool
+	verbatim     bool
+
+	scanner json.Scanner
+	pline   *format.Pipeline
+	path    format.Path
+
+	inLiteral   bool
+	isComment   bool
+	literal     []byte // the literal being read
+	literalScan []byte // its counterpart in the scanned copy of the document
+	literalLine int
+	literalCol  int
+
+	line, column int
+}
+
+func newWalker(
+	out io.Writer,
+	visit func(format.ScalarValue) ([]byte, error),
+	publicKey func(string) error,
+	verbatim bool,
+	workers int,
+) *walker {
+	wk := &walker{
+		visit:     visit,
+		publicKey: publicKey,
+		verbatim:  verbatim,
+		pline:     format.NewPipeline(out, workers),
+		line:      1,
+		column:    1,
+	}
+	wk.scanner.Reset()
+	return wk
+}
+
+// step processes c, the next byte of the document, and s, the corresponding
+// byte of its scanned copy. It returns done when the top-level value ended
+// before c, in which case c has not been written to the output.
+func (wk *walker) step(c, s byte) (done bool, err error) {
+	switch v := wk.scanner.Step(&wk.scanner, int(s)); v {
+	case json.ScanContinue, json.ScanSkipSpace:
+		// Uninteresting byte. Just advance to next.
+	case json.ScanBeginLiteral:
+		wk.inLiteral = true
+		wk.literal, wk.literalScan = nil, nil
+		wk.literalLine, wk.literalCol = wk.line, wk.column
+	case json.ScanObjectKey:
+		// The literal we just finished reading was a Key. Decide whether it was a
+		// encryptable by checking whether the first byte after the '"' was an
+		// underscore, then append it verbatim to the output buffer.
+		wk.inLiteral = false
+		wk.isComment = wk.literal[1] == '_'
+		key, ok := json.UnquoteBytes(wk.literal[:literalEnd(wk.literalScan, 0, len(wk.literal))])
+		if !ok {
+			return false, &format.SyntaxError{Line: wk.literalLine, Column: wk.literalCol, Msg: "invalid json"}
+		}
+		wk.path[len(wk.path)-1] = format.Key(string(key))
+		wk.pline.Append(wk.literal)
+	case json.ScanError:
+		// Some error happened; just bail.
+		return false, &format.SyntaxError{Line: wk.line, Column: wk.column, Msg: fmt.Sprintf("invalid json: unexpected %q", c)}
+	case json.ScanEnd:
+		return true, nil
+	default:
+		if wk.inLiteral {
+			wk.inLiteral = false
+			if err := wk.endValue(); err != nil {
+				return false, err
 			}
 		}
-		if !inLiteral {
-			// If we're in a literal, we save up bytes because we may have to encrypt
-			// them. Outside of a literal, we simply append each byte as we read it.
-			pline.appendByte(c)
+		// Keep track of where we are in the document, now that any literal
+		// preceding this byte has been dealt with.
+		switch v {
+		case json.ScanBeginObject:
+			wk.path = append(wk.path, format.Key(""))
+		case json.ScanBeginArray:
+			wk.path = append(wk.path, format.Index(0))
+		case json.ScanArrayValue:
+			wk.path[len(wk.path)-1].Index++
+		case json.ScanEndObject, json.ScanEndArray:
+			wk.path = wk.path[:len(wk.path)-1]
 		}
 	}
-	if scanner.EOF() == json.ScanError {
+
+	if wk.inLiteral {
+		// If we're in a literal, we save up bytes because we may have to encrypt
+		// them. Outside of a literal, we simply append each byte as we read it.
+		wk.literal = append(wk.literal, c)
+		wk.literalScan = append(wk.literalScan, s)
+	} else {
+		wk.pline.AppendByte(c)
+	}
+	if c == '\n' {
+		wk.line, wk.column = wk.line+1, 1
+	} else if utf8.RuneStart(c) {
+		wk.column++
+	}
+	return false, nil
+}
+
+// endValue handles a literal which has just been read, and wasn't a key.
+func (wk *walker) endValue() error {
+	literal := wk.literal
+	if wk.publicKey != nil && literal[0] == '"' &&
+		len(wk.path) == 1 && wk.path[0] == format.Key(format.PublicKeyField) {
+		key, ok := json.UnquoteBytes(literal[:literalEnd(wk.literalScan, 0, len(literal))])
+		if !ok {
+			return &format.SyntaxError{Line: wk.literalLine, Column: wk.literalCol, Msg: "invalid json"}
+		}
+		if err := wk.publicKey(string(key)); err != nil {
+			return err
+		}
+		wk.sawPublicKey = true
+	}
+
+	// We finished reading some literal, and it wasn't a Key, meaning it's
+	// potentially encryptable. If it was a string, and the most recent Key
+	// encountered didn't begin with a '_', we are to encrypt it. In any
+	// other case, we append it verbatim to the output buffer.
+	if wk.isComment || literal[0] != '"' {
+		wk.pline.Append(literal)
+		return nil
+	}
+	if wk.publicKey != nil && !wk.sawPublicKey {
+		return format.ErrPublicKeyLate
+	}
+	end := len(literal)
+	if wk.verbatim {
+		end = literalEnd(wk.literalScan, 0, end)
+	}
+	sv := format.ScalarValue{
+		Path:   append(format.Path(nil), wk.path...),
+		Line:   wk.literalLine,
+		Column: wk.literalCol,
+		Kind:   format.ScalarDoubleQuoted,
+	}
+	visit := wk.visit
+	if err := wk.pline.Go(func() ([]byte, error) {
+		return runAction(literal[:end], sv, visit)
+	}); err != nil {
+		return err
+	}
+	wk.pline.Append(literal[end:])
+	return nil
+}
+
+// eof checks that the document is complete once all of it has been stepped
+// through, and outputs a top-level literal, which is never actionable.
+func (wk *walker) eof() error {
+	if wk.scanner.EOF() == json.ScanError {
 		// Unexpected EOF => malformed JSON
-		pline.flush()
-		return nil, fmt.Errorf("invalid json")
+		return &format.SyntaxError{Line: wk.line, Column: wk.column, Msg: "invalid json: unexpected end of input"}
+	}
+	if wk.inLiteral {
+		wk.pline.Append(wk.literal)
 	}
-	return pline.flush()
+	return nil
 }
 
+// literalEnd returns the index just past the last non-space byte of
+// scan[start:end].
+func literalEnd(scan []byte, start, end int) int {
+	for end > start {
+		switch scan[end-1] {
+		case ' ', '\t', '\r', '\n':
+			end--
+		default:
+			return end
+		}
+	}
+	return end
+}
+
+// runAction calls visit with the unquoted content of the JSON string data,
+// returning the quoted result, or data itself if visit returns nil.
 func runAction(
 	data []byte,
-	action func([]byte) ([]byte, error),
+	sv format.ScalarValue,
+	visit func(format.ScalarValue) ([]byte, error),
 ) ([]byte, error) {
 	unquoted, ok := json.UnquoteBytes(data)
 	if !ok {
-		return nil, fmt.Errorf("invalid json")
+		return nil, &format.SyntaxError{Line: sv.Line, Column: sv.Column, Msg: "invalid json"}
 	}
-	done, err := action(unquoted)
+	sv.Value = unquoted
+	done, err := visit(sv)
 	if err != nil {
 		return nil, err
 	}
+	if done == nil {
+		return data, nil
+	}
 	return quoteBytes(done)
 }
 
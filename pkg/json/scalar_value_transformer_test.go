@@ -1,7 +1,14 @@
 package json
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/Shopify/ecfg/pkg/format"
 )
 
 func TestScalarValueTransformer(t *testing.T) {
@@ -40,3 +47,65 @@ var testCases = []testCase{
 	{`{"a": {"_b": "c"}}`, `{"a": {"_b": "c"}}`},     // nested comment
 	{`{"_a": {"b": "c"}}`, `{"_a": {"b": "E"}}`},     // comments don't inherit
 }
+
+func TestWalkScalarValues(t *testing.T) {
+	in := `{
+  "_public_key": "k",
+  "a": "b",
+  "c": {"d": ["e", 1, "f"], "g.h": "i"},
+  "j": [{"k": "l"}, ["m"]]
+}`
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]string)
+	)
+	visit := func(v format.ScalarValue) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[v.Path.String()] = fmt.Sprintf("%d:%d %s %s", v.Line, v.Column, v.Kind, v.Value)
+		if string(v.Value) == "e" {
+			return []byte("E"), nil
+		}
+		return nil, nil
+	}
+
+	fh := &FormatHandler{}
+	out, err := fh.WalkScalarValues([]byte(in), visit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := strings.Replace(in, `"e"`, `"E"`, 1); string(out) != expected {
+		t.Errorf("unexpected output: '%s'", out)
+	}
+	expected := map[string]string{
+		"a":        "3:8 double-quoted b",
+		"c.d[0]":   "4:15 double-quoted e",
+		"c.d[2]":   "4:23 double-quoted f",
+		`c["g.h"]`: "4:36 double-quoted i",
+		"j[0].k":   "5:15 double-quoted l",
+		"j[1][0]":  "5:22 double-quoted m",
+	}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected values visited: %v", seen)
+	}
+}
+
+func TestTransformSyntaxError(t *testing.T) {
+	nop := func(a []byte) ([]byte, error) { return a, nil }
+	cases := []struct {
+		in           string
+		line, column int
+	}{
+		{"{\n  \"a\": x\n}", 2, 8},
+		{"{\n  \"a\": \"b\"", 2, 11},
+	}
+	for _, tc := range cases {
+		_, err := (&FormatHandler{}).TransformScalarValues([]byte(tc.in), nop)
+		var syntaxErr *format.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%q: expected *format.SyntaxError, got %v", tc.in, err)
+		} else if syntaxErr.Line != tc.line || syntaxErr.Column != tc.column {
+			t.Errorf("%q: expected error at %d:%d, got %v", tc.in, tc.line, tc.column, err)
+		}
+	}
+}
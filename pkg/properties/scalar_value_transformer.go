@@ -0,0 +1,321 @@
+// Package properties implements an ecfg format handler for Java `.properties`
+// files, following the syntax accepted by java.util.Properties.load.
+//
+// Entries are `key=value`, `key: value`, or `key value`; lines may be
+// continued with a trailing backslash; and `\uXXXX` and the usual backslash
+// escapes are decoded. Only the byte ranges of encryptable values are
+// replaced, so comments, continuations elsewhere, and layout are preserved
+// exactly.
+package properties
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// FormatHandler simply exposes the methods required of format.FormatHandler.
+type FormatHandler struct{}
+
+var _ format.FormatHandler = &FormatHandler{}
+
+func init() {
+	format.Register("properties", []string{".properties"}, &FormatHandler{})
+}
+
+type entry struct {
+	key        string
+	value      string
+	start, end int // byte range of the value as written
+}
+
+// TransformScalarValues replaces the value of each entry whose key doesn't
+// begin with an underscore with the result of calling action on it. Empty
+// values are left alone.
+func (h *FormatHandler) TransformScalarValues(
+	data []byte,
+	action func([]byte) ([]byte, error),
+) ([]byte, error) {
+	entries, err := entries(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		out  bytes.Buffer
+		prev = 0
+	)
+	for _, e := range entries {
+		if strings.HasPrefix(e.key, "_") || e.value == "" {
+			continue
+		}
+		out.Write(data[prev:e.start])
+		val, err := action([]byte(e.value))
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(escape(string(val)))
+		prev = e.end
+	}
+	out.Write(data[prev:])
+
+	return out.Bytes(), nil
+}
+
+// ExtractPublicKey finds the _public_key entry in an ecfg document and parses
+// it into a key usable with the crypto library. By convention, it should be
+// the first entry in the file.
+func (h *FormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return
+	}
+	return format.ExtractPublicKeyHelper(obj)
+}
+
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *FormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields returns the first _public_key entry of data.
+func (h *FormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	entries, err := entries(data)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	for _, e := range entries {
+		if e.key == format.PublicKeyField {
+			obj[format.PublicKeyField] = e.value
+			break
+		}
+	}
+	return obj, nil
+}
+
+func entries(data []byte) ([]entry, error) {
+	var entries []entry
+	pos, lineNo := 0, 1
+	for pos < len(data) {
+		i := pos
+		for i < len(data) && (data[i] == ' ' || data[i] == '\t' || data[i] == '\f') {
+			i++
+		}
+		if i == len(data) || bytes.IndexByte([]byte("#!\r\n"), data[i]) >= 0 {
+			// Blank and comment lines are never continued.
+			eol := bytes.IndexByte(data[i:], '\n')
+			if eol < 0 {
+				break
+			}
+			pos, lineNo = i+eol+1, lineNo+1
+			continue
+		}
+
+		end, lines := logicalLineEnd(data, pos)
+		e, err := parseEntry(data, i, end)
+		if err != nil {
+			return nil, fmt.Errorf("properties error: line %d: %v", lineNo, err)
+		}
+		entries = append(entries, e)
+		pos, lineNo = end, lineNo+lines
+	}
+	return entries, nil
+}
+
+// logicalLineEnd returns the offset just past the logical line starting at
+// pos (including its terminator), following backslash continuations, and the
+// number of physical lines it spans.
+func logicalLineEnd(data []byte, pos int) (int, int) {
+	lines := 1
+	for {
+		eol := bytes.IndexByte(data[pos:], '\n')
+		if eol < 0 {
+			return len(data), lines
+		}
+		eol += pos
+		line := bytes.TrimRight(data[pos:eol], "\r")
+		if !continues(line) {
+			return eol + 1, lines
+		}
+		pos = eol + 1
+		lines++
+	}
+}
+
+// continues reports whether line ends with an odd number of backslashes.
+func continues(line []byte) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// parseEntry parses the logical line data[start:end], which begins with the
+// first character of the key.
+func parseEntry(data []byte, start, end int) (entry, error) {
+	var e entry
+
+	// The key ends at the first unescaped '=', ':', or whitespace.
+	i := start
+	for i < end {
+		c := data[i]
+		if c == '\\' {
+			i += 2
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' || c == '\r' || c == '\n' {
+			break
+		}
+		i++
+	}
+	if i > end {
+		i = end
+	}
+	key, err := unescape(data[start:i])
+	if err != nil {
+		return e, err
+	}
+	e.key = key
+
+	// The separator is optional whitespace, then at most one of '=' or ':',
+	// then more optional whitespace.
+	i = skipSpace(data, i, end)
+	if i < end && (data[i] == '=' || data[i] == ':') {
+		i = skipSpace(data, i+1, end)
+	}
+
+	// The value runs to the end of the logical line, less its terminator.
+	valueEnd := end
+	for valueEnd > i && (data[valueEnd-1] == '\n' || data[valueEnd-1] == '\r') {
+		valueEnd--
+	}
+	value, err := unescape(data[i:valueEnd])
+	if err != nil {
+		return e, err
+	}
+	e.value = value
+	e.start, e.end = i, valueEnd
+	return e, nil
+}
+
+// skipSpace returns the index of the first byte at or after i which is
+// neither whitespace nor a line continuation.
+func skipSpace(data []byte, i, end int) int {
+	for i < end {
+		switch {
+		case data[i] == ' ' || data[i] == '\t' || data[i] == '\f':
+			i++
+		case data[i] == '\\' && i+1 < end && (data[i+1] == '\n' || data[i+1] == '\r'):
+			i += 2
+			for i < end && (data[i] == '\n' || data[i] == '\r') {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// unescape decodes the escape sequences and line continuations of a key or
+// value as written. Consecutive \uXXXX escapes are decoded together, so that
+// surrogate pairs are combined.
+func unescape(s []byte) (string, error) {
+	var (
+		buf   bytes.Buffer
+		units []uint16
+	)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == 'u' {
+			if i+6 > len(s) {
+				return "", fmt.Errorf("malformed \\uxxxx encoding")
+			}
+			u, err := strconv.ParseUint(string(s[i+2:i+6]), 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uxxxx encoding")
+			}
+			units = append(units, uint16(u))
+			i += 5
+			continue
+		}
+		if len(units) > 0 {
+			buf.WriteString(string(utf16.Decode(units)))
+			units = nil
+		}
+
+		c := s[i]
+		if c != '\\' {
+			buf.WriteByte(c)
+			continue
+		}
+		i++
+		if i == len(s) {
+			break // a trailing backslash at the end of the file is dropped
+		}
+		switch c = s[i]; c {
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 'f':
+			buf.WriteByte('\f')
+		case '\r', '\n':
+			// Continuation: skip the line terminator and the next line's
+			// leading whitespace.
+			if c == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+			for i+1 < len(s) && (s[i+1] == ' ' || s[i+1] == '\t' || s[i+1] == '\f') {
+				i++
+			}
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	if len(units) > 0 {
+		buf.WriteString(string(utf16.Decode(units)))
+	}
+	return buf.String(), nil
+}
+
+// escape encodes a value so that java.util.Properties will read it back
+// unchanged. Non-ASCII characters are written as \uXXXX escapes, since
+// Properties.load(InputStream) assumes ISO-8859-1.
+func escape(val string) string {
+	var buf bytes.Buffer
+	for i, r := range val {
+		switch {
+		case r == '\\':
+			buf.WriteString(`\\`)
+		case r == '\t':
+			buf.WriteString(`\t`)
+		case r == '\n':
+			buf.WriteString(`\n`)
+		case r == '\r':
+			buf.WriteString(`\r`)
+		case r == '\f':
+			buf.WriteString(`\f`)
+		case r == ' ' && i == 0:
+			buf.WriteString(`\ `)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&buf, `\u%04X`, u)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,82 @@
+package properties
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const inProperties = `# Application settings. Boom.
+_public_key=6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+! another comment \
+db.password = hunter2
+db.user:admin
+db.host   localhost
+_db.note = not secret
+greeting = café \
+           au lait
+key\ with\ spaces = v
+empty =
+smile = 😀
+`
+
+const outProperties = `# Application settings. Boom.
+_public_key=6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+! another comment \
+db.password = ENC[hunter2]
+db.user:ENC[admin]
+db.host   ENC[localhost]
+_db.note = not secret
+greeting = ENC[caf\u00E9 au lait]
+key\ with\ spaces = ENC[v]
+empty =
+smile = ENC[\uD83D\uDE00]
+`
+
+func TestTransform(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("ENC[%s]", a)), nil
+	}
+	fh := FormatHandler{}
+	out, err := fh.TransformScalarValues([]byte(inProperties), xform)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != outProperties {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+}
+
+func TestEscapeRoundtrip(t *testing.T) {
+	for _, in := range []string{" leading", "tab\there", "new\nline", `back\slash`, "ünïcödé", "😀", "a=b:c#d"} {
+		out, err := unescape([]byte(escape(in)))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if out != in {
+			t.Errorf("roundtrip mismatch: %q became %q", in, out)
+		}
+	}
+}
+
+func TestTransformInvalid(t *testing.T) {
+	xform := func(a []byte) ([]byte, error) {
+		return a, nil
+	}
+	fh := FormatHandler{}
+	if _, err := fh.TransformScalarValues([]byte(`a = \u12`), xform); err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
+
+func TestKeyExtraction(t *testing.T) {
+	fh := FormatHandler{}
+	expected := [32]byte{109, 121, 183, 229, 0, 115, 229, 230, 106, 69, 129, 237, 8, 191, 29, 154, 3, 128, 108, 196, 100, 140, 255, 235, 109, 247, 27, 87, 117, 229, 235, 8}
+	key, err := fh.ExtractPublicKey([]byte(inProperties))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(key, expected) {
+		t.Errorf("unexpected key: %#v", key)
+	}
+}
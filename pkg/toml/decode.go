@@ -120,7 +120,29 @@ func Decode(data string, v interface{}) (MetaData, error) {
 		p.mapping, p.types, p.ordered,
 		make(map[string]bool, len(p.ordered)), nil,
 	}
-	return md, md.unify(p.mapping, indirect(rv))
+	if err := md.unify(p.mapping, indirect(rv)); err != nil {
+		// On failure, md.context is left at the key being decoded.
+		if len(md.context) > 0 {
+			err = &DecodeError{Key: append(Key(nil), md.context...), Err: err}
+		}
+		return md, err
+	}
+	return md, nil
+}
+
+// DecodeError is returned by Decode when the value of a key can't be decoded
+// into the corresponding Go value.
+type DecodeError struct {
+	Key Key
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v (key %s)", e.Err, e.Key.maybeQuotedAll())
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
 }
 
 // DecodeFile is just like Decode, except it will automatically read the
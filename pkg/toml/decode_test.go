@@ -578,6 +578,25 @@ func TestDecodeMalformedNumbers(t *testing.T) {
 	}
 }
 
+func TestDecodeErrorKey(t *testing.T) {
+	var x struct {
+		A struct {
+			B int
+		}
+	}
+	_, err := Decode("[a]\nb = \"x\"\n", &x)
+	decodeErr, ok := err.(*DecodeError)
+	if !ok {
+		t.Fatalf("got %v; want *DecodeError", err)
+	}
+	if decodeErr.Key.String() != "a.b" {
+		t.Errorf("got key %q; want %q", decodeErr.Key, "a.b")
+	}
+	if !strings.Contains(err.Error(), "(key a.b)") {
+		t.Errorf("got %q; want error mentioning key", err)
+	}
+}
+
 func TestDecodeBadValues(t *testing.T) {
 	for _, tt := range []struct {
 		v    interface{}
@@ -0,0 +1,47 @@
+package toml
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+var _ format.FieldEditor = &FormatHandler{}
+
+// Field returns the value of the top-level string field name.
+func (h *FormatHandler) Field(data []byte, name string) (string, bool, error) {
+	var obj map[string]interface{}
+	if err := Unmarshal(data, &obj); err != nil {
+		if pe, ok := err.(parseError); ok {
+			err = &format.SyntaxError{Line: pe.line, Msg: "toml error: " + pe.detail}
+		}
+		return "", false, err
+	}
+	return format.FieldHelper(obj, name)
+}
+
+// SetField sets the top-level string field name to value. An existing field's
+// value must be written on a single line; the rest of that line, including
+// any comment, is replaced. A new field is inserted on the line before
+// _public_key.
+func (h *FormatHandler) SetField(data []byte, name, value string) ([]byte, error) {
+	if _, _, err := h.Field(data, name); err != nil {
+		return nil, err
+	}
+	quoted := `"` + quotedReplacer.Replace(value) + `"`
+	out, ok := format.SetFieldHelper(data, name, quoted, " = ", isTableHeader)
+	if !ok {
+		return nil, format.ErrPublicKeyMissing
+	}
+	if got, _, err := h.Field(out, name); err != nil || got != value {
+		return nil, fmt.Errorf("can't set %s: top-level fields must be written one per line", name)
+	}
+	return out, nil
+}
+
+// isTableHeader reports whether line begins a table or array of tables,
+// after which no top-level fields may be set.
+func isTableHeader(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, " \t"), []byte("["))
+}
@@ -0,0 +1,41 @@
+package toml
+
+import (
+	"testing"
+)
+
+func TestSetField(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		{
+			"# secrets\n_public_key = \"k\"\n[a]\n_sig = \"x\"\n",
+			"# secrets\n_sig = \"s\"\n_public_key = \"k\"\n[a]\n_sig = \"x\"\n",
+		},
+		{
+			"\"_sig\" = 'old' # comment\r\n_public_key = \"k\"\r\n",
+			"_sig = \"s\"\r\n_public_key = \"k\"\r\n",
+		},
+	}
+	fh := &FormatHandler{}
+	for _, tc := range cases {
+		out, err := fh.SetField([]byte(tc.in), "_sig", "s")
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if string(out) != tc.expected {
+			t.Errorf("%q: expected\n%q\ngot\n%q", tc.in, tc.expected, out)
+		}
+	}
+
+	for _, in := range []string{
+		"[a]\n_public_key = \"k\"\n",
+		"_sig = 1\n_public_key = \"k\"\n",
+	} {
+		if _, err := fh.SetField([]byte(in), "_sig", "s"); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package toml
 
 import (
 	"fmt"
+	"io"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -61,6 +62,14 @@ type lexer struct {
 	state stateFn
 	items chan item
 
+	// When lexing from a reader, input holds the portion of the document
+	// beginning at offset base, which is read from src as needed. Input before
+	// the released offset may be discarded when more is read.
+	src      io.Reader
+	srcErr   error
+	base     int
+	released int
+
 	// A stack of state functions used to maintain context.
 	// The idea is to reuse parts of the state machine in various places.
 	// For example, values can appear at the top level or within arbitrarily
@@ -99,6 +108,64 @@ func lex(input string) *lexer {
 	return lx
 }
 
+// lexReader returns a lexer which reads its input incrementally from r.
+// Offsets in the items it emits are relative to the start of r. Input is
+// retained until it's passed to release, and can be retrieved with slice.
+func lexReader(r io.Reader) *lexer {
+	return &lexer{
+		src:   r,
+		state: lexTop,
+		line:  1,
+		items: make(chan item, 10),
+		stack: make([]stateFn, 0, 10),
+	}
+}
+
+// readChunkSize is the number of bytes read from the source of a lexer at a
+// time.
+const readChunkSize = 32 * 1024
+
+// fill reads more input from the source, if there is one, discarding input
+// that has been released and is no longer needed by the lexer. It reports
+// whether any input was added.
+func (lx *lexer) fill() bool {
+	if lx.src == nil {
+		return false
+	}
+	if discard := min(lx.released, lx.start) - lx.base; discard > 0 {
+		lx.input = lx.input[discard:]
+		lx.base += discard
+	}
+	buf := make([]byte, readChunkSize)
+	for {
+		n, err := lx.src.Read(buf)
+		if n > 0 {
+			lx.input += string(buf[:n])
+			return true
+		}
+		if err != nil {
+			if err != io.EOF {
+				lx.srcErr = err
+			}
+			// Mirror lex, which always appends a newline.
+			lx.input += "\n"
+			lx.src = nil
+			return true
+		}
+	}
+}
+
+// release allows input before offset to be discarded.
+func (lx *lexer) release(offset int) {
+	lx.released = offset
+}
+
+// slice returns the input between the given offsets, which must not have been
+// released.
+func (lx *lexer) slice(start, end int) string {
+	return lx.input[start-lx.base : end-lx.base]
+}
+
 func (lx *lexer) push(state stateFn) {
 	lx.stack = append(lx.stack, state)
 }
@@ -113,7 +180,7 @@ func (lx *lexer) pop() stateFn {
 }
 
 func (lx *lexer) current() string {
-	return lx.input[lx.start:lx.pos]
+	return lx.slice(lx.start, lx.pos)
 }
 
 func (lx *lexer) emit(typ itemType) {
@@ -127,15 +194,18 @@ func (lx *lexer) emitTrim(typ itemType) {
 }
 
 func (lx *lexer) next() (r rune) {
-	if lx.pos >= len(lx.input) {
+	// Make sure that the whole of the next rune has been read.
+	for lx.pos+utf8.UTFMax > lx.base+len(lx.input) && lx.fill() {
+	}
+	if lx.pos >= lx.base+len(lx.input) {
 		lx.width = 0
 		return eof
 	}
 
-	if lx.input[lx.pos] == '\n' {
+	if lx.input[lx.pos-lx.base] == '\n' {
 		lx.line++
 	}
-	r, lx.width = utf8.DecodeRuneInString(lx.input[lx.pos:])
+	r, lx.width = utf8.DecodeRuneInString(lx.input[lx.pos-lx.base:])
 	lx.pos += lx.width
 	return r
 }
@@ -148,7 +218,7 @@ func (lx *lexer) ignore() {
 // backup steps back one rune. Can be called only once per call of next.
 func (lx *lexer) backup() {
 	lx.pos -= lx.width
-	if lx.pos < len(lx.input) && lx.input[lx.pos] == '\n' {
+	if lx.pos < lx.base+len(lx.input) && lx.input[lx.pos-lx.base] == '\n' {
 		lx.line--
 	}
 }
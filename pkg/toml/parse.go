@@ -30,10 +30,14 @@ type parser struct {
 	implicits map[string]bool
 }
 
-type parseError string
+type parseError struct {
+	msg    string
+	line   int    // approximate
+	detail string // msg without the position
+}
 
 func (pe parseError) Error() string {
-	return string(pe)
+	return pe.msg
 }
 
 func parse(data string) (p *parser, err error) {
@@ -66,9 +70,10 @@ func parse(data string) (p *parser, err error) {
 }
 
 func (p *parser) panicf(format string, v ...interface{}) {
+	detail := fmt.Sprintf(format, v...)
 	msg := fmt.Sprintf("Near line %d (last key parsed '%s'): %s",
-		p.approxLine, p.current(), fmt.Sprintf(format, v...))
-	panic(parseError(msg))
+		p.approxLine, p.current(), detail)
+	panic(parseError{msg: msg, line: p.approxLine, detail: detail})
 }
 
 func (p *parser) next() item {
@@ -1,86 +1,324 @@
 package toml
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/Shopify/ecfg/pkg/format"
 )
 
-type FormatHandler struct{}
+type FormatHandler struct {
+	// Workers is as described on format.ConcurrentHandler.
+	Workers int
+}
 
 type encryptableItem struct {
-	val   string
-	start int
-	end   int
+	val        string
+	start      int
+	end        int
+	path       format.Path
+	kind       format.ScalarKind
+	suppressed bool // e.g. because the key begins with an underscore
 }
 
 func (h *FormatHandler) TransformScalarValues(
 	toml []byte,
 	action func([]byte) ([]byte, error),
+) ([]byte, error) {
+	return h.WalkScalarValues(toml, format.TransformAction(action))
+}
+
+// WalkScalarValues is like TransformScalarValues, but passes visit the path,
+// position, and style of each encryptable string along with its content.
+// Tables in an array of tables are indexed by their position in the array,
+// e.g. `products[1].name`.
+func (h *FormatHandler) WalkScalarValues(
+	toml []byte,
+	visit func(format.ScalarValue) ([]byte, error),
 ) ([]byte, error) {
 	var (
-		in   = string(toml)
-		out  = ""
-		prev = 0
+		out   bytes.Buffer
+		pline = format.NewPipeline(&out, h.Workers)
+		prev  = 0
 	)
-	encryptable, err := encryptableItems(in)
+	encryptable, err := encryptableItems(string(toml))
 	if err != nil {
+		pline.Close()
 		return nil, err
 	}
 
+	pos := format.NewPositioner(toml)
 	for _, item := range encryptable {
-		out += in[prev:item.start]
-		val, err := action([]byte(item.val))
-		if err != nil {
-			return nil, err
+		pline.Append(toml[prev:item.start])
+		sv := format.ScalarValue{Path: item.path, Kind: item.kind, Value: []byte(item.val)}
+		sv.Line, sv.Column = pos.Position(item.start)
+		if err := pline.Go(transformItem(toml[item.start:item.end], sv, visit)); err != nil {
+			break
 		}
-		out += fmt.Sprintf("%q", string(val))
 		prev = item.end
 	}
-	out += in[prev:len(in)]
+	pline.Append(toml[prev:])
+
+	if err := pline.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// transformItem returns a function which visits the string value sv, and
+// returns its replacement, or original if it's to be left unchanged.
+func transformItem(
+	original []byte,
+	sv format.ScalarValue,
+	visit func(format.ScalarValue) ([]byte, error),
+) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		val, err := visit(sv)
+		if err != nil || val == nil {
+			return original, err
+		}
+		return []byte(fmt.Sprintf("%q", string(val))), nil
+	}
+}
+
+// StreamScalarValues is like WalkScalarValues, but reads the document from r
+// and writes the result to w as it goes, so that only a bounded portion of the
+// document is held in memory at any time. publicKey is called with the value
+// of the top-level _public_key field as soon as it has been read.
+func (h *FormatHandler) StreamScalarValues(
+	r io.Reader,
+	w io.Writer,
+	publicKey func(string) error,
+	visit func(format.ScalarValue) ([]byte, error),
+) error {
+	var (
+		lexer        = lexReader(r)
+		bw           = bufio.NewWriter(w)
+		pline        = format.NewPipeline(bw, h.Workers)
+		pos          = position{line: 1, column: 1}
+		prev         = 0
+		sawPublicKey = false
+	)
+	// copyTo writes the input up to end unchanged.
+	copyTo := func(end int) error {
+		if end <= prev {
+			return nil
+		}
+		s := lexer.slice(prev, end)
+		pos.advance(s)
+		prev = end
+		lexer.release(prev)
+		pline.Append([]byte(s))
+		return nil
+	}
+
+	err := walkItems(lexer, func(it item, str *encryptableItem) error {
+		if str == nil {
+			return copyTo(it.start)
+		}
+		if err := copyTo(str.start); err != nil {
+			return err
+		}
+		if publicKey != nil && len(str.path) == 1 && str.path[0] == format.Key(format.PublicKeyField) {
+			if err := publicKey(str.val); err != nil {
+				return err
+			}
+			sawPublicKey = true
+		}
+		if str.suppressed {
+			return nil
+		}
+		if publicKey != nil && !sawPublicKey {
+			return format.ErrPublicKeyLate
+		}
+		original := lexer.slice(str.start, str.end)
+		sv := format.ScalarValue{
+			Path:   str.path,
+			Line:   pos.line,
+			Column: pos.column,
+			Kind:   str.kind,
+			Value:  []byte(str.val),
+		}
+		pos.advance(original)
+		prev = str.end
+		lexer.release(prev)
+		return pline.Go(transformItem([]byte(original), sv, visit))
+	})
+	if err == nil {
+		err = lexer.srcErr
+	}
+	if err == nil {
+		// Everything that remains, less the newline added by the lexer.
+		err = copyTo(lexer.base + len(lexer.input) - 1)
+	}
+	if closeErr := pline.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// position tracks the line and column reached in a document which is read
+// incrementally.
+type position struct {
+	line, column int
+}
 
-	return []byte(out), nil
+func (p *position) advance(s string) {
+	for _, r := range s {
+		if r == '\n' {
+			p.line, p.column = p.line+1, 1
+		} else {
+			p.column++
+		}
+	}
 }
 
 func encryptableItems(data string) (enc []encryptableItem, err error) {
-	lexer := lex(data)
+	err = walkItems(lex(data), func(_ item, str *encryptableItem) error {
+		if str != nil && !str.suppressed {
+			enc = append(enc, *str)
+		}
+		return nil
+	})
+	return enc, err
+}
 
-	keyIsNext := false
-	suppressEncryption := false
+// walkItems lexes a TOML document, calling found with each item in turn. For
+// string values, str describes the value, and is marked as suppressed if it
+// mustn't be encrypted.
+func walkItems(lexer *lexer, found func(it item, str *encryptableItem) error) error {
+	var (
+		keyIsNext          bool
+		suppressEncryption bool
+		inTableName        bool
+		tableName          []string    // parts of the table header being lexed
+		table              format.Path // path of the current table
+		key                format.Path // path of the current key
+		arrayIndices       []int       // index of the current element of each open array
+		arrayTables        = make(map[string]int)
+	)
 
 	for {
 		item := lexer.nextItem()
+		var str *encryptableItem
 
-		if keyIsNext {
-			suppressEncryption = strings.HasPrefix(item.val, "_")
+		switch {
+		case item.typ == itemError:
+			return &format.SyntaxError{Line: item.line, Msg: "toml error: " + item.val}
+		case item.typ == itemEOF:
+			return nil
+		case keyIsNext:
+			name := keyName(item)
+			suppressEncryption = strings.HasPrefix(name, "_")
+			key = table.Append(format.Key(name))
+			arrayIndices = arrayIndices[:0]
 			keyIsNext = false
+		case inTableName:
+			switch item.typ {
+			case itemTableEnd:
+				table = tablePath(tableName, arrayTables, false)
+				inTableName = false
+			case itemArrayTableEnd:
+				table = tablePath(tableName, arrayTables, true)
+				inTableName = false
+			default:
+				tableName = append(tableName, keyName(item))
+			}
+		default:
+			switch item.typ {
+			case itemKeyStart:
+				suppressEncryption = false
+				keyIsNext = true
+			case itemTableStart, itemArrayTableStart:
+				inTableName = true
+				tableName = tableName[:0]
+			case itemCommentStart:
+				if err := found(item, nil); err != nil {
+					return err
+				}
+				item = lexer.nextItem() // the comment's text
+			case itemArray:
+				arrayIndices = append(arrayIndices, 0)
+			case itemArrayEnd:
+				arrayIndices = arrayIndices[:len(arrayIndices)-1]
+				if len(arrayIndices) > 0 {
+					arrayIndices[len(arrayIndices)-1]++
+				}
+			case itemString, itemRawString, itemMultilineString, itemRawMultilineString:
+				path := key
+				for _, idx := range arrayIndices {
+					path = path.Append(format.Index(idx))
+				}
+				enc := makeEncryptableItem(item, path)
+				enc.suppressed = suppressEncryption
+				str = &enc
+				if len(arrayIndices) > 0 {
+					arrayIndices[len(arrayIndices)-1]++
+				}
+			case itemBool, itemInteger, itemFloat, itemDatetime:
+				if len(arrayIndices) > 0 {
+					arrayIndices[len(arrayIndices)-1]++
+				}
+			}
 		}
 
-		switch item.typ {
-		case itemKeyStart:
-			suppressEncryption = false
-			keyIsNext = true
-		case itemString, itemRawString, itemMultilineString, itemRawMultilineString:
-			if !suppressEncryption {
-				enc = append(enc, makeEncryptableItem(item))
+		if err := found(item, str); err != nil {
+			return err
+		}
+	}
+}
+
+// keyName returns the name given by a key or table name item, which may be
+// bare or quoted.
+func keyName(it item) string {
+	if it.typ == itemText {
+		return it.val
+	}
+	p := parser{}
+	name, _ := p.value(it)
+	return name.(string)
+}
+
+// tablePath resolves the parts of a table header into the path of the table,
+// indexing into arrays of tables along the way. arrayTables records the index
+// of the last element of each array of tables by path, and is updated if
+// isArray is set, in which case the header adds a new element.
+func tablePath(name []string, arrayTables map[string]int, isArray bool) format.Path {
+	var path format.Path
+	for i, part := range name {
+		path = path.Append(format.Key(part))
+		id := path.String()
+		if isArray && i == len(name)-1 {
+			if n, ok := arrayTables[id]; ok {
+				arrayTables[id] = n + 1
+			} else {
+				arrayTables[id] = 0
 			}
-		case itemEOF:
-			return enc, nil
-		case itemError:
-			return enc, fmt.Errorf("toml error: %s", item.val)
+		}
+		if n, ok := arrayTables[id]; ok {
+			path = path.Append(format.Index(n))
 		}
 	}
+	return path
 }
 
-func makeEncryptableItem(lexItem item) encryptableItem {
+func makeEncryptableItem(lexItem item, path format.Path) encryptableItem {
 	p := parser{}
 	parsedVal, _ := p.value(lexItem)
 
 	adjustment := 0
+	kind := format.ScalarBlock
 	switch lexItem.typ {
-	case itemString, itemRawString:
-		adjustment = 1
+	case itemString:
+		adjustment, kind = 1, format.ScalarDoubleQuoted
+	case itemRawString:
+		adjustment, kind = 1, format.ScalarSingleQuoted
 	case itemMultilineString, itemRawMultilineString:
 		adjustment = 3
 	default:
@@ -91,17 +329,56 @@ func makeEncryptableItem(lexItem item) encryptableItem {
 		val:   parsedVal.(string),
 		start: lexItem.start - adjustment,
 		end:   lexItem.end + adjustment,
+		path:  path,
+		kind:  kind,
 	}
 }
 
 // ExtractPublicKey finds the _public_key value in an ecfg document and
 // parses it into a key usable with the crypto library.
 func (h *FormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
-	var obj map[string]interface{}
-	if err = Unmarshal(data, &obj); err != nil {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
 		return
 	}
 	return format.ExtractPublicKeyHelper(obj)
 }
 
-var _ format.FormatHandler = &FormatHandler{}
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *FormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields decodes the top-level table of data.
+func (h *FormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := Unmarshal(data, &obj); err != nil {
+		if pe, ok := err.(parseError); ok {
+			err = &format.SyntaxError{Line: pe.line, Msg: "toml error: " + pe.detail}
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+var (
+	_ format.FormatHandler       = &FormatHandler{}
+	_ format.ScalarValueWalker   = &FormatHandler{}
+	_ format.ScalarValueStreamer = &FormatHandler{}
+	_ format.ConcurrentHandler   = &FormatHandler{}
+)
+
+// WithWorkers implements format.ConcurrentHandler.
+func (h *FormatHandler) WithWorkers(n int) format.FormatHandler {
+	c := *h
+	c.Workers = n
+	return &c
+}
+
+func init() {
+	format.Register("toml", []string{".toml"}, &FormatHandler{})
+}
@@ -1,8 +1,12 @@
 package toml
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
+
+	"github.com/Shopify/ecfg/pkg/format"
 )
 
 const inToml = `
@@ -100,3 +104,59 @@ func TestTranform(t *testing.T) {
 		t.Errorf("output mismatch. Got:\n========================\n%s", out)
 	}
 }
+
+func TestWalkScalarValues(t *testing.T) {
+	in := `_public_key = "k"
+a = "b" # "not a value"
+"c d" = ['e', 1, ["f"]]
+
+["g.h".i]
+j = """
+k"""
+
+[[l]]
+m = "n"
+
+[[l]]
+m = "o"
+
+[[l.p]]
+q = "r"
+`
+	var seen []string
+	visit := func(v format.ScalarValue) ([]byte, error) {
+		seen = append(seen, fmt.Sprintf("%s %d:%d %s %q", v.Path, v.Line, v.Column, v.Kind, v.Value))
+		return nil, nil
+	}
+	fh := FormatHandler{}
+	out, err := fh.WalkScalarValues([]byte(in), visit)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+	expected := []string{
+		`a 2:5 double-quoted "b"`,
+		`["c d"][0] 3:10 single-quoted "e"`,
+		`["c d"][2][0] 3:19 double-quoted "f"`,
+		`["g.h"].i.j 6:5 block "k"`,
+		`l[0].m 10:5 double-quoted "n"`,
+		`l[1].m 13:5 double-quoted "o"`,
+		`l[1].p[0].q 16:5 double-quoted "r"`,
+	}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected values visited: %q", seen)
+	}
+}
+
+func TestTransformSyntaxError(t *testing.T) {
+	nop := func(a []byte) ([]byte, error) { return a, nil }
+	_, err := (&FormatHandler{}).TransformScalarValues([]byte("a = \"b\"\nc = @\n"), nop)
+	var syntaxErr *format.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Errorf("expected *format.SyntaxError, got %v", err)
+	} else if syntaxErr.Line != 2 {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
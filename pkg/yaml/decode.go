@@ -8,6 +8,8 @@ import (
 	"reflect"
 	"strconv"
 	"time"
+
+	"github.com/Shopify/ecfg/pkg/format"
 )
 
 const (
@@ -93,7 +95,38 @@ func (p *parser) fail() {
 	} else {
 		msg = "unknown problem parsing YAML content"
 	}
-	failf("%s%s", where, msg)
+	mark := p.parser.problem_mark
+	if len(p.parser.problem) == 0 {
+		mark = p.parser.context_mark
+	}
+	fail(&syntaxError{
+		msg:     "yaml: " + where + msg,
+		problem: msg,
+		line:    mark.line + 1,
+		column:  mark.column + 1,
+	})
+}
+
+// syntaxError is reported for documents which can't be parsed. Its message
+// is that of the error failf would produce, whose line number is zero-based,
+// so the precise position is recorded separately for format handlers.
+type syntaxError struct {
+	msg          string
+	problem      string
+	line, column int
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+// formatError converts an error from parsing a document into a
+// *format.SyntaxError, for the format handlers.
+func formatError(err error) error {
+	if e, ok := err.(*syntaxError); ok {
+		return &format.SyntaxError{Line: e.line, Column: e.column, Msg: "yaml: " + e.problem}
+	}
+	return err
 }
 
 func (p *parser) anchor(n *node, anchor []byte) {
@@ -191,6 +224,10 @@ type decoder struct {
 	aliases map[string]bool
 	mapType reflect.Type
 	terrors []string
+	// tpaths holds the key path of the value each of terrors refers to.
+	tpaths []string
+	// path is the key path of the value being decoded.
+	path format.Path
 }
 
 var (
@@ -219,6 +256,23 @@ func (d *decoder) terror(n *node, tag string, out reflect.Value) {
 		}
 	}
 	d.terrors = append(d.terrors, fmt.Sprintf("line %d: cannot unmarshal %s%s into %s", n.line+1, shortTag(tag), value, out.Type()))
+	d.tpaths = append(d.tpaths, d.path.String())
+}
+
+// pushKey descends into the value of the mapping key k. It must be paired
+// with a call to pop.
+func (d *decoder) pushKey(k *node) {
+	d.path = append(d.path, format.Key(k.value))
+}
+
+// pushIndex descends into the i'th element of a sequence. It must be paired
+// with a call to pop.
+func (d *decoder) pushIndex(i int) {
+	d.path = append(d.path, format.Index(i))
+}
+
+func (d *decoder) pop() {
+	d.path = d.path[:len(d.path)-1]
 }
 
 func (d *decoder) callUnmarshaler(n *node, u Unmarshaler) (good bool) {
@@ -228,13 +282,22 @@ func (d *decoder) callUnmarshaler(n *node, u Unmarshaler) (good bool) {
 		d.unmarshal(n, reflect.ValueOf(v))
 		if len(d.terrors) > terrlen {
 			issues := d.terrors[terrlen:]
+			paths := d.tpaths[terrlen:]
 			d.terrors = d.terrors[:terrlen]
-			return &TypeError{issues}
+			d.tpaths = d.tpaths[:terrlen]
+			return &TypeError{Errors: issues, Paths: paths}
 		}
 		return nil
 	})
 	if e, ok := err.(*TypeError); ok {
 		d.terrors = append(d.terrors, e.Errors...)
+		for i := range e.Errors {
+			path := d.path.String()
+			if i < len(e.Paths) {
+				path = e.Paths[i]
+			}
+			d.tpaths = append(d.tpaths, path)
+		}
 		return false
 	}
 	if err != nil {
@@ -495,7 +558,10 @@ func (d *decoder) sequence(n *node, out reflect.Value) (good bool) {
 	j := 0
 	for i := 0; i < l; i++ {
 		e := reflect.New(et).Elem()
-		if ok := d.unmarshal(n.children[i], e); ok {
+		d.pushIndex(i)
+		ok := d.unmarshal(n.children[i], e)
+		d.pop()
+		if ok {
 			out.Index(j).Set(e)
 			j++
 		}
@@ -560,7 +626,10 @@ func (d *decoder) mapping(n *node, out reflect.Value) (good bool) {
 				failf("invalid map key: %#v", k.Interface())
 			}
 			e := reflect.New(et).Elem()
-			if d.unmarshal(n.children[i+1], e) {
+			d.pushKey(n.children[i])
+			ok := d.unmarshal(n.children[i+1], e)
+			d.pop()
+			if ok {
 				out.SetMapIndex(k, e)
 			}
 		}
@@ -590,7 +659,10 @@ func (d *decoder) mappingSlice(n *node, out reflect.Value) (good bool) {
 		k := reflect.ValueOf(&item.Key).Elem()
 		if d.unmarshal(n.children[i], k) {
 			v := reflect.ValueOf(&item.Value).Elem()
-			if d.unmarshal(n.children[i+1], v) {
+			d.pushKey(n.children[i])
+			ok := d.unmarshal(n.children[i+1], v)
+			d.pop()
+			if ok {
 				slice = append(slice, item)
 			}
 		}
@@ -632,13 +704,17 @@ func (d *decoder) mappingStruct(n *node, out reflect.Value) (good bool) {
 			} else {
 				field = out.FieldByIndex(info.Inline)
 			}
+			d.pushKey(ni)
 			d.unmarshal(n.children[i+1], field)
+			d.pop()
 		} else if sinfo.InlineMap != -1 {
 			if inlineMap.IsNil() {
 				inlineMap.Set(reflect.MakeMap(inlineMap.Type()))
 			}
 			value := reflect.New(elemType).Elem()
+			d.pushKey(ni)
 			d.unmarshal(n.children[i+1], value)
+			d.pop()
 			inlineMap.SetMapIndex(name, value)
 		}
 	}
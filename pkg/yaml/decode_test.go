@@ -722,8 +722,8 @@ func (s *S) TestUnmarshalerWholeDocument(c *C) {
 }
 
 func (s *S) TestUnmarshalerTypeError(c *C) {
-	unmarshalerResult[2] = &yaml.TypeError{[]string{"foo"}}
-	unmarshalerResult[4] = &yaml.TypeError{[]string{"bar"}}
+	unmarshalerResult[2] = &yaml.TypeError{Errors: []string{"foo"}}
+	unmarshalerResult[4] = &yaml.TypeError{Errors: []string{"bar"}}
 	defer func() {
 		delete(unmarshalerResult, 2)
 		delete(unmarshalerResult, 4)
@@ -752,6 +752,20 @@ func (s *S) TestUnmarshalerTypeError(c *C) {
 	c.Assert(v.M["ghi"].value, Equals, 3)
 }
 
+func (s *S) TestUnmarshalTypeErrorPaths(c *C) {
+	type T struct {
+		A struct {
+			B []int
+		}
+		C map[string]int
+	}
+	var v T
+	data := "a:\n  b: [1, x]\nc:\n  d.e: y\n"
+	err := yaml.Unmarshal([]byte(data), &v)
+	c.Assert(err, FitsTypeOf, &yaml.TypeError{})
+	c.Assert(err.(*yaml.TypeError).Paths, DeepEquals, []string{"a.b[1]", `c["d.e"]`})
+}
+
 type proxyTypeError struct{}
 
 func (v *proxyTypeError) UnmarshalYAML(unmarshal func(interface{}) error) error {
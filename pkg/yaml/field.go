@@ -0,0 +1,85 @@
+package yaml
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+var (
+	_ format.FieldEditor = &FormatHandler{}
+	_ format.FieldEditor = &SecretFormatHandler{}
+)
+
+// Field returns the value of the top-level string field name.
+func (h *FormatHandler) Field(data []byte, name string) (string, bool, error) {
+	var obj map[string]interface{}
+	if err := Unmarshal(data, &obj); err != nil {
+		return "", false, formatError(err)
+	}
+	return format.FieldHelper(obj, name)
+}
+
+// SetField sets the top-level string field name to value. The top-level
+// mapping must be in block style, and an existing field's value must be
+// written on the same line as its key; the rest of that line, including any
+// comment, is replaced. A new field is inserted on the line before
+// _public_key.
+func (h *FormatHandler) SetField(data []byte, name, value string) ([]byte, error) {
+	if _, _, err := h.Field(data, name); err != nil {
+		return nil, err
+	}
+	out, ok := format.SetFieldHelper(data, name, fmt.Sprintf("%q", value), ": ", nil)
+	if !ok {
+		return nil, format.ErrPublicKeyMissing
+	}
+	if got, _, err := h.Field(out, name); err != nil || got != value {
+		return nil, fmt.Errorf("can't set %s: top-level fields must be in block style, one per line", name)
+	}
+	return out, nil
+}
+
+// secretFieldAnnotation returns the annotation which stands in for the
+// top-level field name of a Secret manifest whose public key is in
+// SecretPublicKeyAnnotation, e.g. "ecfg.shopify.com/signature" for
+// "_ecfg_signature" and SecretPublicKeyAnnotation itself for "_public_key".
+func secretFieldAnnotation(name string) string {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "_"), "ecfg_")
+	return "ecfg.shopify.com/" + strings.Replace(name, "_", "-", -1)
+}
+
+// Field returns the value of the top-level string field name or, if the
+// public key is in SecretPublicKeyAnnotation, of the annotation standing in
+// for it, so that the manifest remains acceptable to `kubectl apply`.
+func (h *SecretFormatHandler) Field(data []byte, name string) (string, bool, error) {
+	obj, err := parseSecretKeys(data)
+	if err != nil {
+		return "", false, err
+	}
+	if !obj.annotated() {
+		return (&FormatHandler{}).Field(data, name)
+	}
+	return format.FieldHelper(obj.Metadata.Annotations, secretFieldAnnotation(name))
+}
+
+// SetField sets the field read by Field, as (*FormatHandler).SetField does. A
+// new annotation is inserted on the line before SecretPublicKeyAnnotation.
+func (h *SecretFormatHandler) SetField(data []byte, name, value string) ([]byte, error) {
+	obj, err := parseSecretKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.annotated() {
+		return (&FormatHandler{}).SetField(data, name, value)
+	}
+	annotation := secretFieldAnnotation(name)
+	out, ok := format.SetFieldBeforeHelper(data, annotation, fmt.Sprintf("%q", value), ": ", SecretPublicKeyAnnotation, nil)
+	if !ok {
+		return nil, format.ErrPublicKeyMissing
+	}
+	if got, _, err := h.Field(out, name); err != nil || got != value {
+		return nil, fmt.Errorf("can't set %s: annotations must be in block style, one per line", annotation)
+	}
+	return out, nil
+}
@@ -0,0 +1,42 @@
+package yaml
+
+import (
+	"testing"
+)
+
+func TestSetField(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected string
+	}{
+		{
+			"# secrets\n_public_key: k\na:\n  _public_key: x\n",
+			"# secrets\n_sig: \"s\"\n_public_key: k\na:\n  _public_key: x\n",
+		},
+		{
+			"a:\n  _sig: x\n_sig: 'old' # comment\n_public_key: k\n",
+			"a:\n  _sig: x\n_sig: \"s\"\n_public_key: k\n",
+		},
+	}
+	fh := &FormatHandler{}
+	for _, tc := range cases {
+		out, err := fh.SetField([]byte(tc.in), "_sig", "s")
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if string(out) != tc.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tc.in, tc.expected, out)
+		}
+	}
+
+	for _, in := range []string{
+		"{_public_key: k, a: b}",
+		"_sig: [x]\n_public_key: k\n",
+		"a: b\n",
+	} {
+		if _, err := fh.SetField([]byte(in), "_sig", "s"); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package yaml
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// SecretPublicKeyAnnotation is the metadata annotation from which the public
+// key of a Kubernetes Secret manifest is read when no top-level _public_key is
+// present. Keeping the key in an annotation allows the decrypted manifest to be
+// passed to `kubectl apply` without tripping strict field validation.
+const SecretPublicKeyAnnotation = "ecfg.shopify.com/public-key"
+
+// SecretFormatHandler handles Kubernetes `kind: Secret` manifests. It differs
+// from FormatHandler in that:
+//
+//   - apiVersion, kind, metadata, and type are never encrypted, even without a
+//     leading underscore;
+//   - values under `data` are base64-encoded in the manifest, so the decoded
+//     bytes are encrypted, and decrypted values are re-encoded as base64;
+//   - values under `stringData` are encrypted as-is.
+type SecretFormatHandler struct {
+	// Workers is as described on format.ConcurrentHandler.
+	Workers int
+}
+
+var (
+	_ format.FormatHandler     = &SecretFormatHandler{}
+	_ format.ScalarValueWalker = &SecretFormatHandler{}
+	_ format.ConcurrentHandler = &SecretFormatHandler{}
+)
+
+// WithWorkers implements format.ConcurrentHandler.
+func (h *SecretFormatHandler) WithWorkers(n int) format.FormatHandler {
+	c := *h
+	c.Workers = n
+	return &c
+}
+
+// secretPassthroughKeys are the top-level keys of a Secret manifest whose
+// values are left untouched.
+var secretPassthroughKeys = map[string]bool{
+	"apiVersion": true,
+	"kind":       true,
+	"metadata":   true,
+	"type":       true,
+}
+
+// IsKubernetesSecret reports whether a YAML document is a Kubernetes Secret
+// manifest, i.e. a mapping with `apiVersion: v1` and `kind: Secret`.
+func IsKubernetesSecret(data []byte) bool {
+	var obj map[string]interface{}
+	if err := Unmarshal(data, &obj); err != nil {
+		return false
+	}
+	return obj["apiVersion"] == "v1" && obj["kind"] == "Secret"
+}
+
+// TransformScalarValues behaves like (*FormatHandler).TransformScalarValues,
+// with the Secret-specific rules described on SecretFormatHandler.
+func (h *SecretFormatHandler) TransformScalarValues(
+	yaml []byte,
+	action func([]byte) ([]byte, error),
+) ([]byte, error) {
+	return h.WalkScalarValues(yaml, format.TransformAction(action))
+}
+
+// WalkScalarValues behaves like (*FormatHandler).WalkScalarValues, with the
+// Secret-specific rules described on SecretFormatHandler. Values under `data`
+// are passed to visit decoded.
+func (h *SecretFormatHandler) WalkScalarValues(
+	yaml []byte,
+	visit func(format.ScalarValue) ([]byte, error),
+) (out []byte, err error) {
+	defer func() { err = formatError(err) }()
+	defer handleErr(&err)
+
+	p := newParser(yaml)
+	defer p.destroy()
+	parse := p.parse()
+	tokenization := p.parser.all_tokens
+
+	coarseValues := findSecretTransformableValues(parse)
+	preciseValues := refineValues(tokenization, coarseValues)
+
+	return transformValues(yaml, preciseValues, visit, h.Workers)
+}
+
+func findSecretTransformableValues(doc *node) (cvalues []coarseValue) {
+	if doc == nil || len(doc.children) == 0 || doc.children[0].kind != mappingNode {
+		return nil
+	}
+	root := doc.children[0]
+	for i := 0; i+1 < len(root.children); i += 2 {
+		key, value := root.children[i], root.children[i+1]
+		path := format.Path{format.Key(key.value)}
+		switch {
+		case secretPassthroughKeys[key.value]:
+			// left untouched
+		case key.value == "data" && value.kind == mappingNode:
+			for j := 1; j < len(value.children); j += 2 {
+				ch := value.children[j]
+				if nodeIsEncryptable(ch, value, value.children[j-1], j) {
+					cvalues = append(cvalues, coarseValue{
+						line:       ch.line,
+						column:     ch.column,
+						path:       path.Append(format.Key(value.children[j-1].value)),
+						value:      ch.value,
+						secretData: true,
+					})
+				}
+			}
+		default:
+			cvalues = findTransformableValues(value, path, cvalues)
+			if nodeIsEncryptable(value, root, key, i+1) {
+				cvalues = append(cvalues, coarseValue{line: value.line, column: value.column, path: path, value: value.value})
+			}
+		}
+	}
+	return cvalues
+}
+
+// secretDataVisitor adapts visit to values stored base64-encoded under a
+// Secret's `data` key. Plaintext values are decoded before being passed to
+// visit, and any result which isn't itself an encrypted message (i.e. the
+// result of a decryption) is re-encoded. Values that are already encrypted are
+// passed through without decoding.
+func secretDataVisitor(visit func(format.ScalarValue) ([]byte, error)) func(format.ScalarValue) ([]byte, error) {
+	return func(v format.ScalarValue) ([]byte, error) {
+		if !looksEncrypted(v.Value) {
+			decoded, err := base64.StdEncoding.DecodeString(string(v.Value))
+			if err != nil {
+				return nil, &format.ValueError{
+					Path:   v.Path,
+					Line:   v.Line,
+					Column: v.Column,
+					Err:    fmt.Errorf("secret data value is not valid base64: %v", err),
+				}
+			}
+			v.Value = decoded
+		}
+		out, err := visit(v)
+		if err != nil || out == nil {
+			return out, err
+		}
+		if looksEncrypted(out) {
+			return out, nil
+		}
+		return []byte(base64.StdEncoding.EncodeToString(out)), nil
+	}
+}
+
+func looksEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, []byte("EJ[")) && bytes.HasSuffix(value, []byte("]"))
+}
+
+// ExtractPublicKey finds the _public_key value at the top level of a Secret
+// manifest or, failing that, in the SecretPublicKeyAnnotation annotation, and
+// parses it into a key usable with the crypto library.
+func (h *SecretFormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return
+	}
+	return format.ExtractPublicKeyHelper(obj)
+}
+
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *SecretFormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields returns _public_key, or else the public key annotation.
+func (h *SecretFormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	obj, err := parseSecretKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if obj.PublicKey != nil {
+		fields[format.PublicKeyField] = *obj.PublicKey
+	} else if annotation, ok := obj.Metadata.Annotations[SecretPublicKeyAnnotation]; ok {
+		fields[format.PublicKeyField] = annotation
+	}
+	return fields, nil
+}
+
+// secretKeys holds the parts of a Secret manifest which may hold its public
+// key.
+type secretKeys struct {
+	PublicKey *string `yaml:"_public_key"`
+	Metadata  struct {
+		Annotations map[string]interface{} `yaml:"annotations"`
+	} `yaml:"metadata"`
+}
+
+func parseSecretKeys(data []byte) (*secretKeys, error) {
+	var obj secretKeys
+	if err := Unmarshal(data, &obj); err != nil {
+		return nil, formatError(err)
+	}
+	return &obj, nil
+}
+
+// annotated reports whether the public key is in SecretPublicKeyAnnotation
+// rather than a top-level _public_key.
+func (k *secretKeys) annotated() bool {
+	_, ok := k.Metadata.Annotations[SecretPublicKeyAnnotation]
+	return k.PublicKey == nil && ok
+}
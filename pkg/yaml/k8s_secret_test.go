@@ -0,0 +1,141 @@
+package yaml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+const inSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  annotations:
+    ecfg.shopify.com/public-key: 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+type: Opaque
+data:
+  password: aHVudGVyMg==
+  _hint: bm90IHNlY3JldA==
+stringData:
+  username: admin
+`
+
+const outSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  annotations:
+    ecfg.shopify.com/public-key: 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+type: Opaque
+data:
+  password: "EJ[hunter2]"
+  _hint: bm90IHNlY3JldA==
+stringData:
+  username: "EJ[admin]"
+`
+
+const roundtrippedSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  annotations:
+    ecfg.shopify.com/public-key: 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08
+type: Opaque
+data:
+  password: "aHVudGVyMg=="
+  _hint: bm90IHNlY3JldA==
+stringData:
+  username: "admin"
+`
+
+func TestSecretTransform(t *testing.T) {
+	encrypt := func(a []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("EJ[%s]", a)), nil
+	}
+	decrypt := func(a []byte) ([]byte, error) {
+		return bytes.TrimSuffix(bytes.TrimPrefix(a, []byte("EJ[")), []byte("]")), nil
+	}
+	fh := SecretFormatHandler{}
+
+	out, err := fh.TransformScalarValues([]byte(inSecret), encrypt)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != outSecret {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+
+	out, err = fh.TransformScalarValues(out, decrypt)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != roundtrippedSecret {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+}
+
+func TestSecretInvalidBase64(t *testing.T) {
+	in := "apiVersion: v1\nkind: Secret\ndata:\n  a: '%%%'\n"
+	fh := SecretFormatHandler{}
+	_, err := fh.TransformScalarValues([]byte(in), func(a []byte) ([]byte, error) { return a, nil })
+	if err == nil {
+		t.Errorf("expected error, but none was received")
+	}
+}
+
+func TestIsKubernetesSecret(t *testing.T) {
+	if !IsKubernetesSecret([]byte(inSecret)) {
+		t.Errorf("expected manifest to be detected as a Secret")
+	}
+	if IsKubernetesSecret([]byte("apiVersion: v1\nkind: ConfigMap\n")) {
+		t.Errorf("ConfigMap detected as a Secret")
+	}
+	if IsKubernetesSecret([]byte("a: b\n")) {
+		t.Errorf("plain document detected as a Secret")
+	}
+}
+
+func TestSecretKeyExtraction(t *testing.T) {
+	fh := SecretFormatHandler{}
+	expected := [32]byte{109, 121, 183, 229, 0, 115, 229, 230, 106, 69, 129, 237, 8, 191, 29, 154, 3, 128, 108, 196, 100, 140, 255, 235, 109, 247, 27, 87, 117, 229, 235, 8}
+
+	key, err := fh.ExtractPublicKey([]byte(inSecret))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(key, expected) {
+		t.Errorf("unexpected key: %#v", key)
+	}
+
+	in := "_public_key: 6d79b7e50073e5e66a4581ed08bf1d9a03806cc4648cffeb6df71b5775e5eb08\napiVersion: v1\nkind: Secret\n"
+	key, err = fh.ExtractPublicKey([]byte(in))
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(key, expected) {
+		t.Errorf("unexpected key: %#v", key)
+	}
+}
+
+func TestSecretWalkScalarValues(t *testing.T) {
+	var seen []string
+	visit := func(v format.ScalarValue) ([]byte, error) {
+		seen = append(seen, fmt.Sprintf("%s %s", v.Path, v.Value))
+		return nil, nil
+	}
+	fh := SecretFormatHandler{}
+	out, err := fh.WalkScalarValues([]byte(inSecret), visit)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != inSecret {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+	expected := []string{"data.password hunter2", "stringData.username admin"}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected values visited: %q", seen)
+	}
+}
@@ -1,6 +1,7 @@
 package yaml
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 
@@ -8,16 +9,25 @@ import (
 )
 
 // FormatHandler simply exposes the methods required of format.FormatHandler.
-type FormatHandler struct{}
+type FormatHandler struct {
+	// Workers is as described on format.ConcurrentHandler.
+	Workers int
+}
 
 type coarseValue struct {
 	line, column int
+	path         format.Path
 	value        string
+	secretData   bool // base64-encoded Secret data; see SecretFormatHandler
 }
 
 type preciseValue struct {
 	startIndex, endIndex int
+	path                 format.Path
+	line, column         int // of the token, 1-based
+	kind                 format.ScalarKind
 	value                string
+	secretData           bool
 }
 
 // TransformScalarValues operates in three phases, over the parse tree, then
@@ -52,6 +62,20 @@ func (h *FormatHandler) TransformScalarValues(
 	yaml []byte,
 	action func([]byte) ([]byte, error),
 ) ([]byte, error) {
+	return h.WalkScalarValues(yaml, format.TransformAction(action))
+}
+
+// WalkScalarValues is like TransformScalarValues, but passes visit the path,
+// position, and style of each actionable value along with its content. The
+// path of a value is built from the scalar content of the mapping keys leading
+// to it.
+func (h *FormatHandler) WalkScalarValues(
+	yaml []byte,
+	visit func(format.ScalarValue) ([]byte, error),
+) (out []byte, err error) {
+	defer func() { err = formatError(err) }()
+	defer handleErr(&err)
+
 	p := newParser(yaml)
 	defer p.destroy()
 	parse := p.parse()
@@ -62,75 +86,135 @@ func (h *FormatHandler) TransformScalarValues(
 		preciseValues []preciseValue
 	)
 
-	coarseValues = findTransformableValues(parse, nil)
-	preciseValues = refineValues(tokenization, coarseValues, nil)
+	coarseValues = findTransformableValues(parse, nil, nil)
+	preciseValues = refineValues(tokenization, coarseValues)
 
-	return transformValues(yaml, preciseValues, action)
+	return transformValues(yaml, preciseValues, visit, h.Workers)
 }
 
+// transformValues replaces each of pvalues in the document with the result of
+// visiting it. Values are visited concurrently by up to workers goroutines.
 func transformValues(
-	bytesIn []byte,
+	in []byte,
 	pvalues []preciseValue,
-	action func([]byte) ([]byte, error),
+	visit func(format.ScalarValue) ([]byte, error),
+	workers int,
 ) ([]byte, error) {
-	in := string(bytesIn)
-	out := ""
+	var out bytes.Buffer
+	pline := format.NewPipeline(&out, workers)
 
 	lastPrinted := 0
 	for _, pvalue := range pvalues {
-		out += in[lastPrinted:pvalue.startIndex]
-		xformed, err := action([]byte(pvalue.value))
+		pline.Append(in[lastPrinted:pvalue.startIndex])
+		v := visit
+		if pvalue.secretData {
+			v = secretDataVisitor(visit)
+		}
+		original := in[pvalue.startIndex:pvalue.endIndex]
+		sv := format.ScalarValue{
+			Path:   pvalue.path,
+			Line:   pvalue.line,
+			Column: pvalue.column,
+			Kind:   pvalue.kind,
+			Value:  []byte(pvalue.value),
+		}
+		err := pline.Go(func() ([]byte, error) {
+			xformed, err := v(sv)
+			if err != nil || xformed == nil {
+				return original, err
+			}
+			return []byte(fmt.Sprintf("%q", xformed)), nil
+		})
 		if err != nil {
-			return nil, err
+			break
 		}
-		out += fmt.Sprintf("%q", xformed)
 		lastPrinted = pvalue.endIndex
 	}
 
-	out += in[lastPrinted:len(in)]
-	return []byte(out), nil
+	pline.Append(in[lastPrinted:])
+	if err := pline.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
 }
 
-func refineValues(tokens []yaml_token_t, cvalues []coarseValue, pvalues []preciseValue) []preciseValue {
-	if len(cvalues) == 0 {
-		return pvalues
-	}
-	l := cvalues[0].line
-	c := cvalues[0].column
-	v := cvalues[0].value
-	cvalues = cvalues[1:]
-
-	tokenIndex := -1
-	matchNextScalar := false
-	for index, token := range tokens {
-		if token.start_mark.line >= l && token.start_mark.column >= c {
-			matchNextScalar = true
+// refineValues locates each of cvalues in the token stream, in a single pass
+// over the tokens, since both are in document order.
+func refineValues(tokens []yaml_token_t, cvalues []coarseValue) []preciseValue {
+	pvalues := make([]preciseValue, 0, len(cvalues))
+	tokenIndex := 0
+	for _, cv := range cvalues {
+		matchNextScalar := false
+		for ; tokenIndex < len(tokens); tokenIndex++ {
+			token := &tokens[tokenIndex]
+			if token.start_mark.line >= cv.line && token.start_mark.column >= cv.column {
+				matchNextScalar = true
+			}
+			if matchNextScalar && token.typ == yaml_SCALAR_TOKEN {
+				break
+			}
 		}
-		if matchNextScalar && token.typ == yaml_SCALAR_TOKEN {
-			tokenIndex = index
-			break
+		if tokenIndex == len(tokens) {
+			failf("no scalar token found for value at line %d, column %d", cv.line+1, cv.column+1)
 		}
-	}
 
-	token := tokens[tokenIndex]
-	pvalues = append(pvalues, preciseValue{startIndex: token.start_mark.index, endIndex: token.end_mark.index, value: v})
+		token := &tokens[tokenIndex]
+		pvalues = append(pvalues, preciseValue{
+			startIndex: token.start_mark.index,
+			endIndex:   token.end_mark.index,
+			path:       cv.path,
+			line:       token.start_mark.line + 1,
+			column:     token.start_mark.column + 1,
+			kind:       scalarKind(token.style),
+			value:      cv.value,
+			secretData: cv.secretData,
+		})
+		tokenIndex++
+	}
+	return pvalues
+}
 
-	tokens = tokens[tokenIndex+1:]
-	return refineValues(tokens, cvalues, pvalues)
+func scalarKind(style yaml_scalar_style_t) format.ScalarKind {
+	switch style {
+	case yaml_SINGLE_QUOTED_SCALAR_STYLE:
+		return format.ScalarSingleQuoted
+	case yaml_DOUBLE_QUOTED_SCALAR_STYLE:
+		return format.ScalarDoubleQuoted
+	case yaml_LITERAL_SCALAR_STYLE, yaml_FOLDED_SCALAR_STYLE:
+		return format.ScalarBlock
+	default:
+		return format.ScalarPlain
+	}
 }
 
-func findTransformableValues(n *node, cvalues []coarseValue) []coarseValue {
+// findTransformableValues collects the encryptable values beneath n, which is
+// found at path.
+func findTransformableValues(n *node, path format.Path, cvalues []coarseValue) []coarseValue {
 	var prevSibling *node
 	for idx, ch := range n.children {
-		cvalues = findTransformableValues(ch, cvalues)
+		chPath := childPath(n, path, prevSibling, idx)
+		cvalues = findTransformableValues(ch, chPath, cvalues)
 		if nodeIsEncryptable(ch, n, prevSibling, idx) {
-			cvalues = append(cvalues, coarseValue{ch.line, ch.column, ch.value})
+			cvalues = append(cvalues, coarseValue{line: ch.line, column: ch.column, path: chPath, value: ch.value})
 		}
 		prevSibling = ch
 	}
 	return cvalues
 }
 
+// childPath returns the path of the index'th child of parent, which is found
+// at path. The keys of a mapping share the path of the mapping itself.
+func childPath(parent *node, path format.Path, prevSibling *node, index int) format.Path {
+	switch {
+	case parent.kind == sequenceNode:
+		return path.Append(format.Index(index))
+	case parent.kind == mappingNode && index%2 == 1:
+		return path.Append(format.Key(prevSibling.value))
+	default:
+		return path
+	}
+}
+
 func nodeIsEncryptable(n, parent, prevSibling *node, index int) bool {
 	switch parent.kind {
 	case sequenceNode:
@@ -145,11 +229,45 @@ func nodeIsEncryptable(n, parent, prevSibling *node, index int) bool {
 // ExtractPublicKey finds the _public_key value in an ecfg document and
 // parses it into a key usable with the crypto library.
 func (h *FormatHandler) ExtractPublicKey(data []byte) (key [32]byte, err error) {
-	var obj map[string]interface{}
-	if err = Unmarshal(data, &obj); err != nil {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
 		return
 	}
 	return format.ExtractPublicKeyHelper(obj)
 }
 
-var _ format.FormatHandler = &FormatHandler{}
+// ExtractPublicKeyString implements format.PublicKeyStringExtractor.
+func (h *FormatHandler) ExtractPublicKeyString(data []byte) (string, error) {
+	obj, err := h.publicKeyFields(data)
+	if err != nil {
+		return "", err
+	}
+	return format.PublicKeyStringHelper(obj)
+}
+
+// publicKeyFields decodes the top-level mapping of data.
+func (h *FormatHandler) publicKeyFields(data []byte) (map[string]interface{}, error) {
+	var obj map[string]interface{}
+	if err := Unmarshal(data, &obj); err != nil {
+		return nil, formatError(err)
+	}
+	return obj, nil
+}
+
+var (
+	_ format.FormatHandler     = &FormatHandler{}
+	_ format.ScalarValueWalker = &FormatHandler{}
+	_ format.ConcurrentHandler = &FormatHandler{}
+)
+
+// WithWorkers implements format.ConcurrentHandler.
+func (h *FormatHandler) WithWorkers(n int) format.FormatHandler {
+	c := *h
+	c.Workers = n
+	return &c
+}
+
+func init() {
+	format.Register("yaml", []string{".yaml", ".yml"}, &FormatHandler{})
+	format.Register("k8s-secret", nil, &SecretFormatHandler{})
+}
@@ -1,8 +1,12 @@
 package yaml
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"testing"
+
+	"github.com/Shopify/ecfg/pkg/format"
 )
 
 const inYaml = `
@@ -44,3 +48,49 @@ func TestTranform(t *testing.T) {
 		t.Errorf("output mismatch. Got:\n========================\n%s", out)
 	}
 }
+
+func TestWalkScalarValues(t *testing.T) {
+	in := `_public_key: k
+a: b
+c:
+  d: ['e', 1, "f"]
+  g.h: |
+    i
+`
+	var seen []string
+	visit := func(v format.ScalarValue) ([]byte, error) {
+		seen = append(seen, fmt.Sprintf("%s %d:%d %s %q", v.Path, v.Line, v.Column, v.Kind, v.Value))
+		return nil, nil
+	}
+	fh := FormatHandler{}
+	out, err := fh.WalkScalarValues([]byte(in), visit)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if string(out) != in {
+		t.Errorf("output mismatch. Got:\n========================\n%s", out)
+	}
+	expected := []string{
+		`a 2:4 plain "b"`,
+		`c.d[0] 4:7 single-quoted "e"`,
+		`c.d[1] 4:12 plain "1"`,
+		`c.d[2] 4:15 double-quoted "f"`,
+		`c["g.h"] 5:8 block "i\n"`,
+	}
+	if !reflect.DeepEqual(seen, expected) {
+		t.Errorf("unexpected values visited: %q", seen)
+	}
+}
+
+func TestTransformSyntaxError(t *testing.T) {
+	nop := func(a []byte) ([]byte, error) { return a, nil }
+	for _, fh := range []format.FormatHandler{&FormatHandler{}, &SecretFormatHandler{}} {
+		_, err := fh.TransformScalarValues([]byte("a: b\n  c: d\n"), nop)
+		var syntaxErr *format.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%T: expected *format.SyntaxError, got %v", fh, err)
+		} else if syntaxErr.Line != 2 || syntaxErr.Column != 4 {
+			t.Errorf("%T: expected error at 2:4, got %v", fh, err)
+		}
+	}
+}
@@ -90,7 +90,7 @@ func Unmarshal(in []byte, out interface{}) (err error) {
 		d.unmarshal(node, v)
 	}
 	if len(d.terrors) > 0 {
-		return &TypeError{d.terrors}
+		return &TypeError{Errors: d.terrors, Paths: d.tpaths}
 	}
 	return nil
 }
@@ -173,6 +173,10 @@ func failf(format string, args ...interface{}) {
 // unmarshaled partially.
 type TypeError struct {
 	Errors []string
+	// Paths holds the key path of the value each of Errors refers to, such
+	// as `a.b[0]`, or "" for the document itself. Errors reported by an
+	// Unmarshaler are attributed to the value it was unmarshaling.
+	Paths []string
 }
 
 func (e *TypeError) Error() string {
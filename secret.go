@@ -0,0 +1,164 @@
+package ecfg
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/Shopify/ecfg/pkg/toml"
+	"github.com/Shopify/ecfg/pkg/yaml"
+)
+
+// redacted is what a Secret is printed, encoded and logged as.
+const redacted = "[REDACTED]"
+
+// Secret holds a decrypted value which is never printed, encoded or logged
+// in plaintext: fmt (with any verb), encoding/json, pkg/yaml, pkg/toml and
+// log/slog all render it as "[REDACTED]". The value is only available by
+// calling Reveal or RevealBytes.
+//
+// A Secret can be used as the type of a field decoded by Unmarshal or
+// LoadFile, from a string in the document. Marshal encrypts Secrets wherever
+// they appear, as it does strings in fields tagged `ecfg:"secret"`, so a
+// Secret survives a round trip through Marshal and Unmarshal.
+//
+// The zero value is an empty Secret. Copies of a Secret share its value, so
+// Destroy affects all of them.
+type Secret struct {
+	// The value is held behind a pointer so that printing a struct with an
+	// unexported Secret field shows only an address.
+	v *secretValue
+}
+
+type secretValue struct {
+	b []byte
+	// encode is set on the copies made by Marshal, which are encoded as their
+	// value so that it can be encrypted.
+	encode bool
+}
+
+var (
+	_ fmt.Formatter          = Secret{}
+	_ fmt.Stringer           = Secret{}
+	_ fmt.GoStringer         = Secret{}
+	_ json.Marshaler         = Secret{}
+	_ yaml.Marshaler         = Secret{}
+	_ encoding.TextMarshaler = Secret{}
+	_ slog.LogValuer         = Secret{}
+	_ json.Unmarshaler       = &Secret{}
+	_ yaml.Unmarshaler       = &Secret{}
+	_ toml.Unmarshaler       = &Secret{}
+)
+
+// NewSecret returns a Secret holding a copy of b.
+func NewSecret(b []byte) Secret {
+	return Secret{v: &secretValue{b: append([]byte(nil), b...)}}
+}
+
+// NewSecretString returns a Secret holding s.
+func NewSecretString(s string) Secret {
+	return Secret{v: &secretValue{b: []byte(s)}}
+}
+
+// Reveal returns the value of the Secret.
+func (s Secret) Reveal() string {
+	return string(s.RevealBytes())
+}
+
+// RevealBytes returns the value of the Secret. The returned slice is the
+// Secret's own buffer, which is zeroed by Destroy; it must not be modified.
+func (s Secret) RevealBytes() []byte {
+	if s.v == nil {
+		return nil
+	}
+	return s.v.b
+}
+
+// Destroy overwrites the value of the Secret with zeros and empties it.
+// Copies of the value made by Reveal, or by decoding, aren't affected.
+func (s Secret) Destroy() {
+	if s.v == nil {
+		return
+	}
+	for i := range s.v.b {
+		s.v.b[i] = 0
+	}
+	s.v.b = nil
+}
+
+// String returns "[REDACTED]".
+func (s Secret) String() string {
+	return redacted
+}
+
+// GoString returns "[REDACTED]".
+func (s Secret) GoString() string {
+	return redacted
+}
+
+// Format writes "[REDACTED]" whatever the verb and flags.
+func (s Secret) Format(f fmt.State, verb rune) {
+	io.WriteString(f, redacted)
+}
+
+// MarshalJSON encodes the Secret as the string "[REDACTED]".
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.encoded())
+}
+
+// MarshalYAML encodes the Secret as the string "[REDACTED]".
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.encoded(), nil
+}
+
+// MarshalText encodes the Secret as "[REDACTED]", for pkg/toml and other
+// encoders which use encoding.TextMarshaler.
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.encoded()), nil
+}
+
+// encoded returns what the Secret is encoded as: "[REDACTED]", unless it's
+// one of Marshal's copies.
+func (s Secret) encoded() string {
+	if s.v != nil && s.v.encode {
+		return string(s.v.b)
+	}
+	return redacted
+}
+
+// LogValue logs the Secret as "[REDACTED]".
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// UnmarshalJSON decodes a JSON string into the Secret.
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*s = NewSecretString(str)
+	return nil
+}
+
+// UnmarshalYAML decodes a YAML scalar into the Secret.
+func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var str string
+	if err := unmarshal(&str); err != nil {
+		return err
+	}
+	*s = NewSecretString(str)
+	return nil
+}
+
+// UnmarshalTOML decodes a TOML string into the Secret.
+func (s *Secret) UnmarshalTOML(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("cannot decode TOML value of type %T into a Secret", v)
+	}
+	*s = NewSecretString(str)
+	return nil
+}
@@ -0,0 +1,114 @@
+package ecfg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ecfg/pkg/toml"
+	"github.com/Shopify/ecfg/pkg/yaml"
+)
+
+type secretConfig struct {
+	User     string `json:"user" yaml:"user" toml:"user"`
+	Password Secret `json:"password" yaml:"password" toml:"password"`
+}
+
+func TestSecretRedacted(t *testing.T) {
+	cfg := secretConfig{User: "admin", Password: NewSecretString("hunter2")}
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d", "%10.3s"} {
+		for _, v := range []interface{}{cfg, &cfg, cfg.Password, &cfg.Password} {
+			if out := fmt.Sprintf(verb, v); strings.Contains(out, "hunter2") || strings.Contains(out, fmt.Sprintf(verb, "hunter2")) {
+				t.Errorf("%s of %T revealed the secret: %s", verb, v, out)
+			}
+		}
+	}
+	if s := fmt.Sprint(cfg.Password); s != "[REDACTED]" {
+		t.Errorf("expected [REDACTED], got %q", s)
+	}
+
+	var buf bytes.Buffer
+	out, err := json.Marshal(cfg)
+	assertNoError(t, err)
+	if string(out) != `{"user":"admin","password":"[REDACTED]"}` {
+		t.Errorf("unexpected JSON: %s", out)
+	}
+	out, err = yaml.Marshal(cfg)
+	assertNoError(t, err)
+	if string(out) != "user: admin\npassword: '[REDACTED]'\n" {
+		t.Errorf("unexpected YAML: %s", out)
+	}
+	buf.Reset()
+	assertNoError(t, toml.NewEncoder(&buf).Encode(cfg))
+	if buf.String() != "user = \"admin\"\npassword = \"[REDACTED]\"\n" {
+		t.Errorf("unexpected TOML: %s", buf.String())
+	}
+
+	buf.Reset()
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("loaded", "password", cfg.Password)
+	if !strings.Contains(buf.String(), "password=[REDACTED]") {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	if cfg.Password.Reveal() != "hunter2" || string(cfg.Password.RevealBytes()) != "hunter2" {
+		t.Errorf("expected Reveal to return the secret")
+	}
+}
+
+func TestSecretDestroy(t *testing.T) {
+	b := []byte("hunter2")
+	s := NewSecret(b)
+	b[0] = 'x'
+	if s.Reveal() != "hunter2" {
+		t.Errorf("expected NewSecret to copy its argument, got %q", s.Reveal())
+	}
+
+	buf := s.RevealBytes()
+	copied := s
+	s.Destroy()
+	if !bytes.Equal(buf, make([]byte, len("hunter2"))) {
+		t.Errorf("expected buffer to be zeroed, got %q", buf)
+	}
+	if s.Reveal() != "" || copied.Reveal() != "" {
+		t.Errorf("expected Secret to be empty after Destroy")
+	}
+
+	var zero Secret
+	zero.Destroy()
+	if zero.Reveal() != "" || zero.RevealBytes() != nil {
+		t.Errorf("expected zero Secret to be empty")
+	}
+}
+
+func TestUnmarshalSecret(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	docs := map[FileType]string{
+		FileTypeJSON: `{"_public_key": "` + testPublicKey + `", "user": "admin", "password": "hunter2"}`,
+		FileTypeYAML: "_public_key: " + testPublicKey + "\nuser: admin\npassword: hunter2\n",
+		FileTypeTOML: "_public_key = \"" + testPublicKey + "\"\nuser = \"admin\"\npassword = \"hunter2\"\n",
+	}
+	for fileType, doc := range docs {
+		encrypted, err := EncryptData([]byte(doc), fileType)
+		assertNoError(t, err)
+
+		var cfg secretConfig
+		if err := Unmarshal(encrypted, fileType, nil, &cfg); err != nil {
+			t.Errorf("%s: unexpected error: %v", fileType, err)
+			continue
+		}
+		if cfg.User != "admin" || cfg.Password.Reveal() != "hunter2" {
+			t.Errorf("%s: unexpected result: user %q, password %q", fileType, cfg.User, cfg.Password.Reveal())
+		}
+	}
+
+	var cfg struct {
+		Password Secret `toml:"password"`
+	}
+	err := decodeTOML([]byte("password = 1\n"), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "into a Secret") {
+		t.Errorf("expected error decoding an integer into a Secret, got %v", err)
+	}
+}
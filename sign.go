@@ -0,0 +1,331 @@
+package ecfg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// SignatureField is the top-level key at which SignData stores a document's
+// signature.
+const SignatureField = format.SignatureField
+
+var (
+	// ErrSignatureMissing is returned by VerifyData for documents without a
+	// SignatureField.
+	ErrSignatureMissing = errors.New("document is not signed")
+	// ErrSignatureInvalid is returned by VerifyData for documents whose
+	// signature is malformed or doesn't match their content.
+	ErrSignatureInvalid = errors.New("document signature is invalid")
+	// ErrSignerUntrusted is returned by VerifyData for documents signed by a
+	// key which isn't trusted.
+	ErrSignerUntrusted = errors.New("document is signed by an untrusted key")
+)
+
+// signatureScheme prefixes signatures, and identifies the algorithm and the
+// construction of the signed digest.
+const signatureScheme = "ed25519"
+
+// GenerateSigningKeypair creates an ed25519 keypair for signing documents. As
+// with GenerateKeypair, the keys are returned hex-encoded; the private key is
+// the 32-byte seed from which ed25519 derives the full key.
+func GenerateSigningKeypair() (pub string, priv string, err error) {
+	return defaultClient.GenerateSigningKeypair()
+}
+
+// ParseSigningKey parses a private key as returned by GenerateSigningKeypair.
+func ParseSigningKey(s string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, errors.New("invalid signing key")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseSignerKey parses a public key as returned by GenerateSigningKeypair.
+func ParseSignerKey(s string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signer key %q", s)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ParseTrustedSigners parses a list of public keys as returned by
+// GenerateSigningKeypair, one per line. Blank lines and lines beginning with
+// "#" are ignored.
+func ParseTrustedSigners(data []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, err := ParseSignerKey(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, s.Err()
+}
+
+// SignFileInPlace signs the ecfg document at filePath with key, as SignData
+// does, and writes the result over it.
+func SignFileInPlace(filePath string, fileType FileType, key ed25519.PrivateKey) (int, error) {
+	return defaultClient.SignFileInPlace(filePath, fileType, key)
+}
+
+// SignData returns the ecfg document data with a signature by key stored in
+// its SignatureField, replacing any signature it already has. The signature
+// covers the document's public key and the key path and content of every
+// value which would be encrypted, in any order, so that values can't be
+// swapped, altered, added or removed without detection. Other fields, whose
+// keys begin with an underscore, aren't covered. Documents should be signed
+// after they've been encrypted, since encryption changes their values.
+//
+// Signing is supported for formats whose handlers implement both
+// format.FieldEditor and format.ScalarValueWalker: currently JSON, JSONC, YAML
+// and TOML.
+func SignData(data []byte, fileType FileType, key ed25519.PrivateKey) ([]byte, error) {
+	return defaultClient.SignData(data, fileType, key)
+}
+
+// VerifyFile verifies the signature of the ecfg document at filePath, as
+// VerifyData does.
+func VerifyFile(filePath string, fileType FileType, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	return defaultClient.VerifyFile(filePath, fileType, trusted)
+}
+
+// VerifyData checks that the ecfg document data has been signed by one of
+// the trusted keys, and hasn't been changed since, returning the key which
+// signed it. Otherwise, the error returned is or wraps ErrSignatureMissing,
+// ErrSignatureInvalid or ErrSignerUntrusted.
+func VerifyData(data []byte, fileType FileType, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	return defaultClient.VerifyData(data, fileType, trusted)
+}
+
+// GenerateSigningKeypair is like the package-level GenerateSigningKeypair,
+// using c.Rand.
+func (c *Client) GenerateSigningKeypair() (pub string, priv string, err error) {
+	r := c.Rand
+	if r == nil {
+		r = rand.Reader
+	}
+	pubKey, privKey, err := ed25519.GenerateKey(r)
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(pubKey), hex.EncodeToString(privKey.Seed()), nil
+}
+
+// SignFileInPlace is like the package-level SignFileInPlace.
+func (c *Client) SignFileInPlace(filePath string, fileType FileType, key ed25519.PrivateKey) (int, error) {
+	data, err := c.fs().ReadFile(filePath)
+	if err != nil {
+		return -1, err
+	}
+
+	fi, err := c.fs().Stat(filePath)
+	if err != nil {
+		return -1, err
+	}
+
+	newdata, err := c.SignData(data, fileType, key)
+	if err != nil {
+		return -1, withFile(err, filePath)
+	}
+
+	if err := c.fs().WriteFile(filePath, newdata, fi.Mode()); err != nil {
+		return -1, err
+	}
+
+	return len(newdata), nil
+}
+
+// SignData is like the package-level SignData.
+func (c *Client) SignData(data []byte, fileType FileType, key ed25519.PrivateKey) ([]byte, error) {
+	editor, walker, err := c.signingHandler(fileType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := signatureDigest(editor, walker, data)
+	if err != nil {
+		return nil, err
+	}
+
+	pub := key.Public().(ed25519.PublicKey)
+	sig := signatureScheme + ":" + hex.EncodeToString(pub) + ":" +
+		base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+	return editor.SetField(data, SignatureField, sig)
+}
+
+// VerifyFile is like the package-level VerifyFile.
+func (c *Client) VerifyFile(filePath string, fileType FileType, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	data, err := c.fs().ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := c.VerifyData(data, fileType, trusted)
+	return signer, withFile(err, filePath)
+}
+
+// VerifyData is like the package-level VerifyData.
+func (c *Client) VerifyData(data []byte, fileType FileType, trusted []ed25519.PublicKey) (ed25519.PublicKey, error) {
+	editor, walker, err := c.signingHandler(fileType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, ok, err := editor.Field(data, SignatureField)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrSignatureMissing
+	}
+	signer, signature, err := parseSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	isTrusted := false
+	for _, key := range trusted {
+		if key.Equal(signer) {
+			isTrusted = true
+			break
+		}
+	}
+	if !isTrusted {
+		return nil, fmt.Errorf("%w: %x", ErrSignerUntrusted, []byte(signer))
+	}
+
+	digest, err := signatureDigest(editor, walker, data)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(signer, digest, signature) {
+		return nil, ErrSignatureInvalid
+	}
+	return signer, nil
+}
+
+// verifyForDecryption checks the signature of data if c.TrustedSigners is
+// set.
+func (c *Client) verifyForDecryption(ctx context.Context, data []byte, fileType FileType) error {
+	if len(c.TrustedSigners) == 0 {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := c.VerifyData(data, fileType, c.TrustedSigners)
+	return err
+}
+
+// signingHandler returns the handler for signing and verifying data, a
+// document of type fileType.
+func (c *Client) signingHandler(fileType FileType, data []byte) (format.FieldEditor, format.ScalarValueWalker, error) {
+	fh, err := c.handlerForDocument(fileType, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	editor, ok := fh.(format.FieldEditor)
+	walker, walkable := fh.(format.ScalarValueWalker)
+	if !ok || !walkable {
+		return nil, nil, fmt.Errorf("can't sign or verify %s documents", fileType)
+	}
+	return editor, walker, nil
+}
+
+// parseSignature parses the value of a SignatureField, of the form
+// "ed25519:<hex signer key>:<base64 signature>".
+func parseSignature(s string) (ed25519.PublicKey, []byte, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] != signatureScheme {
+		return nil, nil, ErrSignatureInvalid
+	}
+	signer, err := hex.DecodeString(parts[1])
+	if err != nil || len(signer) != ed25519.PublicKeySize {
+		return nil, nil, ErrSignatureInvalid
+	}
+	signature, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, nil, ErrSignatureInvalid
+	}
+	return ed25519.PublicKey(signer), signature, nil
+}
+
+// signatureDigest returns the digest of data which is signed: a hash of its
+// public key and of an encoding of the key path and content of each value
+// which would be encrypted. The encodings are sorted, so that the digest
+// doesn't depend on the order of the document, which the walker may not
+// preserve when visiting values concurrently.
+func signatureDigest(fh format.FormatHandler, walker format.ScalarValueWalker, data []byte) ([]byte, error) {
+	pubkey, err := extractPublicKey(fh, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu      sync.Mutex
+		entries [][]byte
+	)
+	_, err = walker.WalkScalarValues(data, func(v format.ScalarValue) ([]byte, error) {
+		entry := digestEntry(v)
+		mu.Lock()
+		entries = append(entries, entry)
+		mu.Unlock()
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i], entries[j]) < 0
+	})
+
+	h := sha256.New()
+	h.Write([]byte("ecfg " + signatureScheme + " signature v1\x00"))
+	h.Write(pubkey.bytes())
+	for _, entry := range entries {
+		h.Write(entry)
+	}
+	return h.Sum(nil), nil
+}
+
+// digestEntry encodes the path and content of v unambiguously: each key is
+// tagged 'k' and prefixed with its length, each index tagged 'i', and the
+// content tagged 'v' and prefixed with its length.
+func digestEntry(v format.ScalarValue) []byte {
+	var entry []byte
+	for _, el := range v.Path {
+		if el.IsIndex {
+			entry = append(entry, 'i')
+			entry = binary.AppendUvarint(entry, uint64(el.Index))
+			continue
+		}
+		entry = append(entry, 'k')
+		entry = binary.AppendUvarint(entry, uint64(len(el.Key)))
+		entry = append(entry, el.Key...)
+	}
+	entry = append(entry, 'v')
+	entry = binary.AppendUvarint(entry, uint64(len(v.Value)))
+	return append(entry, v.Value...)
+}
@@ -0,0 +1,165 @@
+package ecfg
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newSigningKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	pub, priv, err := GenerateSigningKeypair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := ParseSigningKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey, err := ParseSignerKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pubKey, privKey
+}
+
+func TestSignAndVerify(t *testing.T) {
+	pub, priv := newSigningKey(t)
+	docs := []struct {
+		fileType FileType
+		doc      string
+	}{
+		{FileTypeJSON, `{"_public_key": "` + testPublicKey + `", "a": "EJ[1]", "b": ["EJ[2]", "EJ[3]"]}`},
+		{FileTypeYAML, "_public_key: " + testPublicKey + "\na: EJ[1]\nb:\n  - EJ[2]\n  - EJ[3]\n"},
+		{FileTypeTOML, "_public_key = \"" + testPublicKey + "\"\na = \"EJ[1]\"\nb = [\"EJ[2]\", \"EJ[3]\"]\n"},
+	}
+	for _, d := range docs {
+		signed, err := SignData([]byte(d.doc), d.fileType, priv)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", d.fileType, err)
+			continue
+		}
+		if signer, err := VerifyData(signed, d.fileType, []ed25519.PublicKey{pub}); err != nil || !signer.Equal(pub) {
+			t.Errorf("%s: expected valid signature, got %v", d.fileType, err)
+		}
+
+		resigned, err := SignData(signed, d.fileType, priv)
+		if err != nil {
+			t.Errorf("%s: unexpected error re-signing: %v", d.fileType, err)
+		} else if len(resigned) != len(signed) {
+			t.Errorf("%s: expected signature to be replaced, got\n%s", d.fileType, resigned)
+		}
+
+		tampered := map[string]string{
+			"swapped": strings.NewReplacer("EJ[1]", "EJ[2]", "EJ[2]", "EJ[1]").Replace(string(signed)),
+			"altered": strings.Replace(string(signed), "EJ[3]", "EJ[4]", 1),
+			"removed": strings.Replace(strings.Replace(string(signed), ", \"EJ[3]\"", "", 1), "\n  - EJ[3]", "", 1),
+			"rekeyed": strings.Replace(string(signed), testPublicKey[:8], "00000000", 1),
+		}
+		for name, doc := range tampered {
+			if doc == string(signed) {
+				t.Fatalf("%s: %s document is unchanged", d.fileType, name)
+			}
+			if _, err := VerifyData([]byte(doc), d.fileType, []ed25519.PublicKey{pub}); !errors.Is(err, ErrSignatureInvalid) {
+				t.Errorf("%s: expected %s document to fail verification, got %v", d.fileType, name, err)
+			}
+		}
+	}
+}
+
+func TestSignKubernetesSecret(t *testing.T) {
+	pub, priv := newSigningKey(t)
+	const manifest = "apiVersion: v1\nkind: Secret\nmetadata:\n  name: app\n%s\ndata:\n  a: EJ[1]\nstringData:\n  b: EJ[2]\n"
+	cases := []struct {
+		key, signature string
+	}{
+		{"_public_key: " + testPublicKey, "\n_ecfg_signature: \"ed25519:"},
+		{"  annotations:\n    ecfg.shopify.com/public-key: " + testPublicKey, "\n    ecfg.shopify.com/signature: \"ed25519:"},
+	}
+	for _, tc := range cases {
+		doc := fmt.Sprintf(manifest, tc.key)
+		signed, err := SignData([]byte(doc), FileTypeYAML, priv)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.key, err)
+			continue
+		}
+		if !strings.Contains(string(signed), tc.signature) {
+			t.Errorf("%q: expected signature %q, got\n%s", tc.key, tc.signature, signed)
+		}
+		if signer, err := VerifyData(signed, FileTypeYAML, []ed25519.PublicKey{pub}); err != nil || !signer.Equal(pub) {
+			t.Errorf("%q: expected valid signature, got %v", tc.key, err)
+		}
+		tampered := strings.Replace(string(signed), "a: EJ[1]", "a: EJ[3]", 1)
+		if _, err := VerifyData([]byte(tampered), FileTypeYAML, []ed25519.PublicKey{pub}); !errors.Is(err, ErrSignatureInvalid) {
+			t.Errorf("%q: expected tampered document to fail verification, got %v", tc.key, err)
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	pub, priv := newSigningKey(t)
+	other, _ := newSigningKey(t)
+	doc := []byte(`{"_public_key": "` + testPublicKey + `", "a": "EJ[1]"}`)
+	signed, err := SignData(doc, FileTypeJSON, priv)
+	assertNoError(t, err)
+
+	if _, err := VerifyData(doc, FileTypeJSON, []ed25519.PublicKey{pub}); !errors.Is(err, ErrSignatureMissing) {
+		t.Errorf("expected ErrSignatureMissing, got %v", err)
+	}
+	if _, err := VerifyData(signed, FileTypeJSON, []ed25519.PublicKey{other}); !errors.Is(err, ErrSignerUntrusted) {
+		t.Errorf("expected ErrSignerUntrusted, got %v", err)
+	}
+	malformed := bytes.Replace(signed, []byte(`"_ecfg_signature": "ed25519:`), []byte(`"_ecfg_signature": "rsa:`), 1)
+	if _, err := VerifyData(malformed, FileTypeJSON, []ed25519.PublicKey{pub}); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("expected ErrSignatureInvalid, got %v", err)
+	}
+	if _, err := SignData([]byte("_public_key = "+testPublicKey+"\n"), FileTypeINI, priv); err == nil {
+		t.Errorf("expected error signing INI document")
+	}
+}
+
+func TestDecryptRequireSignature(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	pub, priv := newSigningKey(t)
+	encrypted, err := EncryptData([]byte(`{"_public_key": "`+testPublicKey+`", "a": "b"}`), FileTypeJSON)
+	assertNoError(t, err)
+	signed, err := SignData(encrypted, FileTypeJSON, priv)
+	assertNoError(t, err)
+
+	if _, err := DecryptData(signed, nil, FileTypeJSON, RequireSignature(pub)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := DecryptData(encrypted, nil, FileTypeJSON, RequireSignature(pub)); !errors.Is(err, ErrSignatureMissing) {
+		t.Errorf("expected ErrSignatureMissing, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "secrets.ejson")
+	if err := os.WriteFile(path, signed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyFile(path, FileTypeJSON, []ed25519.PublicKey{pub}); err != nil {
+		t.Errorf("unexpected error verifying file: %v", err)
+	}
+
+	var out bytes.Buffer
+	opts := StreamOptions{FileType: FileTypeJSON, TrustedSigners: []ed25519.PublicKey{pub}}
+	if err := DecryptStream(bytes.NewReader(encrypted), &out, opts); !errors.Is(err, ErrSignatureMissing) || out.Len() != 0 {
+		t.Errorf("expected ErrSignatureMissing and no output, got %v and %q", err, out.Bytes())
+	}
+}
+
+func TestParseTrustedSigners(t *testing.T) {
+	pub, _, err := GenerateSigningKeypair()
+	assertNoError(t, err)
+	keys, err := ParseTrustedSigners([]byte("# deploy\n" + pub + "\n\n"))
+	if err != nil || len(keys) != 1 {
+		t.Errorf("expected one key, got %v, %v", keys, err)
+	}
+	if _, err := ParseTrustedSigners([]byte("# deploy\nnope\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error on line 2, got %v", err)
+	}
+}
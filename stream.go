@@ -0,0 +1,233 @@
+package ecfg
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// StreamOptions configures EncryptStream and DecryptStream.
+type StreamOptions struct {
+	// FileType is the format of the document. It's required.
+	FileType FileType
+	// Keypath lists the directories searched for the private key by
+	// DecryptStream. If nil, DefaultKeypath is used.
+	Keypath []string
+	// KeepGoing and Placeholder make DecryptStream best-effort, as the
+	// Client fields of the same names do. The whole document is written to
+	// w before a *PartialDecryptError is returned.
+	KeepGoing   bool
+	Placeholder string
+	// TrustedSigners makes DecryptStream refuse documents which haven't been
+	// signed by one of these keys, as Client.TrustedSigners does. Documents
+	// are then read in full before any output is written.
+	TrustedSigners []ed25519.PublicKey
+	// AllowUnencrypted makes DecryptStream leave values which haven't been
+	// encrypted at all as they are, as Client.AllowUnencrypted does.
+	AllowUnencrypted bool
+}
+
+// EncryptStream reads an ecfg document from r and writes it to w with all
+// encryptable-but-unencrypted values encrypted, as EncryptData does.
+//
+// Documents in formats which support it (currently JSON and TOML) are
+// processed incrementally, so that memory usage doesn't grow with the size of
+// the document, provided that the public key comes before any value to be
+// encrypted, as is conventional. Documents in other formats, and those whose
+// public key comes later, are read in full first. Nothing is written to w
+// until the public key has been read, but once output has begun, if an error
+// is returned, part of the output may already have been written to w.
+func EncryptStream(r io.Reader, w io.Writer, opts StreamOptions) error {
+	return defaultClient.EncryptStream(context.Background(), r, w, opts.FileType)
+}
+
+// DecryptStream reads an encrypted ecfg document from r and writes the
+// decrypted document to w, as DecryptData does. The private key is searched
+// for in opts.Keypath. Like EncryptStream, documents in JSON and TOML are
+// processed incrementally unless their public key comes after a value to be
+// decrypted, and other formats are read in full first.
+func DecryptStream(r io.Reader, w io.Writer, opts StreamOptions) error {
+	c := &Client{
+		Keypath:          opts.Keypath,
+		KeepGoing:        opts.KeepGoing,
+		Placeholder:      opts.Placeholder,
+		TrustedSigners:   opts.TrustedSigners,
+		AllowUnencrypted: opts.AllowUnencrypted,
+	}
+	return c.DecryptStream(context.Background(), r, w, opts.FileType)
+}
+
+// EncryptStream is like the package-level EncryptStream, but stops and
+// returns ctx.Err() if ctx is done before the whole document has been
+// written.
+func (c *Client) EncryptStream(ctx context.Context, r io.Reader, w io.Writer, fileType FileType) error {
+	fh, err := c.handler(fileType)
+	if err != nil {
+		return err
+	}
+	fh = c.encryptHandler(fh)
+	streamer, ok := fh.(format.ScalarValueStreamer)
+	if !ok {
+		return transformStream(r, w, func(data []byte) ([]byte, error) {
+			return c.EncryptData(ctx, data, fileType)
+		})
+	}
+
+	var encrypter *crypto.Encrypter
+	ks := &keyedStream{r: r, w: w}
+	err = streamer.StreamScalarValues(ks, ks,
+		func(key string) error {
+			pubkey, err := parsePublicKey(key)
+			if err != nil {
+				return err
+			}
+			if encrypter, err = c.encrypter(pubkey); err != nil {
+				return err
+			}
+			return ks.release()
+		},
+		func(v format.ScalarValue) ([]byte, error) {
+			return boundVisitor(ctx, encrypter.EncryptBound)(v)
+		},
+	)
+	if errors.Is(err, format.ErrPublicKeyLate) {
+		return transformStream(ks.restart(), w, func(data []byte) ([]byte, error) {
+			return c.EncryptData(ctx, data, fileType)
+		})
+	}
+	if err == nil && encrypter == nil {
+		err = format.ErrPublicKeyMissing
+	}
+	return contextErr(ctx, err)
+}
+
+// DecryptStream is like the package-level DecryptStream, searching for the
+// private key as described on Client, and stopping and returning ctx.Err()
+// if ctx is done before the whole document has been written.
+func (c *Client) DecryptStream(ctx context.Context, r io.Reader, w io.Writer, fileType FileType) error {
+	fh, err := c.handler(fileType)
+	if err != nil {
+		return err
+	}
+	streamer, ok := fh.(format.ScalarValueStreamer)
+	if !ok || len(c.TrustedSigners) > 0 {
+		return transformStream(r, w, func(data []byte) ([]byte, error) {
+			return c.DecryptData(ctx, data, fileType)
+		})
+	}
+
+	var decrypter *crypto.Decrypter
+	var failures decryptFailures
+	ks := &keyedStream{r: r, w: w}
+	err = streamer.StreamScalarValues(ks, ks,
+		func(key string) error {
+			pubkey, err := parsePublicKey(key)
+			if err != nil {
+				return err
+			}
+			if decrypter, err = c.decrypter(ctx, pubkey); err != nil {
+				return err
+			}
+			return ks.release()
+		},
+		func(v format.ScalarValue) ([]byte, error) {
+			if c.KeepGoing {
+				return failures.visit(ctx, decrypter, c.Placeholder, v)
+			}
+			return boundVisitor(ctx, decrypter.DecryptBound)(v)
+		},
+	)
+	if errors.Is(err, format.ErrPublicKeyLate) {
+		return transformStream(ks.restart(), w, func(data []byte) ([]byte, error) {
+			return c.DecryptData(ctx, data, fileType)
+		})
+	}
+	if err == nil && decrypter == nil {
+		err = format.ErrPublicKeyMissing
+	}
+	if err == nil {
+		err = failures.err()
+	}
+	return contextErr(ctx, err)
+}
+
+// keyedStream sits between a ScalarValueStreamer and the reader and writer of
+// a stream. Until release is called, once the public key has been read, it
+// keeps what has been read from r, and holds back what's written, so that
+// the stream can be restarted as a whole document if the public key turns out
+// to come too late, and so that nothing is written if it's missing or
+// invalid.
+type keyedStream struct {
+	r io.Reader
+	w io.Writer
+
+	mu       sync.Mutex // guards the fields below; the writer has its own goroutine
+	released bool
+	read     []byte
+	held     bytes.Buffer
+}
+
+func (ks *keyedStream) Read(p []byte) (int, error) {
+	n, err := ks.r.Read(p)
+	ks.mu.Lock()
+	if !ks.released {
+		ks.read = append(ks.read, p[:n]...)
+	}
+	ks.mu.Unlock()
+	return n, err
+}
+
+func (ks *keyedStream) Write(p []byte) (int, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if !ks.released {
+		return ks.held.Write(p)
+	}
+	return ks.w.Write(p)
+}
+
+// release writes out what has been held back, and passes everything written
+// from now on straight through.
+func (ks *keyedStream) release() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.released = true
+	ks.read = nil
+	_, err := ks.w.Write(ks.held.Bytes())
+	ks.held = bytes.Buffer{}
+	return err
+}
+
+// restart returns a reader over the whole of the stream, discarding any output
+// held back. It's only valid before release is called.
+func (ks *keyedStream) restart() io.Reader {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.held = bytes.Buffer{}
+	return io.MultiReader(bytes.NewReader(ks.read), ks.r)
+}
+
+// transformStream applies transform to the whole of r, writing the result to
+// w. If transform returns a document along with an error, as best-effort
+// decryption does, the document is written before the error is returned.
+func transformStream(r io.Reader, w io.Writer, transform func([]byte) ([]byte, error)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out, err := transform(data)
+	if out == nil {
+		return err
+	}
+	if _, werr := w.Write(out); werr != nil {
+		return werr
+	}
+	return err
+}
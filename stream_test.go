@@ -0,0 +1,246 @@
+package ecfg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const (
+	testPublicKey  = "8d8647e2eeb6d2e31228e6df7da3df921ec3b799c3f66a171cd37a1ed3004e7d"
+	testPrivateKey = "c5caa31a5b8cb2be0074b37c56775f533b368b81d8fd33b94181f79bd6e47f87"
+)
+
+func TestStreamRoundtrip(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	cases := []struct {
+		fileType FileType
+		in       string
+		match    *regexp.Regexp
+	}{
+		{
+			FileTypeJSON,
+			`{"_public_key": "` + testPublicKey + `", "a": "b", "c": ["d", 1], "_e": "f"}`,
+			regexp.MustCompile(`^{"_public_key": "8d8.*", "a": "EJ\[.*\]", "c": \["EJ\[.*\]", 1\], "_e": "f"}$`),
+		},
+		{
+			FileTypeTOML,
+			"_public_key = \"" + testPublicKey + "\" # key\n[a]\nb = 'c'\nd = [\"x\", \"é\"]\n",
+			regexp.MustCompile(`^_public_key = "8d8.*" # key\n\[a\]\nb = "EJ\[.*\]"\nd = \["EJ\[.*\]", "EJ\[.*\]"\]\n$`),
+		},
+		{
+			FileTypeYAML, // not streamed
+			"_public_key: " + testPublicKey + "\na: b\n",
+			regexp.MustCompile(`^_public_key: 8d8.*\na: "EJ\[.*\]"\n$`),
+		},
+	}
+	for _, tc := range cases {
+		var encrypted bytes.Buffer
+		err := EncryptStream(strings.NewReader(tc.in), &encrypted, StreamOptions{FileType: tc.fileType})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.fileType, err)
+			continue
+		}
+		if !tc.match.Match(encrypted.Bytes()) {
+			t.Errorf("%s: unexpected output: %s", tc.fileType, encrypted.Bytes())
+		}
+
+		expected, err := DecryptData(encrypted.Bytes(), nil, tc.fileType)
+		assertNoError(t, err)
+		var decrypted bytes.Buffer
+		err = DecryptStream(&encrypted, &decrypted, StreamOptions{FileType: tc.fileType})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.fileType, err)
+			continue
+		}
+		if !bytes.Equal(decrypted.Bytes(), expected) {
+			t.Errorf("%s: output differs from DecryptData: %s", tc.fileType, decrypted.Bytes())
+		}
+	}
+}
+
+func TestStreamPublicKeyErrors(t *testing.T) {
+	cases := []struct {
+		fileType FileType
+		in       string
+		err      string
+	}{
+		{FileTypeJSON, `{"a": 1}`, "not present"},
+		{FileTypeJSON, `{"a": "b", "c": 1}`, "not present"},
+		{FileTypeJSON, `{"_public_key": "invalid", "a": "b"}`, "invalid format"},
+		{FileTypeJSON, `{"_public_key": "`, "invalid json"},
+		{FileTypeTOML, "a = 1\n_public_key = \"invalid\"\n", "invalid format"},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		err := EncryptStream(strings.NewReader(tc.in), &out, StreamOptions{FileType: tc.fileType})
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%q: wanted error containing %q, but got %v", tc.in, tc.err, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%q: expected no output before the error, got %q", tc.in, out.Bytes())
+		}
+	}
+}
+
+func TestStreamLatePublicKey(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	cases := []struct {
+		fileType FileType
+		in       string
+		match    *regexp.Regexp
+	}{
+		{
+			FileTypeJSON,
+			`{"a": "b", "_public_key": "` + testPublicKey + `", "c": "d"}`,
+			regexp.MustCompile(`^{"a": "EJ\[.*\]", "_public_key": "8d8.*", "c": "EJ\[.*\]"}$`),
+		},
+		{
+			FileTypeTOML,
+			"a = \"b\"\n_public_key = \"" + testPublicKey + "\"\n",
+			regexp.MustCompile(`^a = "EJ\[.*\]"\n_public_key = "8d8.*"\n$`),
+		},
+	}
+	for _, tc := range cases {
+		var encrypted bytes.Buffer
+		err := EncryptStream(strings.NewReader(tc.in), &encrypted, StreamOptions{FileType: tc.fileType})
+		if err != nil || !tc.match.Match(encrypted.Bytes()) {
+			t.Errorf("%s: unexpected output: %s, %v", tc.fileType, encrypted.Bytes(), err)
+			continue
+		}
+
+		var decrypted bytes.Buffer
+		err = DecryptStream(&encrypted, &decrypted, StreamOptions{FileType: tc.fileType})
+		if err != nil || decrypted.String() != tc.in {
+			t.Errorf("%s: expected %q, got %q, %v", tc.fileType, tc.in, decrypted.Bytes(), err)
+		}
+	}
+
+	// a late key which can't be used still produces no output
+	var out bytes.Buffer
+	in := `{"a": "` + foreignMessage(t) + `", "_public_key": "` + testPublicKey + `"}`
+	if err := DecryptStream(strings.NewReader(in), &out, StreamOptions{FileType: FileTypeJSON}); err == nil || out.Len() > 0 {
+		t.Errorf("expected an error and no output, got %q, %v", out.Bytes(), err)
+	}
+}
+
+// generatedDocument is a reader over a document of n entries, generated as
+// it's read rather than held in memory.
+type generatedDocument struct {
+	header, footer string
+	entry          func(i int) string
+	n, i           int
+	buf            []byte
+}
+
+func (g *generatedDocument) Read(p []byte) (int, error) {
+	for len(g.buf) == 0 {
+		switch {
+		case g.i == 0:
+			g.buf = []byte(g.header)
+		case g.i <= g.n:
+			g.buf = []byte(g.entry(g.i - 1))
+		case g.i == g.n+1:
+			g.buf = []byte(g.footer)
+		default:
+			return 0, io.EOF
+		}
+		g.i++
+	}
+	n := copy(p, g.buf)
+	g.buf = g.buf[n:]
+	return n, nil
+}
+
+// peakHeapWriter discards what's written to it, sampling the size of the heap
+// every so often.
+type peakHeapWriter struct {
+	written, nextSample int
+	peak                uint64
+}
+
+func (w *peakHeapWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written >= w.nextSample {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		if ms.HeapInuse > w.peak {
+			w.peak = ms.HeapInuse
+		}
+		w.nextSample = w.written + 1<<20
+	}
+	return len(p), nil
+}
+
+// BenchmarkEncryptStream encrypts documents of increasing size. The reported
+// peak-heap-MB should stay roughly constant as the documents grow.
+func BenchmarkEncryptStream(b *testing.B) {
+	docs := map[FileType]*generatedDocument{
+		FileTypeJSON: {
+			header: `{"_public_key": "` + testPublicKey + `"`,
+			entry:  func(i int) string { return fmt.Sprintf(",\n  \"key%d\": \"value number %d\"", i, i) },
+			footer: "\n}\n",
+		},
+		FileTypeTOML: {
+			header: `_public_key = "` + testPublicKey + `"` + "\n",
+			entry:  func(i int) string { return fmt.Sprintf("key%d = \"value number %d\"\n", i, i) },
+			footer: "",
+		},
+	}
+	for _, fileType := range []FileType{FileTypeJSON, FileTypeTOML} {
+		for _, n := range []int{10000, 100000} {
+			b.Run(fmt.Sprintf("%s/%d", fileType, n), func(b *testing.B) {
+				var peak uint64
+				for i := 0; i < b.N; i++ {
+					doc := *docs[fileType]
+					doc.n = n
+					w := &peakHeapWriter{}
+					if err := EncryptStream(&doc, w, StreamOptions{FileType: fileType}); err != nil {
+						b.Fatal(err)
+					}
+					if w.peak > peak {
+						peak = w.peak
+					}
+				}
+				b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+			})
+		}
+	}
+}
+
+func TestStreamKeepGoing(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	foreign := foreignMessage(t)
+	cases := []struct {
+		fileType FileType
+		in       string
+		expected string
+	}{
+		{
+			FileTypeJSON,
+			`{"_public_key": "` + testPublicKey + `", "a": "` + foreign + `", "b": "c"}`,
+			`{"_public_key": "` + testPublicKey + `", "a": "?", "b": "c"}`,
+		},
+		{
+			FileTypeYAML, // not streamed
+			"_public_key: " + testPublicKey + "\na: " + foreign + "\nb: c\n",
+			"_public_key: " + testPublicKey + "\na: \"?\"\nb: \"c\"\n",
+		},
+	}
+	for _, tc := range cases {
+		var out bytes.Buffer
+		err := DecryptStream(strings.NewReader(tc.in), &out, StreamOptions{FileType: tc.fileType, KeepGoing: true, Placeholder: "?", AllowUnencrypted: true})
+		var partialErr *PartialDecryptError
+		if !errors.As(err, &partialErr) || len(partialErr.Errors) != 1 || partialErr.Errors[0].Path.String() != "a" {
+			t.Errorf("%s: expected *PartialDecryptError for a, got %v", tc.fileType, err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("%s: unexpected output: %s", tc.fileType, out.Bytes())
+		}
+	}
+}
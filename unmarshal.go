@@ -0,0 +1,151 @@
+package ecfg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/ecfg/pkg/format"
+	ecfgjson "github.com/Shopify/ecfg/pkg/json"
+	"github.com/Shopify/ecfg/pkg/toml"
+	"github.com/Shopify/ecfg/pkg/yaml"
+)
+
+// DecodeError reports a value of a decrypted document which couldn't be
+// decoded into the Go value passed to Unmarshal.
+type DecodeError struct {
+	// Path is the key path of the value, such as `database.password` or
+	// `servers[0].host`. It's empty if the value is the document itself.
+	Path string
+	// Err is the error reported by the decoder.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decoders decode decrypted documents of each type which can be unmarshaled.
+// Each honors the struct tags of its own format: `json`, `yaml` or `toml`.
+var decoders = map[FileType]func(data []byte, v interface{}) error{
+	FileTypeJSON:      decodeJSON,
+	FileTypeJSONC:     decodeJSONC,
+	FileTypeYAML:      decodeYAML,
+	FileTypeK8sSecret: decodeYAML,
+	FileTypeTOML:      decodeTOML,
+}
+
+// Unmarshal decrypts the ecfg document data and decodes it into v, which
+// must be a pointer to a struct or map, using encoding/json for JSON and
+// JSONC documents and the bundled pkg/yaml and pkg/toml decoders for YAML
+// and TOML documents. Struct fields are matched using the tags of the
+// document's format (e.g. `toml:"db_password"`).
+//
+// The private key is found by keys; if keys is nil, it's taken from
+// ECFG_PRIVATE_KEY or looked up in DefaultKeypath. Values which can't be
+// decoded into the corresponding part of v are reported as *DecodeError;
+// when a YAML document has several, they're combined with errors.Join.
+func Unmarshal(data []byte, fileType FileType, keys KeyProvider, v interface{}) error {
+	c := &Client{KeyProvider: keys}
+	return c.Unmarshal(context.Background(), data, fileType, v)
+}
+
+// LoadFile reads the ecfg document at path, whose type is determined as by
+// DetectFileType, and decrypts and decodes it into v as Unmarshal does with
+// a nil KeyProvider.
+func LoadFile(path string, v interface{}) error {
+	return defaultClient.LoadFile(context.Background(), path, v)
+}
+
+// Unmarshal is like the package-level Unmarshal, finding the private key as
+// described on Client.
+func (c *Client) Unmarshal(ctx context.Context, data []byte, fileType FileType, v interface{}) error {
+	if fileType == FileTypeYAML && yaml.IsKubernetesSecret(data) {
+		fileType = FileTypeK8sSecret
+	}
+	decode, ok := decoders[fileType]
+	if !ok {
+		return fmt.Errorf("can't unmarshal %s documents", fileType)
+	}
+	// Marshal leaves the values of fields which aren't tagged secret
+	// unencrypted.
+	uc := *c
+	uc.AllowUnencrypted = true
+	decrypted, err := uc.DecryptData(ctx, data, fileType)
+	if err != nil {
+		return err
+	}
+	return decode(decrypted, v)
+}
+
+// LoadFile is like the package-level LoadFile, reading the file from c.FS and
+// finding the private key as described on Client.
+func (c *Client) LoadFile(ctx context.Context, path string, v interface{}) error {
+	data, err := c.fs().ReadFile(path)
+	if err != nil {
+		return err
+	}
+	fileType, err := DetectFileType(path, data)
+	if err != nil {
+		return err
+	}
+	return withFile(c.Unmarshal(ctx, data, fileType, v), path)
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &DecodeError{Path: typeErr.Field, Err: err}
+	}
+	return err
+}
+
+func decodeJSONC(data []byte, v interface{}) error {
+	standard, err := ecfgjson.Standardize(data)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(standard, v)
+}
+
+func decodeYAML(data []byte, v interface{}) error {
+	err := yaml.Unmarshal(data, v)
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	errs := make([]error, len(typeErr.Errors))
+	for i, msg := range typeErr.Errors {
+		decodeErr := &DecodeError{Err: errors.New(msg)}
+		if i < len(typeErr.Paths) {
+			decodeErr.Path = typeErr.Paths[i]
+		}
+		errs[i] = decodeErr
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+func decodeTOML(data []byte, v interface{}) error {
+	err := toml.Unmarshal(data, v)
+	var decodeErr *toml.DecodeError
+	if !errors.As(err, &decodeErr) {
+		return err
+	}
+	path := make(format.Path, len(decodeErr.Key))
+	for i, k := range decodeErr.Key {
+		path[i] = format.Key(k)
+	}
+	return &DecodeError{Path: path.String(), Err: decodeErr.Err}
+}
@@ -0,0 +1,138 @@
+package ecfg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testConfig struct {
+	Password string `json:"db_password" yaml:"db_password" toml:"db_password"`
+	Servers  []struct {
+		Host string `json:"host" yaml:"host" toml:"host"`
+	} `json:"servers" yaml:"servers" toml:"servers"`
+}
+
+var unmarshalDocuments = map[FileType]string{
+	FileTypeJSON: `{"_public_key": "` + testPublicKey + `", "db_password": "hunter2", "servers": [{"host": "a"}]}`,
+	FileTypeJSONC: `// settings
+{"_public_key": "` + testPublicKey + `", "db_password": "hunter2", "servers": [{"host": "a"},],}`,
+	FileTypeYAML: "_public_key: " + testPublicKey + "\ndb_password: hunter2\nservers:\n  - host: a\n",
+	FileTypeTOML: "_public_key = \"" + testPublicKey + "\"\ndb_password = \"hunter2\"\n[[servers]]\nhost = \"a\"\n",
+}
+
+func TestUnmarshal(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	for fileType, doc := range unmarshalDocuments {
+		encrypted, err := EncryptData([]byte(doc), fileType)
+		assertNoError(t, err)
+
+		var cfg testConfig
+		if err := Unmarshal(encrypted, fileType, nil, &cfg); err != nil {
+			t.Errorf("%s: unexpected error: %v", fileType, err)
+			continue
+		}
+		if cfg.Password != "hunter2" || len(cfg.Servers) != 1 || cfg.Servers[0].Host != "a" {
+			t.Errorf("%s: unexpected result: %+v", fileType, cfg)
+		}
+
+		var m map[string]interface{}
+		if err := Unmarshal(encrypted, fileType, nil, &m); err != nil {
+			t.Errorf("%s: unexpected error: %v", fileType, err)
+		} else if m["db_password"] != "hunter2" {
+			t.Errorf("%s: unexpected result: %v", fileType, m)
+		}
+	}
+}
+
+func TestUnmarshalDecodeErrors(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	// Decrypted values are strings, which can't be decoded as ints.
+	var cfg struct {
+		DB struct {
+			Port int `toml:"port"`
+		} `toml:"db"`
+		Servers []struct {
+			Port int `json:"port" yaml:"port"`
+		} `json:"servers" yaml:"servers"`
+	}
+	cases := []struct {
+		fileType FileType
+		doc      string
+		path     string
+	}{
+		{FileTypeJSON, `{"_public_key": "` + testPublicKey + `", "servers": [{"port": "x"}]}`, "servers.0.port"},
+		{FileTypeYAML, "_public_key: " + testPublicKey + "\nservers:\n  - port: x\n", "servers[0].port"},
+		{FileTypeTOML, "_public_key = \"" + testPublicKey + "\"\n[db]\nport = \"x\"\n", "db.port"},
+	}
+	for _, tc := range cases {
+		encrypted, err := EncryptData([]byte(tc.doc), tc.fileType)
+		assertNoError(t, err)
+		err = Unmarshal(encrypted, tc.fileType, nil, &cfg)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Errorf("%s: expected *DecodeError, got %v", tc.fileType, err)
+		} else if decodeErr.Path != tc.path {
+			t.Errorf("%s: expected path %q, got %q (%v)", tc.fileType, tc.path, decodeErr.Path, err)
+		}
+	}
+
+	err := Unmarshal([]byte("a = b\n"), FileTypeINI, nil, &map[string]string{})
+	if err == nil {
+		t.Errorf("expected error unmarshaling INI")
+	}
+}
+
+func TestUnmarshalKeyProvider(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", "")
+	encrypted, err := EncryptData([]byte(unmarshalDocuments[FileTypeJSON]), FileTypeJSON)
+	assertNoError(t, err)
+
+	providerErr := errors.New("no key")
+	keys := KeyProviderFunc(func(context.Context, [32]byte) ([32]byte, error) {
+		return [32]byte{}, providerErr
+	})
+	var cfg testConfig
+	if err := Unmarshal(encrypted, FileTypeJSON, keys, &cfg); err != providerErr {
+		t.Errorf("expected KeyProvider's error, got %v", err)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	dir := t.TempDir()
+	for name, doc := range map[string]string{
+		"secrets.ejson":     unmarshalDocuments[FileTypeJSON],
+		"secrets.ecfg.toml": unmarshalDocuments[FileTypeTOML],
+		"secrets":           unmarshalDocuments[FileTypeYAML],
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := EncryptFileInPlace(path, mustDetect(t, path, doc)); err != nil {
+			t.Fatal(err)
+		}
+
+		var cfg testConfig
+		if err := LoadFile(path, &cfg); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if cfg.Password != "hunter2" {
+			t.Errorf("%s: unexpected result: %+v", name, cfg)
+		}
+	}
+
+	if err := LoadFile(filepath.Join(dir, "missing.ejson"), &testConfig{}); !os.IsNotExist(err) {
+		t.Errorf("expected IsNotExist, got %v", err)
+	}
+}
+
+func mustDetect(t *testing.T, path, doc string) FileType {
+	fileType, err := DetectFileType(path, []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fileType
+}
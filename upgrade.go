@@ -0,0 +1,179 @@
+package ecfg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+	"github.com/Shopify/ecfg/pkg/format"
+)
+
+// UpgradeFileInPlace upgrades the values of the ecfg document at filePath, as
+// UpgradeData does, and writes the result over it if any were upgraded. It
+// returns the number of values upgraded.
+func UpgradeFileInPlace(filePath string, keypath []string, fileType FileType, toVersion int) (int, error) {
+	return keypathClient(keypath, nil).UpgradeFileInPlace(context.Background(), filePath, fileType, toVersion)
+}
+
+// UpgradeData re-encrypts each value of the ecfg document data whose schema
+// version is below toVersion, decrypting it with a private key found in
+// keypath and encrypting it in toVersion to the document's public key. It
+// returns the upgraded document and the number of values upgraded. Other
+// values, including those which aren't encrypted, are left byte-identical.
+//
+// If toVersion is zero, each value is upgraded to the version EncryptData
+// would encrypt it in: 3 for documents with a hybrid key, otherwise 2 for
+// values whose key path the format handler reports, and 1 for others.
+//
+// Upgrading changes values, so signed documents must be signed again.
+func UpgradeData(data []byte, keypath []string, fileType FileType, toVersion int) ([]byte, int, error) {
+	return keypathClient(keypath, nil).UpgradeData(context.Background(), data, fileType, toVersion)
+}
+
+// UpgradeFileInPlace is like the package-level UpgradeFileInPlace, searching
+// for the private key as described on Client.
+func (c *Client) UpgradeFileInPlace(ctx context.Context, filePath string, fileType FileType, toVersion int) (int, error) {
+	data, err := c.fs().ReadFile(filePath)
+	if err != nil {
+		return -1, err
+	}
+
+	fi, err := c.fs().Stat(filePath)
+	if err != nil {
+		return -1, err
+	}
+
+	newdata, n, err := c.UpgradeData(ctx, data, fileType, toVersion)
+	if err != nil {
+		return -1, withFile(err, filePath)
+	}
+	if n == 0 {
+		return 0, nil
+	}
+
+	if err := c.fs().WriteFile(filePath, newdata, fi.Mode()); err != nil {
+		return -1, err
+	}
+
+	return n, nil
+}
+
+// UpgradeData is like the package-level UpgradeData, searching for the
+// private key as described on Client.
+func (c *Client) UpgradeData(ctx context.Context, data []byte, fileType FileType, toVersion int) ([]byte, int, error) {
+	if toVersion != 0 && !supportedVersion(toVersion) {
+		return nil, 0, fmt.Errorf("can't upgrade to schema version %d: supported versions are %v", toVersion, crypto.SchemaVersions())
+	}
+
+	fh, err := c.handlerForDocument(fileType, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	fh = c.encryptHandler(fh)
+
+	pubkey, err := extractPublicKey(fh, data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	encrypter, err := c.encrypter(pubkey)
+	if err != nil {
+		return nil, 0, err
+	}
+	encrypter.Version = toVersion
+	walker, bound := fh.(format.ScalarValueWalker)
+	if err := encrypter.CheckVersion(bound); err != nil {
+		if !bound && encrypter.CheckVersion(true) == nil {
+			return nil, 0, fmt.Errorf("can't upgrade %s documents to schema version %d: the key paths of their values aren't known", fileType, toVersion)
+		}
+		return nil, 0, fmt.Errorf("can't upgrade to schema version %d: %w", toVersion, err)
+	}
+
+	decrypter, err := c.decrypter(ctx, pubkey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	u := &upgrader{encrypter: encrypter, decrypter: decrypter}
+	var out []byte
+	if bound {
+		out, err = walker.WalkScalarValues(data, func(v format.ScalarValue) ([]byte, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			out, err := u.upgrade(v.Value, binding(v.Path))
+			if err != nil {
+				return nil, &ValueError{Path: v.Path, Line: v.Line, Column: v.Column, Err: err}
+			}
+			return out, nil
+		})
+		err = contextErr(ctx, err)
+	} else {
+		out, err = transformContext(ctx, fh, data, func(bs, binding []byte) ([]byte, error) {
+			out, err := u.upgrade(bs, binding)
+			if out == nil && err == nil {
+				out = bs
+			}
+			return out, err
+		})
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, u.count(), nil
+}
+
+// supportedVersion reports whether version can be encrypted and decrypted.
+func supportedVersion(version int) bool {
+	for _, v := range crypto.SchemaVersions() {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// upgrader re-encrypts values in a newer schema version. Values may be
+// upgraded concurrently.
+type upgrader struct {
+	encrypter *crypto.Encrypter
+	decrypter *crypto.Decrypter
+
+	mu sync.Mutex
+	n  int
+}
+
+// upgrade returns value, bound to binding, re-encrypted in the target schema
+// version, or nil if it's unencrypted or already of that version or later.
+func (u *upgrader) upgrade(value, binding []byte) ([]byte, error) {
+	version, ok := crypto.MessageVersion(value)
+	if !ok || version >= u.encrypter.VersionFor(binding) {
+		return nil, nil
+	}
+
+	plaintext, err := u.decrypter.DecryptBound(value, binding)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := crypto.MessageVersion(plaintext); ok {
+		// EncryptBound would leave it as it is, in plaintext.
+		return nil, errors.New("can't re-encrypt a value which is itself an encrypted message")
+	}
+	out, err := u.encrypter.EncryptBound(plaintext, binding)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	u.n++
+	u.mu.Unlock()
+	return out, nil
+}
+
+func (u *upgrader) count() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.n
+}
@@ -0,0 +1,144 @@
+package ecfg
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/ecfg/pkg/crypto"
+)
+
+// v1Message returns plaintext encrypted to pubkey, a hex-encoded key, in
+// schema version 1.
+func v1Message(t *testing.T, pubkey, plaintext string) string {
+	var kp crypto.Keypair
+	if err := kp.Generate(); err != nil {
+		t.Fatal(err)
+	}
+	var peer [32]byte
+	bs, err := hex.DecodeString(pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(peer[:], bs)
+	msg, err := kp.Encrypter(peer).Encrypt([]byte(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func TestUpgradeData(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	encrypted, err := EncryptData([]byte(`{"_public_key": "`+testPublicKey+`", "b": "y"}`), FileTypeJSON)
+	assertNoError(t, err)
+	current := regexp.MustCompile(`EJ\[2:[^"]*\]`).FindString(string(encrypted))
+
+	doc := `{"_public_key": "` + testPublicKey + `", "a": "` + v1Message(t, testPublicKey, "x") + `", "b": "` + current + `", "c": "z"}`
+	out, n, err := UpgradeData([]byte(doc), nil, FileTypeJSON, 0)
+	assertNoError(t, err)
+	if n != 1 || !strings.Contains(string(out), `"a": "EJ[2:`) || !strings.Contains(string(out), current) || !strings.Contains(string(out), `"c": "z"`) {
+		t.Errorf("expected only a to be upgraded, got %d:\n%s", n, out)
+	}
+	decrypted, err := DecryptData(out, nil, FileTypeJSON, AllowUnencrypted())
+	if want := `{"_public_key": "` + testPublicKey + `", "a": "x", "b": "y", "c": "z"}`; err != nil || string(decrypted) != want {
+		t.Errorf("expected %s, got %s, %v", want, decrypted, err)
+	}
+
+	again, n, err := UpgradeData(out, nil, FileTypeJSON, 0)
+	if err != nil || n != 0 || string(again) != string(out) {
+		t.Errorf("expected nothing to upgrade, got %d, %v", n, err)
+	}
+
+	// values which aren't upgraded are left as written
+	yamlDoc := "_public_key: " + testPublicKey + "\na: " + v1Message(t, testPublicKey, "x") + "\nb: plain\n"
+	out, n, err = UpgradeData([]byte(yamlDoc), nil, FileTypeYAML, 2)
+	assertNoError(t, err)
+	if n != 1 || !strings.HasSuffix(string(out), "\nb: plain\n") {
+		t.Errorf("expected b to be unchanged, got %d:\n%s", n, out)
+	}
+
+	// formats which don't report key paths have nothing newer than version 1
+	iniDoc := "_public_key = " + testPublicKey + "\na = " + v1Message(t, testPublicKey, "x") + "\n"
+	if out, n, err := UpgradeData([]byte(iniDoc), nil, FileTypeINI, 0); err != nil || n != 0 || string(out) != iniDoc {
+		t.Errorf("expected nothing to upgrade, got %d, %v:\n%s", n, err, out)
+	}
+}
+
+func TestUpgradeErrors(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	doc := []byte(`{"_public_key": "` + testPublicKey + `", "a": "` + v1Message(t, testPublicKey, "x") + `"}`)
+	if _, _, err := UpgradeData(doc, nil, FileTypeJSON, 9); err == nil || !strings.Contains(err.Error(), "schema version 9") {
+		t.Errorf("expected unsupported version error, got %v", err)
+	}
+	var valueErr *ValueError
+	if _, _, err := UpgradeData(doc, nil, FileTypeJSON, 3); err == nil || errors.As(err, &valueErr) || !strings.Contains(err.Error(), "requires a hybrid public key") {
+		t.Errorf("expected error upgrading to version 3 without a hybrid key, got %v", err)
+	}
+	iniDoc := []byte("_public_key = " + testPublicKey + "\na = " + v1Message(t, testPublicKey, "x") + "\n")
+	if _, _, err := UpgradeData(iniDoc, nil, FileTypeINI, 2); err == nil || errors.As(err, &valueErr) || !strings.Contains(err.Error(), "key paths of their values aren't known") {
+		t.Errorf("expected error upgrading ini document to version 2, got %v", err)
+	}
+	if _, _, err := UpgradeData(iniDoc, nil, FileTypeINI, 3); err == nil || !strings.Contains(err.Error(), "requires a hybrid public key") {
+		t.Errorf("expected error upgrading ini document to version 3, got %v", err)
+	}
+
+	// the private key isn't needed to find that the version is impossible
+	t.Setenv("ECFG_PRIVATE_KEY", "")
+	if _, _, err := UpgradeData(doc, []string{t.TempDir()}, FileTypeJSON, 3); err == nil || !strings.Contains(err.Error(), "requires a hybrid public key") {
+		t.Errorf("expected version to be checked first, got %v", err)
+	}
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	if _, _, err := UpgradeData([]byte(`{"_public_key": "`+testPublicKey+`", "a": "`+foreignMessage(t)+`"}`), nil, FileTypeJSON, 0); err == nil {
+		t.Errorf("expected error upgrading a value which can't be decrypted")
+	}
+}
+
+func TestUpgradeHybrid(t *testing.T) {
+	pub, priv, err := GenerateHybridKeypair()
+	assertNoError(t, err)
+	t.Setenv("ECFG_PRIVATE_KEY", priv)
+	id, err := PublicKeyID(pub)
+	assertNoError(t, err)
+
+	doc := `{"_public_key": "` + pub + `", "a": "` + v1Message(t, id, "x") + `"}`
+	for _, version := range []int{1, 2} {
+		if _, _, err := UpgradeData([]byte(doc), nil, FileTypeJSON, version); err == nil || !strings.Contains(err.Error(), "hybrid public key") {
+			t.Errorf("%d: expected error downgrading a hybrid key, got %v", version, err)
+		}
+	}
+	out, n, err := UpgradeData([]byte(doc), nil, FileTypeJSON, 0)
+	assertNoError(t, err)
+	if n != 1 || !strings.Contains(string(out), `"a": "EJ[3:`) {
+		t.Errorf("expected a to be upgraded to version 3, got %d:\n%s", n, out)
+	}
+	if decrypted, err := DecryptData(out, nil, FileTypeJSON); err != nil || !strings.Contains(string(decrypted), `"a": "x"`) {
+		t.Errorf("unexpected result: %s, %v", decrypted, err)
+	}
+}
+
+func TestUpgradeFileInPlace(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	path := filepath.Join(t.TempDir(), "secrets.ejson")
+	doc := `{"_public_key": "` + testPublicKey + `", "a": "` + v1Message(t, testPublicKey, "x") + `"}`
+	if err := os.WriteFile(path, []byte(doc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := UpgradeFileInPlace(path, nil, FileTypeJSON, 0)
+	if err != nil || n != 1 {
+		t.Fatalf("expected one value to be upgraded, got %d, %v", n, err)
+	}
+	data, err := os.ReadFile(path)
+	assertNoError(t, err)
+	if !strings.Contains(string(data), "EJ[2:") {
+		t.Errorf("expected file to be rewritten, got\n%s", data)
+	}
+	if n, err := UpgradeFileInPlace(path, nil, FileTypeJSON, 0); err != nil || n != 0 {
+		t.Errorf("expected nothing to upgrade, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,301 @@
+package ecfg
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWatchInterval = time.Second
+	defaultWatchDebounce = 250 * time.Millisecond
+)
+
+// WatchEvent reports the outcome of reloading a watched document.
+type WatchEvent struct {
+	// Value is the value decoded from the new version of the document or,
+	// if Err is set, the last value which was decoded successfully.
+	Value interface{}
+	// Err is set if the new version of the document couldn't be read,
+	// decrypted or decoded.
+	Err error
+}
+
+// Watcher keeps a Go value up to date with an ecfg document, so that
+// long-running services pick up new secrets without restarting. On Linux,
+// the directory containing the file (and that containing its target, if it's
+// a symlink) is watched with inotify, so that changes are noticed at once.
+// Elsewhere, if inotify can't be used, or if the Client has its own FS, the
+// file is instead polled for changes to its modification time or size
+// (following symlinks, so it works with Kubernetes Secret volumes). Once the
+// file has stopped changing for the Debounce period, it's decrypted and
+// decoded into a new value as LoadFile would. If that fails, the last good
+// value is kept and the error is reported instead.
+//
+// Interval and Debounce may only be changed before Run is called.
+type Watcher struct {
+	// Interval is how often the file is polled, when it isn't watched with
+	// inotify. If zero, it's polled every second.
+	Interval time.Duration
+	// Debounce is how long the file must go unchanged before it's reloaded.
+	// If zero, it's 250ms.
+	Debounce time.Duration
+
+	client   *Client
+	path     string
+	newValue func() interface{}
+
+	mu     sync.Mutex
+	value  interface{}
+	err    error
+	stamp  fileStamp
+	read   []byte
+	subs   []func(WatchEvent)
+	chans  []chan WatchEvent
+	closed bool
+}
+
+// notifier reports possible changes to a file, on platforms which support
+// it; see newNotifier.
+type notifier interface {
+	// Changes receives a value whenever the file may have changed. It's
+	// closed if notification fails, after which the file must be polled.
+	Changes() <-chan struct{}
+	Close() error
+}
+
+// fileStamp identifies a version of a file for the purpose of polling.
+type fileStamp struct {
+	modTime int64
+	size    int64
+	missing bool
+}
+
+// NewWatcher loads the ecfg document at path into the value returned by
+// newValue, which must be a new pointer each time it's called (e.g.
+// `func() interface{} { return new(Config) }`), and returns a Watcher which
+// reloads it into another such value whenever it changes. It fails if the
+// document can't be loaded initially. Call Run to start watching.
+func NewWatcher(path string, newValue func() interface{}) (*Watcher, error) {
+	return defaultClient.NewWatcher(context.Background(), path, newValue)
+}
+
+// NewWatcher is like the package-level NewWatcher, reading the file from
+// c.FS and finding the private key as described on Client.
+func (c *Client) NewWatcher(ctx context.Context, path string, newValue func() interface{}) (*Watcher, error) {
+	w := &Watcher{client: c, path: path, newValue: newValue}
+	w.stamp = w.statFile()
+	data, err := c.fs().ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	v, err := w.decode(ctx, data)
+	if err != nil {
+		return nil, err
+	}
+	w.value, w.read = v, data
+	return w, nil
+}
+
+// Value returns the last value which was decoded successfully.
+func (w *Watcher) Value() interface{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.value
+}
+
+// Err returns the error from the last reload, or nil if it succeeded.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Subscribe arranges for fn to be called with the outcome of each reload.
+// Calls are made in order from the goroutine running Run, which waits for
+// them to return.
+func (w *Watcher) Subscribe(fn func(WatchEvent)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subs = append(w.subs, fn)
+}
+
+// Updates returns a channel which receives the outcome of each reload. If an
+// event hasn't been received by the time the next is sent, it's replaced, so
+// that slow receivers only see the latest one. The channel is closed when Run
+// returns.
+func (w *Watcher) Updates() <-chan WatchEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ch := make(chan WatchEvent, 1)
+	if w.closed {
+		close(ch)
+		return ch
+	}
+	w.chans = append(w.chans, ch)
+	return ch
+}
+
+// Run watches the file until ctx is done, reloading it when it changes, and
+// returns ctx.Err(). It must only be called once.
+func (w *Watcher) Run(ctx context.Context) error {
+	defer w.close()
+
+	interval, debounce := w.Interval, w.Debounce
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	if debounce <= 0 {
+		debounce = defaultWatchDebounce
+	}
+
+	var (
+		changes <-chan struct{}
+		ticker  *time.Ticker
+		ticks   <-chan time.Time
+		timer   *time.Timer
+		fire    <-chan time.Time
+	)
+	if w.client.FS == nil {
+		if n, err := newNotifier(w.path); err == nil {
+			defer n.Close()
+			changes = n.Changes()
+		}
+	}
+	poll := func() {
+		ticker = time.NewTicker(interval)
+		ticks = ticker.C
+	}
+	if changes == nil {
+		poll()
+	}
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	last := w.stamp
+	// changed starts or restarts the wait before reloading if the file has
+	// changed. If notified is set, the file may have changed without its
+	// modification time or size doing so, so the wait is started if it
+	// isn't already under way, but not restarted: only a change which can
+	// be seen does that, so that a stream of notifications can't postpone
+	// reloading forever.
+	changed := func(notified bool) {
+		if stamp := w.statFile(); stamp != last || notified && fire == nil {
+			last = stamp
+			if timer == nil {
+				timer = time.NewTimer(debounce)
+			} else {
+				timer.Reset(debounce)
+			}
+			fire = timer.C
+		}
+	}
+	// Catch changes made since NewWatcher, before the file was being watched.
+	changed(false)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticks:
+			changed(false)
+		case _, ok := <-changes:
+			if !ok {
+				changes = nil
+				poll()
+				continue
+			}
+			changed(true)
+		case <-fire:
+			// Changes made since the last poll restart the wait.
+			if stamp := w.statFile(); stamp != last {
+				last = stamp
+				timer.Reset(debounce)
+				continue
+			}
+			fire = nil
+			w.reload(ctx)
+		}
+	}
+}
+
+func (w *Watcher) statFile() fileStamp {
+	fi, err := w.client.fs().Stat(w.path)
+	if err != nil {
+		return fileStamp{missing: true}
+	}
+	return fileStamp{modTime: fi.ModTime().UnixNano(), size: fi.Size()}
+}
+
+func (w *Watcher) decode(ctx context.Context, data []byte) (interface{}, error) {
+	fileType, err := DetectFileType(w.path, data)
+	if err != nil {
+		return nil, err
+	}
+	v := w.newValue()
+	if err := w.client.Unmarshal(ctx, data, fileType, v); err != nil {
+		return nil, withFile(err, w.path)
+	}
+	return v, nil
+}
+
+// reload reads, decrypts and decodes the file, unless its contents are the
+// same as when it was last read, and publishes the outcome.
+func (w *Watcher) reload(ctx context.Context) {
+	data, err := w.client.fs().ReadFile(w.path)
+	if err == nil && bytes.Equal(data, w.read) {
+		return
+	}
+	w.read = data
+
+	var v interface{}
+	if err == nil {
+		v, err = w.decode(ctx, data)
+	}
+	if ctx.Err() != nil {
+		// Run is returning; the document wasn't necessarily at fault.
+		return
+	}
+
+	w.mu.Lock()
+	if err == nil {
+		w.value = v
+	}
+	w.err = err
+	ev := WatchEvent{Value: w.value, Err: err}
+	subs := append(w.subs[:0:0], w.subs...)
+	chans := append(w.chans[:0:0], w.chans...)
+	w.mu.Unlock()
+
+	for _, fn := range subs {
+		fn(ev)
+	}
+	for _, ch := range chans {
+		select {
+		case ch <- ev:
+		default:
+			// Replace the event which hasn't been received.
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- ev
+		}
+	}
+}
+
+func (w *Watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	for _, ch := range w.chans {
+		close(ch)
+	}
+	w.chans = nil
+}
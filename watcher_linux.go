@@ -0,0 +1,112 @@
+package ecfg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events which may mean that a watched file has
+// changed, including it being replaced by a rename or, as in Kubernetes
+// Secret volumes, by a symlink in its directory being swapped.
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyNotifier watches the directories containing a file, and its target
+// if it's a symlink, with inotify. Only events for the entries through which
+// the file is reached are reported: the file itself, the first component of
+// its symlink's target (such as the ..data symlink of a Kubernetes Secret
+// volume), and the target itself.
+type inotifyNotifier struct {
+	f       *os.File
+	names   map[int32]map[string]bool // by watch descriptor
+	changes chan struct{}
+}
+
+func newNotifier(path string) (notifier, error) {
+	dirs := map[string]map[string]bool{}
+	watch := func(p string) {
+		dir := filepath.Dir(p)
+		if dirs[dir] == nil {
+			dirs[dir] = map[string]bool{}
+		}
+		dirs[dir][filepath.Base(p)] = true
+	}
+	watch(path)
+	if link, err := os.Readlink(path); err == nil && !filepath.IsAbs(link) {
+		first := strings.SplitN(filepath.ToSlash(link), "/", 2)[0]
+		watch(filepath.Join(filepath.Dir(path), first))
+	}
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		watch(target)
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// Being non-blocking, the descriptor is handled by the runtime poller,
+	// so that Close interrupts a pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	n := &inotifyNotifier{f: f, names: map[int32]map[string]bool{}, changes: make(chan struct{}, 1)}
+	for dir, names := range dirs {
+		wd, err := syscall.InotifyAddWatch(fd, dir, inotifyMask)
+		if err != nil {
+			f.Close()
+			return nil, &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+		}
+		n.names[int32(wd)] = names
+	}
+
+	go n.read()
+	return n, nil
+}
+
+func (n *inotifyNotifier) read() {
+	defer close(n.changes)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		r, err := n.f.Read(buf)
+		if err != nil {
+			return
+		}
+		if !n.relevant(buf[:r]) {
+			continue
+		}
+		select {
+		case n.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// relevant reports whether any of the events in buf concern the watched
+// file, rather than its neighbours.
+func (n *inotifyNotifier) relevant(buf []byte) bool {
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[0]))
+		end := syscall.SizeofInotifyEvent + int(ev.Len)
+		if end > len(buf) {
+			return true
+		}
+		name := string(bytes.TrimRight(buf[syscall.SizeofInotifyEvent:end], "\x00"))
+		if name == "" || n.names[ev.Wd][name] || ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+			// events without a name concern the directory itself
+			return true
+		}
+		buf = buf[end:]
+	}
+	return false
+}
+
+func (n *inotifyNotifier) Changes() <-chan struct{} {
+	return n.changes
+}
+
+func (n *inotifyNotifier) Close() error {
+	return n.f.Close()
+}
@@ -0,0 +1,106 @@
+package ecfg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherInotify(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.ejson")
+	writeWatched(t, path, watchedDocument("one"), 1)
+
+	w, err := NewWatcher(path, func() interface{} { return new(watchedConfig) })
+	assertNoError(t, err)
+	w.Interval, w.Debounce = time.Hour, 10*time.Millisecond
+	updates := w.Updates()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	time.Sleep(50 * time.Millisecond) // let Run start watching
+
+	// Neither the modification time nor the size changes, so only inotify
+	// can notice.
+	writeWatched(t, path, watchedDocument("two"), 1)
+	if ev := receiveEvent(t, updates); ev.Err != nil || ev.Value.(*watchedConfig).Password != "two" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestWatcherInotifySymlinkSwap(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	// The layout of a Kubernetes Secret volume, which is updated by
+	// replacing the ..data symlink.
+	dir := t.TempDir()
+	for _, version := range []string{"..v1", "..v2"} {
+		if err := os.Mkdir(filepath.Join(dir, version), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeWatched(t, filepath.Join(dir, "..v1", "secrets.ejson"), watchedDocument("one"), 1)
+	writeWatched(t, filepath.Join(dir, "..v2", "secrets.ejson"), watchedDocument("two"), 2)
+	if err := os.Symlink("..v1", filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "secrets.ejson")
+	if err := os.Symlink(filepath.Join("..data", "secrets.ejson"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := NewWatcher(path, func() interface{} { return new(watchedConfig) })
+	assertNoError(t, err)
+	w.Interval, w.Debounce = time.Hour, 10*time.Millisecond
+	updates := w.Updates()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	time.Sleep(50 * time.Millisecond) // let Run start watching
+
+	if err := os.Symlink("..v2", filepath.Join(dir, "..data_tmp")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	if ev := receiveEvent(t, updates); ev.Err != nil || ev.Value.(*watchedConfig).Password != "two" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
+
+func TestWatcherInotifyBusyNeighbour(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.ejson")
+	writeWatched(t, path, watchedDocument("one"), 1)
+
+	w, err := NewWatcher(path, func() interface{} { return new(watchedConfig) })
+	assertNoError(t, err)
+	w.Interval, w.Debounce = time.Hour, 20*time.Millisecond
+	updates := w.Updates()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+	time.Sleep(50 * time.Millisecond) // let Run start watching
+
+	// A file beside the watched one, such as a log, is written more often
+	// than the debounce interval for as long as the test runs.
+	go func() {
+		neighbour := filepath.Join(dir, "app.log")
+		for ctx.Err() == nil {
+			os.WriteFile(neighbour, []byte(time.Now().String()), 0600)
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	writeWatched(t, path, watchedDocument("two"), 1)
+	if ev := receiveEvent(t, updates); ev.Err != nil || ev.Value.(*watchedConfig).Password != "two" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package ecfg
+
+import "errors"
+
+func newNotifier(path string) (notifier, error) {
+	return nil, errors.New("watching files for changes isn't supported on this platform")
+}
@@ -0,0 +1,165 @@
+package ecfg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type watchedConfig struct {
+	Password string `json:"password"`
+}
+
+// writeWatched writes an encrypted document to path, with a modification
+// time which differs from that of any previous version.
+func writeWatched(t *testing.T, path, doc string, version int) {
+	t.Helper()
+	data, err := EncryptData([]byte(doc), FileTypeJSON)
+	assertNoError(t, err)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1e9+int64(version), 0)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func watchedDocument(password string) string {
+	return `{"_public_key": "` + testPublicKey + `", "password": "` + password + `"}`
+}
+
+func receiveEvent(t *testing.T, ch <-chan WatchEvent) WatchEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for reload")
+		return WatchEvent{}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	path := filepath.Join(t.TempDir(), "secrets.ejson")
+	writeWatched(t, path, watchedDocument("one"), 1)
+
+	w, err := NewWatcher(path, func() interface{} { return new(watchedConfig) })
+	assertNoError(t, err)
+	if cfg := w.Value().(*watchedConfig); cfg.Password != "one" {
+		t.Fatalf("unexpected initial value: %+v", cfg)
+	}
+	w.Interval, w.Debounce = 5*time.Millisecond, 10*time.Millisecond
+
+	called := make(chan WatchEvent, 10)
+	w.Subscribe(func(ev WatchEvent) { called <- ev })
+	updates := w.Updates()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	writeWatched(t, path, watchedDocument("two"), 2)
+	for _, ch := range []<-chan WatchEvent{called, updates} {
+		ev := receiveEvent(t, ch)
+		if ev.Err != nil || ev.Value.(*watchedConfig).Password != "two" {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	}
+
+	// A document which can't be decoded keeps the last good value.
+	writeWatched(t, path, `{"_public_key": "`+testPublicKey+`", "password": ["two"]}`, 3)
+	for _, ch := range []<-chan WatchEvent{called, updates} {
+		ev := receiveEvent(t, ch)
+		if ev.Err == nil || ev.Value.(*watchedConfig).Password != "two" {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	}
+	if w.Err() == nil || w.Value().(*watchedConfig).Password != "two" {
+		t.Errorf("expected last good value and error, got %+v, %v", w.Value(), w.Err())
+	}
+
+	writeWatched(t, path, watchedDocument("three"), 4)
+	if ev := receiveEvent(t, updates); ev.Err != nil || ev.Value.(*watchedConfig).Password != "three" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if w.Err() != nil {
+		t.Errorf("expected error to be cleared, got %v", w.Err())
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected Run to return context.Canceled, got %v", err)
+	}
+	if _, ok := <-updates; ok {
+		t.Errorf("expected Updates channel to be closed")
+	}
+	if _, ok := <-w.Updates(); ok {
+		t.Errorf("expected Updates channel to be closed after Run returns")
+	}
+}
+
+func TestWatcherDebounce(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	path := filepath.Join(t.TempDir(), "secrets.ejson")
+	writeWatched(t, path, watchedDocument("0"), 0)
+
+	w, err := NewWatcher(path, func() interface{} { return new(watchedConfig) })
+	assertNoError(t, err)
+	w.Interval, w.Debounce = time.Millisecond, time.Hour
+
+	var reloads int
+	w.Subscribe(func(WatchEvent) { reloads++ })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	writeWatched(t, path, watchedDocument("1"), 1)
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	<-done
+	if reloads != 0 {
+		t.Errorf("expected no reloads before the debounce period, got %d", reloads)
+	}
+}
+
+func TestNewWatcherErrors(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	dir := t.TempDir()
+	newValue := func() interface{} { return new(watchedConfig) }
+
+	if _, err := NewWatcher(filepath.Join(dir, "missing.ejson"), newValue); !os.IsNotExist(err) {
+		t.Errorf("expected IsNotExist, got %v", err)
+	}
+
+	path := filepath.Join(dir, "secrets.ejson")
+	writeWatched(t, path, `{"_public_key": "`+testPublicKey+`", "password": ["x"]}`, 1)
+	if _, err := NewWatcher(path, newValue); err == nil {
+		t.Errorf("expected error decoding document")
+	}
+}
+
+func TestWatcherPolling(t *testing.T) {
+	t.Setenv("ECFG_PRIVATE_KEY", testPrivateKey)
+	path := filepath.Join(t.TempDir(), "secrets.ejson")
+	writeWatched(t, path, watchedDocument("one"), 1)
+
+	// Clients with their own FS are always polled.
+	c := &Client{FS: osFS{}}
+	w, err := c.NewWatcher(context.Background(), path, func() interface{} { return new(watchedConfig) })
+	assertNoError(t, err)
+	w.Interval, w.Debounce = 5*time.Millisecond, 10*time.Millisecond
+	updates := w.Updates()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go w.Run(ctx)
+
+	writeWatched(t, path, watchedDocument("two"), 2)
+	if ev := receiveEvent(t, updates); ev.Err != nil || ev.Value.(*watchedConfig).Password != "two" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+}